@@ -0,0 +1,539 @@
+// Package client is a typed Go client for the perfkit HTTP API, for tools
+// that push profiles into perfkit or read them back in pipelines.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// Profile is a stored profile as returned by the API
+type Profile = models.Profile
+
+// SessionHealth is a session's capture freshness
+type SessionHealth = models.SessionHealth
+
+// SessionSummary is a session's per-type metric rollup
+type SessionSummary = models.SessionSummary
+
+// Percentiles is the distribution of a metric across a session
+type Percentiles = models.Percentiles
+
+// ProfileType identifies the kind of profile (cpu, heap, k6, ...)
+type ProfileType = models.ProfileType
+
+// Profile types the server knows without configuration
+const (
+	ProfileTypeCPU          = models.ProfileTypeCPU
+	ProfileTypeHeap         = models.ProfileTypeHeap
+	ProfileTypeAllocs       = models.ProfileTypeAllocs
+	ProfileTypeGoroutine    = models.ProfileTypeGoroutine
+	ProfileTypeBlock        = models.ProfileTypeBlock
+	ProfileTypeMutex        = models.ProfileTypeMutex
+	ProfileTypeThreadCreate = models.ProfileTypeThreadCreate
+	ProfileTypeWall         = models.ProfileTypeWall
+	ProfileTypeGC           = models.ProfileTypeGC
+	ProfileTypeRuntime      = models.ProfileTypeRuntime
+	ProfileTypeTrace        = models.ProfileTypeTrace
+	ProfileTypeK6           = models.ProfileTypeK6
+)
+
+// IngestPath returns the server ingest route for a profile type
+func IngestPath(pt ProfileType) string {
+	switch pt {
+	case ProfileTypeK6:
+		return "/api/k6/ingest"
+	case ProfileTypeRuntime:
+		return "/api/runtime/ingest"
+	case ProfileTypeTrace:
+		return "/api/trace/ingest"
+	default:
+		return "/api/pprof/ingest"
+	}
+}
+
+// ActivityEvent is one entry in the server's activity log
+type ActivityEvent = models.ActivityEvent
+
+// Client talks to a perfkit server
+type Client struct {
+	// BaseURL is the server address, e.g. http://localhost:8080
+	BaseURL string
+	// Token is sent as a bearer token, for servers behind an auth proxy
+	Token string
+	// MaxRetries is how many times a request is retried on network errors
+	// and 429/502/503/504 responses, except a 429 for a full session, which
+	// stays full until profiles are deleted. Only requests safe to repeat
+	// are retried: reads and deletes, and ingests with
+	// IngestOptions.ContentID, which the server stores once however often
+	// they're sent.
+	MaxRetries int
+	// RetryDelay is the backoff before the first retry; it doubles each time
+	RetryDelay time.Duration
+
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		MaxRetries: 2,
+		RetryDelay: 500 * time.Millisecond,
+		HTTPClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// APIError is a non-2xx response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("perfkit: status %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IngestOptions is the metadata stored with an ingested profile. Empty
+// fields fall back to the server's defaults.
+type IngestOptions struct {
+	Type       ProfileType
+	Name       string
+	Session    string
+	Project    string
+	Host       string
+	Source     string
+	RunID      string
+	Tags       []string
+	Cumulative bool
+	CreatedAt  time.Time
+	// ProfileTime is when the profile was captured, if not now
+	ProfileTime time.Time
+	// Window is the monitoring window a snapshot profile stands for, e.g.
+	// the length of the soak test before a heap capture
+	Window time.Duration
+	// Force stores a profile as Type when it looks like the other of heap
+	// and allocs, which the server otherwise rejects
+	Force bool
+	// ContentID derives the profile's ID from its data, type, project and
+	// session, so re-ingesting the same data stores nothing new
+	ContentID bool
+}
+
+// IngestResult is the server's reply to an ingest
+type IngestResult struct {
+	ID string `json:"id"`
+	// Warning is a non-fatal note, e.g. an empty profile
+	Warning string `json:"warning"`
+	// Anomaly is set when the profile's headline metric was an outlier
+	// against its session's history; AnomalyReason explains by how much
+	Anomaly       bool   `json:"anomaly"`
+	AnomalyReason string `json:"anomaly_reason"`
+	// TypeMismatch is set when a forced profile looked like another type
+	TypeMismatch bool `json:"type_mismatch"`
+	// Duplicate is set when a ContentID ingest matched a stored profile,
+	// which was left as is
+	Duplicate bool `json:"duplicate"`
+}
+
+// Ingest uploads a pprof profile, k6 summary, or runtime metrics snapshot.
+// opts.Type picks the ingest route; pprof types are detected by the server
+// when empty.
+func (c *Client) Ingest(ctx context.Context, data []byte, opts IngestOptions) (*IngestResult, error) {
+	q := url.Values{}
+	if opts.Type != "" {
+		q.Set("type", string(opts.Type))
+	}
+	if opts.Name != "" {
+		q.Set("name", opts.Name)
+	}
+	if opts.Session != "" {
+		q.Set("session", opts.Session)
+	}
+	if opts.Project != "" {
+		q.Set("project", opts.Project)
+	}
+	if opts.Host != "" {
+		q.Set("host", opts.Host)
+	}
+	if opts.Source != "" {
+		q.Set("source", opts.Source)
+	}
+	if opts.RunID != "" {
+		q.Set("run_id", opts.RunID)
+	}
+	if opts.Cumulative {
+		q.Set("cumulative", "true")
+	}
+	if opts.Force {
+		q.Set("force", "true")
+	}
+	if opts.ContentID {
+		q.Set("content_id", "true")
+	}
+	if !opts.CreatedAt.IsZero() {
+		q.Set("created_at", opts.CreatedAt.Format(time.RFC3339Nano))
+	}
+	if !opts.ProfileTime.IsZero() {
+		q.Set("profile_time", opts.ProfileTime.Format(time.RFC3339Nano))
+	}
+	if opts.Window > 0 {
+		q.Set("window", opts.Window.String())
+	}
+	for _, tag := range opts.Tags {
+		q.Add("tag", tag)
+	}
+
+	var result IngestResult
+	if err := c.do(ctx, http.MethodPost, IngestPath(opts.Type), q, data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListOptions filters and pages ListProfiles. Zero values use the server's
+// defaults.
+type ListOptions struct {
+	Limit   int
+	Offset  int
+	Type    ProfileType
+	Session string
+	Project string
+	Host    string
+	Starred bool
+}
+
+// ListProfiles returns profiles newest first, without raw data or metrics
+func (c *Client) ListProfiles(ctx context.Context, opts ListOptions) ([]*Profile, error) {
+	q := url.Values{}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.Type != "" {
+		q.Set("type", string(opts.Type))
+	}
+	if opts.Session != "" {
+		q.Set("session", opts.Session)
+	}
+	if opts.Project != "" {
+		q.Set("project", opts.Project)
+	}
+	if opts.Host != "" {
+		q.Set("host", opts.Host)
+	}
+	if opts.Starred {
+		q.Set("starred", "true")
+	}
+
+	var profiles []*Profile
+	if err := c.do(ctx, http.MethodGet, "/api/profiles", q, nil, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// GetProfile returns a profile with its metrics, without raw data
+func (c *Client) GetProfile(ctx context.Context, id string) (*Profile, error) {
+	var p Profile
+	if err := c.do(ctx, http.MethodGet, "/api/profiles/"+url.PathEscape(id), nil, nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetRawProfile downloads the profile exactly as it was ingested
+func (c *Client) GetRawProfile(ctx context.Context, id string) ([]byte, error) {
+	var raw bytes.Buffer
+	q := url.Values{"raw": {"true"}}
+	if err := c.do(ctx, http.MethodGet, "/api/profiles/"+url.PathEscape(id), q, nil, &raw); err != nil {
+		return nil, err
+	}
+	return raw.Bytes(), nil
+}
+
+// Derived returns the profiles computed from a profile, such as diffs it's
+// the base or target of, oldest first
+func (c *Client) Derived(ctx context.Context, id string) ([]*Profile, error) {
+	var profiles []*Profile
+	if err := c.do(ctx, http.MethodGet, "/api/profiles/"+url.PathEscape(id)+"/derived", nil, nil, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// Compare returns the given profiles in order. They must all be the same type.
+func (c *Client) Compare(ctx context.Context, ids ...string) ([]*Profile, error) {
+	if len(ids) < 2 {
+		return nil, fmt.Errorf("at least 2 profile IDs required for comparison")
+	}
+
+	var profiles []*Profile
+	q := url.Values{"ids": {strings.Join(ids, ",")}}
+	if err := c.do(ctx, http.MethodGet, "/api/profiles/compare", q, nil, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// Run returns the profiles ingested with a run ID, the load test's k6
+// summary and the profiles captured during it, oldest first
+func (c *Client) Run(ctx context.Context, runID string) ([]*Profile, error) {
+	var run struct {
+		Profiles []*Profile `json:"profiles"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/runs/"+url.PathEscape(runID), nil, nil, &run); err != nil {
+		return nil, err
+	}
+	return run.Profiles, nil
+}
+
+// SessionHealth reports when a session last received a capture and
+// whether it has stalled
+func (c *Client) SessionHealth(ctx context.Context, session string) (*SessionHealth, error) {
+	var health SessionHealth
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(session)+"/health", nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// SessionSummary returns a session's profile counts and headline metrics
+// per profile type
+func (c *Client) SessionSummary(ctx context.Context, session string) (*SessionSummary, error) {
+	var summary SessionSummary
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(session)+"/summary", nil, nil, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// LatestProfiles returns the most recently captured profile of each type
+// in a session, keyed by type, without raw data or metrics
+func (c *Client) LatestProfiles(ctx context.Context, session string) (map[ProfileType]*Profile, error) {
+	var latest map[ProfileType]*Profile
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(session)+"/latest", nil, nil, &latest); err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// SessionPercentiles returns the distribution of a quick-access metric,
+// such as total_value, across a session's profiles of one type
+func (c *Client) SessionPercentiles(ctx context.Context, session string, pt ProfileType, metric string) (*Percentiles, error) {
+	q := url.Values{}
+	q.Set("type", string(pt))
+	q.Set("metric", metric)
+	var percentiles Percentiles
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(session)+"/percentiles", q, nil, &percentiles); err != nil {
+		return nil, err
+	}
+	return &percentiles, nil
+}
+
+// Activity returns up to limit of the server's newest ingest and delete
+// events, newest first; 0 uses the server's default
+func (c *Client) Activity(ctx context.Context, limit int) ([]*ActivityEvent, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var events []*ActivityEvent
+	if err := c.do(ctx, http.MethodGet, "/api/activity", q, nil, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Delete removes a profile
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/profiles/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// DeleteOptions picks the profiles DeleteWhere removes. At least one
+// filter must be set.
+type DeleteOptions struct {
+	Type    ProfileType
+	Session string
+	Project string
+	Host    string
+	Tag     string
+	// Before matches profiles created before this time
+	Before time.Time
+	// Confirm deletes the matches; without it they're only counted
+	Confirm bool
+}
+
+// DeleteResult counts the profiles a DeleteWhere matched and deleted
+type DeleteResult struct {
+	Matched int64 `json:"matched"`
+	Deleted int64 `json:"deleted"`
+}
+
+// DeleteWhere removes every profile matching opts in one transaction, or
+// only counts them unless opts.Confirm is set
+func (c *Client) DeleteWhere(ctx context.Context, opts DeleteOptions) (*DeleteResult, error) {
+	q := url.Values{}
+	if opts.Type != "" {
+		q.Set("type", string(opts.Type))
+	}
+	if opts.Session != "" {
+		q.Set("session", opts.Session)
+	}
+	if opts.Project != "" {
+		q.Set("project", opts.Project)
+	}
+	if opts.Host != "" {
+		q.Set("host", opts.Host)
+	}
+	if opts.Tag != "" {
+		q.Set("tag", opts.Tag)
+	}
+	if !opts.Before.IsZero() {
+		q.Set("before", opts.Before.Format(time.RFC3339Nano))
+	}
+	if opts.Confirm {
+		q.Set("confirm", "true")
+	}
+
+	var result DeleteResult
+	if err := c.do(ctx, http.MethodDelete, "/api/profiles", q, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Star bookmarks a profile, to list it with ListOptions.Starred
+func (c *Client) Star(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/profiles/"+url.PathEscape(id)+"/star", nil, nil, nil)
+}
+
+// Unstar removes a profile's bookmark
+func (c *Client) Unstar(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/profiles/"+url.PathEscape(id)+"/unstar", nil, nil, nil)
+}
+
+// do sends a request, retrying transient failures of requests that can be
+// repeated, and decodes a JSON response into out. A *bytes.Buffer out
+// receives the body as is.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body []byte, out any) error {
+	u := c.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	// A failed POST may have been stored anyway, and sending it again would
+	// store it twice, unless its ID comes from its content
+	retries := c.MaxRetries
+	if !idempotent(method) && q.Get("content_id") != "true" {
+		retries = 0
+	}
+
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, u, body)
+		if attempt >= retries || (err == nil && !retryable(resp)) {
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			return decodeResponse(resp, out)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	return resp, nil
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sessionFullHeader marks a 429 as a full session rather than a rate limit
+const sessionFullHeader = "X-Perfkit-Session-Full"
+
+// retryable reports whether a response is worth retrying. A full session
+// is a 429 too, but stays full until profiles are deleted.
+func retryable(resp *http.Response) bool {
+	if resp.Header.Get(sessionFullHeader) != "" {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(resp.Body)
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *bytes.Buffer:
+		_, err := io.Copy(out, resp.Body)
+		return err
+	default:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+		return nil
+	}
+}
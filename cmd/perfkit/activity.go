@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flaticols/perfkit/client"
+)
+
+type ActivityCmd struct {
+	Server string `long:"server" description:"Perfkit server URL" default:"http://localhost:8080"`
+	Limit  int    `short:"n" long:"limit" description:"Number of events to show" default:"20"`
+}
+
+func (c *ActivityCmd) Execute(args []string) error {
+	return runActivity(c)
+}
+
+// runActivity prints the server's newest ingest and delete events, oldest
+// at the top so the latest is next to the prompt, like a log tail
+func runActivity(cmd *ActivityCmd) error {
+	events, err := client.New(cmd.Server).Activity(context.Background(), cmd.Limit)
+	if err != nil {
+		return fmt.Errorf("get activity: %w", err)
+	}
+	if len(events) == 0 {
+		fmt.Println("No activity recorded yet")
+		return nil
+	}
+
+	fmt.Printf("%-19s  %-6s  %-10s  %-20s  %-12s  %s\n", "TIME", "ACTION", "TYPE", "SESSION", "HOST", "PROFILE")
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		fmt.Printf("%-19s  %-6s  %-10s  %-20s  %-12s  %s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), e.Action, e.ProfileType,
+			orDash(e.Session), orDash(e.Host), e.ProfileID)
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
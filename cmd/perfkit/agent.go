@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/capture"
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+type AgentCmd struct {
+	Server      string        `long:"server" description:"Perfkit server URL" default:"http://localhost:8080"`
+	TargetsFile string        `long:"targets-file" description:"File or http(s) URL listing more targets (YAML or JSON), re-read every --refresh"`
+	Refresh     time.Duration `long:"refresh" description:"How often to re-read --targets-file" default:"30s"`
+	Interval    time.Duration `long:"interval" description:"Capture interval for discovered targets that don't set one" default:"1m"`
+	Jitter      time.Duration `long:"jitter" description:"Random offset within ±jitter for each capture round of targets that don't set one"`
+}
+
+func (c *AgentCmd) Execute(args []string) error {
+	return runAgent(c)
+}
+
+// agentTarget is a validated config target ready to capture
+type agentTarget struct {
+	cfg      config.TargetConfig
+	profiles []models.ProfileType
+}
+
+func runAgent(cmd *AgentCmd) error {
+	targets, err := loadAgentTargets(cmd)
+	if err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	pool := newTargetPool(cmd.Server)
+	log.Printf("Agent capturing %d targets → %s", len(targets), cmd.Server)
+	pool.sync(targets)
+
+	// Discovered targets change without a signal, so poll for them
+	var refresh <-chan time.Time
+	if cmd.TargetsFile != "" && cmd.Refresh > 0 {
+		ticker := time.NewTicker(cmd.Refresh)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				pool.stop()
+				log.Println("Agent stopped")
+				return nil
+			}
+			log.Println("Reloading config...")
+		case <-refresh:
+		}
+
+		// Keep the current targets if the new list is bad
+		reloaded, err := loadAgentTargets(cmd)
+		if err != nil {
+			log.Printf("Reload failed, keeping previous targets: %v", err)
+			continue
+		}
+		pool.sync(reloaded)
+	}
+}
+
+// loadAgentTargets reads and validates the targets section of the config,
+// plus the targets file if one is given
+func loadAgentTargets(cmd *AgentCmd) ([]agentTarget, error) {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	if err := registerProfileTypes(cfg); err != nil {
+		return nil, err
+	}
+
+	configured := cfg.Targets
+	if cmd.TargetsFile != "" {
+		discovered, err := readTargetsFile(cmd.TargetsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range discovered {
+			if t.Interval <= 0 {
+				t.Interval = cmd.Interval
+			}
+			configured = append(configured, t)
+		}
+	} else if len(configured) == 0 {
+		// A targets file may legitimately be empty while a service is
+		// scaled to zero, the config alone may not
+		return nil, fmt.Errorf("no targets configured")
+	}
+
+	targets := make([]agentTarget, 0, len(configured))
+	for i, t := range configured {
+		if t.URL == "" {
+			return nil, fmt.Errorf("target %d: url is required", i)
+		}
+		if t.Interval <= 0 {
+			return nil, fmt.Errorf("target %s: interval is required", t.URL)
+		}
+		if t.Jitter <= 0 {
+			t.Jitter = cmd.Jitter
+		}
+		if 2*t.Jitter >= t.Interval {
+			return nil, fmt.Errorf("target %s: jitter must be under half the interval", t.URL)
+		}
+		if t.DeepEvery > 0 && t.DeepCPUDuration <= 0 {
+			return nil, fmt.Errorf("target %s: deep_every needs a deep_cpu_duration", t.URL)
+		}
+		profiles, err := parseProfileTypes(t.Profiles)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", t.URL, err)
+		}
+		if t.Project == "" {
+			t.Project = cfg.Project
+		}
+		targets = append(targets, agentTarget{cfg: t, profiles: profiles})
+	}
+	return targets, nil
+}
+
+// readTargetsFile loads a list of targets from a file or an http(s) URL.
+// Entries are bare URLs or objects shaped like the config's targets.
+func readTargetsFile(source string) ([]config.TargetConfig, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = fetchTargets(source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read targets file: %w", err)
+	}
+
+	// JSON is valid YAML, so one decoder covers both
+	var targets []config.TargetConfig
+	if err := yaml.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("parse targets file %s: %w", source, err)
+	}
+	return targets, nil
+}
+
+// fetchTargets GETs a discovery endpoint's target list
+func fetchTargets(u string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d", u, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// targetPool runs one capture loop per target. sync replaces the target
+// set, starting new targets and stopping removed ones while the rest keep
+// their schedule.
+type targetPool struct {
+	server  string
+	running map[string]runningTarget
+	wg      sync.WaitGroup
+}
+
+type runningTarget struct {
+	url    string
+	cancel context.CancelFunc
+}
+
+func newTargetPool(server string) *targetPool {
+	return &targetPool{
+		server:  server,
+		running: make(map[string]runningTarget),
+	}
+}
+
+// sync makes the running capture loops match targets. A target whose
+// settings changed is restarted.
+func (p *targetPool) sync(targets []agentTarget) {
+	wanted := make(map[string]agentTarget, len(targets))
+	for _, t := range targets {
+		wanted[targetKey(t)] = t
+	}
+
+	for key, rt := range p.running {
+		if _, ok := wanted[key]; !ok {
+			log.Printf("[%s] target removed, stopping", rt.url)
+			rt.cancel()
+			delete(p.running, key)
+		}
+	}
+
+	for key, t := range wanted {
+		if _, ok := p.running[key]; ok {
+			continue
+		}
+		if t.cfg.Jitter > 0 {
+			log.Printf("[%s] capturing every %s ±%s", t.cfg.URL, t.cfg.Interval, t.cfg.Jitter)
+		} else {
+			log.Printf("[%s] capturing every %s", t.cfg.URL, t.cfg.Interval)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		p.running[key] = runningTarget{url: t.cfg.URL, cancel: cancel}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			runAgentTarget(ctx, p.server, t)
+		}()
+	}
+}
+
+// stop ends every capture loop and waits for in-flight captures
+func (p *targetPool) stop() {
+	for _, rt := range p.running {
+		rt.cancel()
+	}
+	p.wg.Wait()
+}
+
+// targetKey identifies a target by all of its settings
+func targetKey(t agentTarget) string {
+	return fmt.Sprintf("%+v", t.cfg)
+}
+
+// runAgentTarget captures one target every interval until ctx is done
+func runAgentTarget(ctx context.Context, serverURL string, t agentTarget) {
+	c := capture.New(t.cfg.URL, serverURL)
+	c.Session = t.cfg.Session
+	c.Project = t.cfg.Project
+	c.Source = "agent"
+	if t.cfg.CPUDuration > 0 {
+		c.CPUDuration = t.cfg.CPUDuration
+	}
+
+	session := &capture.Session{
+		Capturer:        c,
+		Profiles:        t.profiles,
+		Interval:        t.cfg.Interval,
+		Jitter:          t.cfg.Jitter,
+		DeepEvery:       t.cfg.DeepEvery,
+		DeepCPUDuration: t.cfg.DeepCPUDuration,
+		// The agent runs for good and only logs, so keep no history
+		KeepRounds: 1,
+		OnResult: func(result capture.CaptureResult) {
+			pt := result.ProfileType
+			if result.Error != nil {
+				log.Printf("[%s] ✗ %-12s %v", t.cfg.URL, pt, result.Error)
+				return
+			}
+			log.Printf("[%s] ✓ %-12s %s", t.cfg.URL, pt, formatSize(result.Size))
+			if result.Warning != "" {
+				log.Printf("[%s] ! %-12s %s", t.cfg.URL, pt, result.Warning)
+			}
+		},
+	}
+	if _, err := session.Run(ctx); err != nil {
+		log.Printf("[%s] %v", t.cfg.URL, err)
+	}
+}
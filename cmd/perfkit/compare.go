@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/pprof"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type CompareCmd struct {
+	Format     string  `short:"f" long:"format" description:"Output format" choice:"table" choice:"csv" choice:"json" default:"table"`
+	Output     string  `short:"o" long:"output" description:"Write output to a file instead of stdout"`
+	GroupBy    string  `long:"group-by" description:"Roll deltas up by function, package or source file" choice:"function" choice:"package" choice:"file" default:"function"`
+	Unit       string  `long:"unit" description:"Sample type to compare by unit (samples, ns, bytes)"`
+	ValueType  string  `long:"value-type" description:"Sample type to compare by name (e.g. inuse_space, alloc_objects)"`
+	MinPercent float64 `long:"min-percent" description:"Hide functions below this percent of the total in both profiles"`
+	MinDelta   int64   `long:"min-delta" description:"Hide functions whose value changed by less than this"`
+	Args       struct {
+		Base   string `positional-arg-name:"base" description:"Base profile ID" required:"yes"`
+		Target string `positional-arg-name:"target" description:"Target profile ID" required:"yes"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *CompareCmd) Execute(args []string) error {
+	return runCompare(c)
+}
+
+func runCompare(cmd *CompareCmd) error {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	found, err := store.GetProfilesByIDs(context.Background(), []string{cmd.Args.Base, cmd.Args.Target})
+	if err != nil {
+		return fmt.Errorf("get profiles: %w", err)
+	}
+	base, ok := found[cmd.Args.Base]
+	if !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, cmd.Args.Base)
+	}
+	target, ok := found[cmd.Args.Target]
+	if !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, cmd.Args.Target)
+	}
+
+	if base.ProfileType != target.ProfileType {
+		return fmt.Errorf("cannot compare %s with %s profile", base.ProfileType, target.ProfileType)
+	}
+	if !base.ProfileType.IsPprof() {
+		return fmt.Errorf("function comparison is only available for pprof profiles")
+	}
+	runWarning, err := models.CheckSameRun(base, target)
+	if err != nil {
+		return err
+	}
+
+	diff, err := pprof.DiffProfiles(base.RawData, target.RawData, pprof.DiffOptions{
+		ValueType:  cmd.ValueType,
+		Unit:       cmd.Unit,
+		GroupBy:    cmd.GroupBy,
+		MinPercent: cmd.MinPercent,
+		MinDelta:   cmd.MinDelta,
+	})
+	if err != nil {
+		return fmt.Errorf("compare profiles: %w", err)
+	}
+	if runWarning != "" {
+		diff.Warnings = append(diff.Warnings, runWarning)
+	}
+	var ageWarning string
+	if diff.Gap, ageWarning = models.CheckAge(base, target, cfg.Compare.MaxGap); ageWarning != "" {
+		diff.Warnings = append(diff.Warnings, ageWarning)
+	}
+
+	var out io.Writer = os.Stdout
+	if cmd.Output != "" {
+		f, err := os.Create(cmd.Output)
+		if err != nil {
+			return fmt.Errorf("create output: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	// CSV and JSON may be piped into other tools, so warn on stderr
+	if cmd.Format != "table" {
+		for _, warning := range diff.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+		}
+	}
+
+	switch cmd.Format {
+	case "csv":
+		return diff.WriteCSV(out)
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	default:
+		return writeDiffTable(out, diff)
+	}
+}
+
+func writeDiffTable(w io.Writer, diff *pprof.Diff) error {
+	fmt.Fprintf(w, "%s/%s by %s: %s → %s\n", diff.SampleType, diff.Unit, diff.GroupBy,
+		pprof.FormatValue(diff.BaseTotal, diff.Unit), pprof.FormatValue(diff.TargetTotal, diff.Unit))
+	if diff.Gap != 0 {
+		fmt.Fprintf(w, "Captured %s apart\n", models.FormatGap(diff.Gap))
+	}
+	fmt.Fprintln(w)
+	for _, warning := range diff.Warnings {
+		fmt.Fprintf(w, "! %s\n", warning)
+	}
+	if len(diff.Warnings) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	if len(diff.Functions) == 0 {
+		if diff.Filtered > 0 {
+			_, err := fmt.Fprintf(w, "No differences above the threshold (%d hidden).\n", diff.Filtered)
+			return err
+		}
+		_, err := fmt.Fprintln(w, "No differences.")
+		return err
+	}
+
+	// Heap byte diffs get an extra column with the live object count change
+	objects := func(f pprof.FunctionDelta) string { return "" }
+	header := fmt.Sprintf("%12s %12s %12s %9s  %s", "BASE", "TARGET", "DELTA", "CHANGE", "NAME")
+	if diff.ObjectType != "" {
+		objects = func(f pprof.FunctionDelta) string { return fmt.Sprintf(" %+10d", f.Objects.Delta) }
+		header = fmt.Sprintf("%12s %12s %12s %9s %10s  %s", "BASE", "TARGET", "DELTA", "CHANGE", "OBJECTS", "NAME")
+	}
+
+	if err := writeOneSided(w, "NEW IN TARGET", diff.Added, diff.TargetTotal, diff.Unit, func(f pprof.FunctionDelta) int64 { return f.Target }); err != nil {
+		return err
+	}
+	if err := writeOneSided(w, "GONE FROM TARGET", diff.Removed, diff.BaseTotal, diff.Unit, func(f pprof.FunctionDelta) int64 { return f.Base }); err != nil {
+		return err
+	}
+	// By file, lead with the files that moved most: the ones to look for
+	// in the change under review
+	headed := len(diff.Added)+len(diff.Removed) > 0
+	if diff.GroupBy == pprof.GroupByFile {
+		if err := writeTopChanges(w, "MOST REGRESSED FILES", diff.Regressed, diff.Unit); err != nil {
+			return err
+		}
+		if err := writeTopChanges(w, "MOST IMPROVED FILES", diff.Improved, diff.Unit); err != nil {
+			return err
+		}
+		headed = headed || len(diff.Regressed)+len(diff.Improved) > 0
+	}
+	if headed {
+		fmt.Fprintln(w, "ALL CHANGES")
+	}
+
+	fmt.Fprintln(w, header)
+	for _, f := range diff.Functions {
+		change := "new"
+		if f.Base != 0 {
+			change = fmt.Sprintf("%+.1f%%", f.DeltaPercent)
+		}
+		if _, err := fmt.Fprintf(w, "%12s %12s %12s %9s%s  %s\n",
+			pprof.FormatValue(f.Base, diff.Unit), pprof.FormatValue(f.Target, diff.Unit),
+			pprof.FormatValue(f.Delta, diff.Unit), change, objects(f), f.Name); err != nil {
+			return err
+		}
+	}
+	if diff.Filtered > 0 {
+		fmt.Fprintf(w, "\n%d functions below the threshold hidden\n", diff.Filtered)
+	}
+	return nil
+}
+
+// writeTopChanges lists the entries that changed most under a heading,
+// with their delta and relative change
+func writeTopChanges(w io.Writer, heading string, changes []pprof.FunctionDelta, unit string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, heading)
+	for _, f := range changes {
+		change := "new"
+		if f.Base != 0 {
+			change = fmt.Sprintf("%+.1f%%", f.DeltaPercent)
+		}
+		if _, err := fmt.Fprintf(w, "%12s %9s  %s\n", pprof.FormatValue(f.Delta, unit), change, f.Name); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeOneSided lists functions only one profile has under a heading, with
+// their value and share of that profile's total
+func writeOneSided(w io.Writer, heading string, funcs []pprof.FunctionDelta, total int64, unit string, value func(pprof.FunctionDelta) int64) error {
+	if len(funcs) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "%s (%d)\n", heading, len(funcs))
+	for _, f := range funcs {
+		v := value(f)
+		share := 0.0
+		if total != 0 {
+			share = float64(v) / float64(total) * 100
+		}
+		if _, err := fmt.Fprintf(w, "%12s %8.1f%%  %s\n", pprof.FormatValue(v, unit), share, f.Name); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
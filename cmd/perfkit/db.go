@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type DBCmd struct {
+	Optimize DBOptimizeCmd `command:"optimize" description:"Refresh query planner statistics"`
+	Verify   DBVerifyCmd   `command:"verify" description:"Check every stored profile's raw data for corruption"`
+}
+
+type DBOptimizeCmd struct {
+	Reindex bool `long:"reindex" description:"Also rebuild every index, locking out writes while it runs"`
+}
+
+func (c *DBOptimizeCmd) Execute(args []string) error {
+	return runDBOptimize(c)
+}
+
+// runDBOptimize analyzes the local database, and with --reindex rebuilds
+// its indexes first, which keeps queries fast after large imports or prunes
+func runDBOptimize(cmd *DBOptimizeCmd) error {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	if cmd.Reindex {
+		if err := store.Reindex(ctx); err != nil {
+			return fmt.Errorf("reindex database: %w", err)
+		}
+	}
+	if err := store.Optimize(ctx); err != nil {
+		return fmt.Errorf("optimize database: %w", err)
+	}
+
+	fmt.Printf("Optimized %s in %s\n", cfg.DBPath(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+type DBVerifyCmd struct {
+	Quarantine bool `long:"quarantine" description:"Tag profiles that fail the check as corrupt"`
+}
+
+func (c *DBVerifyCmd) Execute(args []string) error {
+	return runDBVerify(c)
+}
+
+// runDBVerify re-reads every stored profile and reports those whose raw
+// data no longer parses or no longer matches what was stored
+func runDBVerify(cmd *DBVerifyCmd) error {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if err := registerProfileTypes(cfg); err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	profiles, err := store.FindProfiles(ctx, storage.ProfileFilter{})
+	if err != nil {
+		return fmt.Errorf("list profiles: %w", err)
+	}
+
+	fmt.Printf("Verifying %d profiles\n\n", len(profiles))
+
+	var bad int
+	for _, p := range profiles {
+		// List queries omit raw data, so fetch the full record
+		full, err := store.GetProfile(ctx, p.ID)
+		if err == nil {
+			err = verifyProfile(full)
+		}
+		if err == nil {
+			continue
+		}
+		bad++
+
+		if cmd.Quarantine && !slices.Contains(p.Tags, models.TagCorrupt) {
+			if qerr := store.AddTag(ctx, p.ID, models.TagCorrupt); qerr != nil {
+				err = fmt.Errorf("%w (quarantine: %v)", err, qerr)
+			}
+		}
+		fmt.Printf("  ✗ %s  %-12s  %v\n", p.ID, p.ProfileType, err)
+	}
+
+	if bad > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d checked, %d ok, %d bad\n", len(profiles), len(profiles)-bad, bad)
+
+	if bad > 0 {
+		if cmd.Quarantine {
+			fmt.Printf("Tagged %s; find them with tag=%s\n", models.TagCorrupt, models.TagCorrupt)
+		}
+		return fmt.Errorf("%d of %d profiles failed verification", bad, len(profiles))
+	}
+	return nil
+}
+
+// verifyProfile checks a stored profile's raw data: it must be as long as
+// recorded at ingest, hash to the content hash recorded then, parse with
+// its type's parser, and still derive the profile's ID if that was taken
+// from the content. Decimated profiles are stored rewritten, so their
+// content ID no longer matches by design.
+func verifyProfile(p *models.Profile) error {
+	if len(p.RawData) != p.RawSize {
+		return fmt.Errorf("raw data is %d bytes, %d were stored", len(p.RawData), p.RawSize)
+	}
+	if models.ContentHash(p.RawData) != p.ContentHash {
+		return fmt.Errorf("raw data doesn't match the content hash")
+	}
+	// Parse a copy, so the check can't touch the stored record
+	parsed := *p
+	if err := reparseProfile(&parsed); err != nil {
+		return err
+	}
+	if id, err := uuid.Parse(p.ID); err == nil && id.Version() == 5 && p.StoredSamples == nil {
+		if models.ContentID(p.RawData, p.ProfileType, p.Project, p.Session) != p.ID {
+			return fmt.Errorf("raw data doesn't match the content ID")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flaticols/perfkit/client"
+	"github.com/flaticols/perfkit/internal/capture"
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/format"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// runGate judges each freshly sent profile against the newest earlier
+// profile of its type in the baseline session, printing a verdict per type.
+// It returns errRegression when any headline metric regressed by more than
+// the threshold, or without one the configured tolerance for its type. A
+// failed capture fails the gate, as does a type without a baseline unless
+// allowNoBaseline, or having nothing to judge at all.
+func runGate(ctx context.Context, serverURL, baseline string, threshold float64, allowNoBaseline bool, cc config.CompareConfig, results []capture.CaptureResult) error {
+	cl := client.New(serverURL)
+
+	if threshold > 0 {
+		fmt.Printf("\nGate against session %q (threshold %g%%)\n", baseline, threshold)
+	} else {
+		fmt.Printf("\nGate against session %q (configured noise tolerance)\n", baseline)
+	}
+	var judged, regressed, failed, noBaseline int
+	for _, result := range results {
+		pt := result.ProfileType
+		if result.Error != nil {
+			fmt.Printf("  ✗ %-12s capture failed: %v\n", pt, result.Error)
+			failed++
+			continue
+		}
+		if result.ID == "" {
+			fmt.Printf("  ✗ %-12s not sent\n", pt)
+			failed++
+			continue
+		}
+
+		base, err := gateBaseline(ctx, cl, baseline, pt, result.ID)
+		if err != nil {
+			return err
+		}
+		if base == nil {
+			fmt.Printf("  - %-12s no baseline\n", pt)
+			noBaseline++
+			continue
+		}
+		target, err := cl.GetProfile(ctx, result.ID)
+		if err != nil {
+			return fmt.Errorf("get profile: %w", err)
+		}
+
+		verdict, detail := judge(pt, base, target, tolerance(threshold, cc, pt))
+		if verdict == verdictNone {
+			fmt.Printf("  - %-12s %s\n", pt, verdict)
+			continue
+		}
+		judged++
+
+		key := models.HeadlineMetrics[pt]
+		a, _ := base.MetricValue(key)
+		b, _ := target.MetricValue(key)
+		mark := "✓"
+		if verdict == models.VerdictRegressed {
+			mark = "✗"
+			regressed++
+		}
+		fmt.Printf("  %s %-12s %s%s: %s → %s\n", mark, pt, verdict, detail, format.Value(key, a), format.Value(key, b))
+	}
+
+	switch {
+	case regressed > 0:
+		return fmt.Errorf("%w in %d of %d profile types", errRegression, regressed, judged)
+	case failed > 0:
+		return fmt.Errorf("gate failed: %d profile types not captured", failed)
+	case noBaseline > 0 && !allowNoBaseline:
+		return fmt.Errorf("gate failed: %d profile types have no baseline in session %q (pass --allow-no-baseline to accept that)", noBaseline, baseline)
+	case judged == 0 && noBaseline == 0:
+		return fmt.Errorf("gate failed: no profile type could be judged")
+	}
+	return nil
+}
+
+// gateBaseline returns the newest profile of type pt in session other than
+// the one just captured, with its metrics, or nil if there is none
+func gateBaseline(ctx context.Context, cl *client.Client, session string, pt models.ProfileType, exclude string) (*models.Profile, error) {
+	profiles, err := cl.ListProfiles(ctx, client.ListOptions{Limit: 2, Type: pt, Session: session})
+	if err != nil {
+		return nil, fmt.Errorf("list baseline profiles: %w", err)
+	}
+	for _, p := range profiles {
+		if p.ID == exclude {
+			continue
+		}
+		// Listings omit metrics, so load the full record
+		base, err := cl.GetProfile(ctx, p.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get profile: %w", err)
+		}
+		return base, nil
+	}
+	return nil, nil
+}
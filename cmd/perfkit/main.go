@@ -3,19 +3,28 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/flaticols/perfkit/internal/capture"
 	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/format"
 	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/pprof"
+	"github.com/flaticols/perfkit/internal/runtimestats"
 	"github.com/flaticols/perfkit/internal/server"
 	"github.com/flaticols/perfkit/internal/storage"
+	"github.com/flaticols/perfkit/internal/trace"
 	"github.com/jessevdk/go-flags"
 )
 
@@ -26,12 +35,23 @@ type Options struct {
 	Quickstart QuickstartCmd `command:"quickstart" alias:"q" description:"Show getting started guide"`
 	Session    SessionCmd    `command:"session" description:"Manage sessions"`
 	Get        GetCmd        `command:"get" description:"Get a profile from a session"`
+	Open       OpenCmd       `command:"open" description:"Open a stored profile in the go tool pprof web UI"`
+	Replay     ReplayCmd     `command:"replay" description:"Re-send stored profiles to another perfkit server"`
+	Reprocess  ReprocessCmd  `command:"reprocess" description:"Recompute metrics for stored profiles from their raw data"`
+	Agent      AgentCmd      `command:"agent" description:"Continuously capture the targets listed in the config"`
+	Compare    CompareCmd    `command:"compare" description:"Compare per-function values of two profiles"`
+	Selftest   SelftestCmd   `command:"selftest" description:"Check capture, ingest, list, compare and delete end to end in-process"`
+	Activity   ActivityCmd   `command:"activity" description:"Show a server's recent ingest and delete events"`
+	Starred    StarredCmd    `command:"starred" description:"List a server's starred profiles"`
+	Rm         RmCmd         `command:"rm" description:"Delete every profile on a server matching filters"`
+	DB         DBCmd         `command:"db" description:"Maintain the local database"`
 }
 
 type ServerCmd struct {
-	Host  string `short:"H" long:"host" description:"Server host" default:"localhost"`
-	Port  int    `short:"p" long:"port" description:"Server port" default:"8080"`
-	Pprof bool   `long:"pprof" description:"Enable pprof endpoints for self-profiling"`
+	Host   string `short:"H" long:"host" description:"Server host" default:"localhost"`
+	Port   int    `short:"p" long:"port" description:"Server port" default:"8080"`
+	Pprof  bool   `long:"pprof" description:"Enable pprof endpoints for self-profiling"`
+	Memory bool   `long:"memory" description:"Keep profiles in memory only; nothing is saved and everything is lost on exit"`
 }
 
 func (c *ServerCmd) Execute(args []string) error {
@@ -39,13 +59,26 @@ func (c *ServerCmd) Execute(args []string) error {
 }
 
 type CaptureCmd struct {
-	Profiles    string        `short:"p" long:"profiles" description:"Comma-separated profiles to capture (cpu,heap,goroutine,block,mutex,allocs,threadcreate)" default:"all"`
+	Profiles    string        `short:"p" long:"profiles" description:"Comma-separated profiles to capture (cpu,heap,goroutine,block,mutex,allocs,threadcreate,runtime,trace,wall); default: capture.default_profiles from the config, or all"`
+	Set         string        `long:"set" description:"Capture a named profile set from the config (built in: memory, latency)"`
 	Interval    time.Duration `short:"i" long:"interval" description:"Capture interval for periodic mode (e.g., 30s, 1m)"`
-	CPUDuration time.Duration `long:"cpu-duration" description:"CPU profile duration" default:"30s"`
+	Jitter      time.Duration `long:"jitter" description:"Move each interval round by a random offset within ±jitter, so captures of many targets don't line up"`
+	CPUDuration time.Duration `long:"cpu-duration" description:"CPU and wall-clock profile and trace duration" default:"30s"`
+	DeepEvery   int           `long:"deep-every" description:"In interval mode, sample for --deep-cpu-duration instead on every Nth round"`
+	DeepCPU     time.Duration `long:"deep-cpu-duration" description:"CPU and wall-clock profile and trace duration of --deep-every rounds" default:"60s"`
 	Session     string        `short:"s" long:"session" description:"Session name for grouping profiles"`
 	Project     string        `long:"project" description:"Project name"`
+	RunID       string        `long:"run-id" description:"Load test run ID to tie captures to its k6 summary"`
 	Server      string        `long:"server" description:"Perfkit server URL" default:"http://localhost:8080"`
 	Count       int           `short:"n" long:"count" description:"Number of captures in interval mode (0=infinite)" default:"0"`
+	Resume      bool          `long:"resume" description:"Continue an interrupted interval capture of the session, keeping its cadence and round count"`
+	DryRun      bool          `long:"dry-run" description:"Fetch profiles and report sizes without uploading"`
+	Compress    bool          `long:"compress" description:"Gzip uncompressed profiles before uploading"`
+	Context     string        `long:"context" optional:"yes" optional-value:"goroutines" description:"Label each round's profiles with the target's runtime context: --context=PATH or URL serving a JSON object (e.g. /debug/context), or bare --context for just the goroutine count"`
+	Gate        bool          `long:"gate" description:"After sending, compare against the baseline session and exit 3 on a regression"`
+	Baseline    string        `long:"baseline" description:"Session to gate against (default: the capture session)"`
+	Threshold   float64       `long:"threshold" description:"Percent change in a headline metric that fails the gate (default: the config's compare tolerance)"`
+	AllowNoBase bool          `long:"allow-no-baseline" description:"Pass the gate for profile types the baseline session has none of yet"`
 	Args        struct {
 		Target string `positional-arg-name:"target" description:"Target pprof URL (e.g., http://localhost:6060)"`
 	} `positional-args:"yes" required:"yes"`
@@ -65,6 +98,8 @@ func (c *QuickstartCmd) Execute(args []string) error {
 type SessionCmd struct {
 	Ls       SessionLsCmd       `command:"ls" description:"List all sessions"`
 	Profiles SessionProfilesCmd `command:"profiles" description:"List profiles in a session"`
+	Diff     SessionDiffCmd     `command:"diff" description:"Compare the latest profiles of two sessions type by type"`
+	Clone    SessionCloneCmd    `command:"clone" description:"Copy a session's profiles into a new session as a baseline"`
 }
 
 type SessionLsCmd struct{}
@@ -84,15 +119,16 @@ func (c *SessionProfilesCmd) Execute(args []string) error {
 }
 
 type GetCmd struct {
-	Raw  bool `long:"raw" description:"Return raw profile data"`
-	Args struct {
+	Raw      bool `long:"raw" description:"Return raw profile data"`
+	DiffPrev bool `long:"diff-prev" description:"Show metric deltas against the previous profile of the same type in the session"`
+	Args     struct {
 		SessionName string `positional-arg-name:"session" description:"Session name" required:"yes"`
 		ProfileID   string `positional-arg-name:"profile_id" description:"Profile ID" required:"yes"`
 	} `positional-args:"yes" required:"yes"`
 }
 
 func (c *GetCmd) Execute(args []string) error {
-	return runGet(c.Args.SessionName, c.Args.ProfileID, c.Raw)
+	return runGet(c.Args.SessionName, c.Args.ProfileID, c.Raw, c.DiffPrev)
 }
 
 const quickstartGuide = `
@@ -134,6 +170,7 @@ Server runs at http://localhost:8080 with web UI for browsing profiles.
 Options:
     --port 9090       Use different port
     --pprof           Enable self-profiling endpoints
+    --memory          Throwaway server, nothing saved to disk
 
 
 STEP 3: CAPTURE PROFILES
@@ -147,6 +184,9 @@ In another terminal, capture profiles from your running app:
     # Capture specific profiles
     perfkit capture http://localhost:6060 --profiles heap,goroutine,cpu
 
+    # Capture a named set (memory, latency, or capture.sets in .perfkit.yaml)
+    perfkit capture http://localhost:6060 --set memory
+
     # Capture with session name (groups profiles together)
     perfkit capture http://localhost:6060 --session load-test
 
@@ -156,6 +196,37 @@ In another terminal, capture profiles from your running app:
     # Capture 5 times with 10s interval
     perfkit capture http://localhost:6060 --interval 10s --count 5
 
+    # Spread rounds within ±5s of the interval, e.g. when capturing a fleet
+    perfkit capture http://localhost:6060 --interval 1m --jitter 5s
+
+    # Sample CPU for 5s each round, and for 60s every tenth round
+    perfkit capture http://localhost:6060 --interval 1m --cpu-duration 5s --deep-every 10 --deep-cpu-duration 60s
+
+    # Continue an interrupted session's rounds and cadence after a restart
+    perfkit capture http://localhost:6060 --interval 30s --session monitoring --resume
+
+    # Record the goroutine count (or --context=/debug/context JSON) with each round
+    perfkit capture http://localhost:6060 --interval 30s --context
+
+    # Check connectivity and profile sizes without uploading
+    perfkit capture http://localhost:6060 --dry-run
+
+
+For always-on monitoring of several services, list them in .perfkit.yaml
+and run the agent instead (send SIGHUP to reload the targets):
+
+    targets:
+      - url: http://localhost:6060
+        interval: 1m
+        jitter: 5s
+        profiles: [heap, goroutine]
+        session: api-monitoring
+
+    perfkit agent
+
+    # Also capture targets from a service discovery file, re-read every 30s
+    perfkit agent --targets-file targets.json
+
 
 STEP 4: VIEW AND COMPARE
 ------------------------
@@ -178,6 +249,12 @@ PROFILE TYPES
     mutex        Mutex contention (cumulative since app start)
     allocs       All allocations (cumulative since app start)
     threadcreate Thread creation stacks
+    runtime      Runtime health from expvar /debug/vars (heap, GC, goroutines);
+                 not part of "all", request it with --profiles runtime
+    trace        Execution trace (over --cpu-duration): scheduling latency,
+                 GC and syscall time; not part of "all"
+    wall         fgprof wall-clock profile (over --cpu-duration) from
+                 /debug/fgprof, on- and off-CPU time; not part of "all"
 
 
 EXAMPLE: DEBUGGING MEMORY LEAK
@@ -223,6 +300,13 @@ EXAMPLE: K6 LOAD TESTING
     # Open http://localhost:8080, select both k6 profiles, click Compare
     # See performance improvements: P95, P99, RPS, error rate changes
 
+    # Tie a test to the server profiles captured during it with a run ID
+    perfkit capture http://localhost:6060 --interval 30s --run-id run42 &
+    k6 run --summary-export=run42.json script.js
+    curl -X POST "http://localhost:8080/api/k6/ingest?run_id=run42" \
+      --data-binary @run42.json
+    curl http://localhost:8080/api/runs/run42
+
 
 STEP 5: BROWSE SESSIONS AND PROFILES (CLI)
 ------------------------------------------
@@ -231,10 +315,27 @@ List all sessions:
 
     perfkit session ls
 
+Sessions whose captures stopped arriving are marked as stale.
+
 List profiles in a specific session:
 
     perfkit session profiles my-session
 
+Gate a CI build in one step: capture, then exit 3 if a headline metric
+regressed beyond the noise tolerance against the baseline session:
+
+    perfkit capture http://localhost:6060 --profiles heap \
+      --session $GIT_SHA --baseline main --gate
+
+Compare two sessions type by type (exits 3 on a regression):
+
+    perfkit session diff baseline optimized
+
+Snapshot a session as a baseline before a risky change, then compare:
+
+    perfkit session clone monitoring pre-refactor
+    perfkit session diff pre-refactor monitoring
+
 Get a specific profile (JSON metadata):
 
     perfkit get my-session <profile-id>
@@ -243,16 +344,80 @@ Get raw profile data:
 
     perfkit get my-session <profile-id> --raw > profile.pb.gz
 
+Compare a profile against the previous capture of the same type:
+
+    perfkit get my-session <profile-id> --diff-prev
+
+Open a profile in the go tool pprof web UI (the newest one without an ID):
+
+    perfkit open my-session <profile-id>
+
+Compare per-function values of two profiles (table, csv or json):
+
+    perfkit compare <base-id> <target-id>
+    perfkit compare <base-id> <target-id> --group-by package
+    perfkit compare <base-id> <target-id> --group-by file
+    perfkit compare <base-id> <target-id> --format csv -o deltas.csv
+
+
+STEP 6: REPLAY TO ANOTHER SERVER
+--------------------------------
+
+Push locally stored profiles to a central perfkit server:
+
+    # Preview what would be sent
+    perfkit replay http://perfkit.internal:8080 --session load-test --dry-run
+
+    # Send everything captured in the last day
+    perfkit replay http://perfkit.internal:8080 --since 24h
+
+    # Re-runnable: skip profiles the target already has
+    perfkit replay http://perfkit.internal:8080 --since 24h --content-id
+
+After upgrading perfkit, recompute stored metrics with the new parsers:
+
+    perfkit reprocess --dry-run
+    perfkit reprocess --type heap
+
 
 API ENDPOINTS
 -------------
 
     POST /api/pprof/ingest?type=heap&session=test    Ingest pprof profile
     POST /api/k6/ingest?session=test&name=run1       Ingest k6 summary
+    POST /api/runtime/ingest?session=test            Ingest runtime metrics JSON
+    POST /api/trace/ingest?session=test              Ingest Go execution trace
+    POST /api/pprof/ingest/url                        Have the server pull a profile
     GET  /api/profiles                                List profiles
     GET  /api/profiles/{id}                           Get profile
     GET  /api/profiles/{id}?raw=true                  Download raw data
+    DELETE /api/profiles?session=x&before=...&confirm=true
+                                                      Delete all matching profiles
+    POST /api/profiles/{id}/star                      Star a profile (unstar to remove)
+    GET  /api/profiles/{id}/top?cum=true              pprof-style top table
+    GET  /api/profiles/{id}/report?type=tree          pprof tree, peek or traces report
+    GET  /api/profiles/{id}/derived                   Diffs computed from a profile
+    GET  /api/profiles/{id}/insights                  Findings like GC or lock hot spots
     GET  /api/profiles/compare?ids=id1,id2            Compare profiles
+    GET  /api/profiles/compare/export.html?ids=id1,id2
+                                                      Comparison as a standalone HTML file
+    GET  /api/profiles/compare/functions?base=id1&target=id2&groupBy=package
+                                                      Per-function/package deltas
+    GET  /api/profiles/compare/flamegraph?base=id1&target=id2
+                                                      Merged call tree for a differential flame graph
+    GET  /api/profiles/compare/status-codes?base=id1&target=id2
+                                                      Status code mix shift between k6 runs
+    GET  /api/profiles/compare/k6?ids=id1,id2&metrics=p95,iterations
+                                                      Any k6 metrics of two runs, diffed
+    POST /api/profiles/{id}/compare-upload            Diff a stored profile against a local file
+    GET  /api/sessions/{name}/health                  Session capture freshness
+    GET  /api/sessions/{name}/summary                 Per-type metric rollup of a session
+    GET  /api/sessions/{name}/latest                  Newest profile of each type in a session
+    GET  /api/sessions/{name}/heatmap?bucket=1h       Metric by day and time of day
+    GET  /api/sessions/{name}/percentiles?type=cpu    P50/P95 of a metric across captures
+    GET  /api/runs/{run_id}                           k6 summary and profiles of a load test
+    GET  /api/activity?limit=50                       Recent ingest and delete events
+    POST /api/db/optimize                             Reindex and analyze the database
 
 
 MORE INFO
@@ -262,6 +427,16 @@ MORE INFO
     perfkit capture --help     Capture options
     perfkit session --help     Session management
     perfkit get --help         Get profile data
+    perfkit open --help        Open a profile in pprof's web UI
+    perfkit replay --help      Replay options
+    perfkit reprocess --help   Reprocess options
+    perfkit agent --help       Agent options
+    perfkit compare --help     Compare options
+    perfkit selftest           Check the full pipeline in-process
+    perfkit activity           Recent ingests and deletes on a server
+    perfkit starred            Starred profiles on a server
+    perfkit rm --help          Delete profiles in bulk
+    perfkit db optimize        Analyze after large imports or prunes
 
     GitHub: https://github.com/flaticols/perfkit
 
@@ -281,17 +456,46 @@ func main() {
 
 	if _, err := parser.Parse(); err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
-			os.Exit(0)
+			os.Exit(exitOK)
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			os.Exit(exitNotFound)
+		}
+		if errors.Is(err, errRegression) {
+			os.Exit(exitRegression)
 		}
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 }
 
+// Exit codes, so scripts can tell a missing profile or a regression from
+// a real failure
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitNotFound   = 2
+	exitRegression = 3
+)
+
+// notFoundError is a user-facing message that exits with exitNotFound
+type notFoundError string
+
+func notFound(msg string, args ...any) error {
+	return notFoundError(fmt.Sprintf(msg, args...))
+}
+
+func (e notFoundError) Error() string { return string(e) }
+
+func (e notFoundError) Is(target error) bool { return target == storage.ErrNotFound }
+
 func runServer(cmd *ServerCmd) error {
 	cfg, err := config.Load(opts.Config)
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
+	if err := registerProfileTypes(cfg); err != nil {
+		return err
+	}
 
 	// Override config with command line flags
 	if cmd.Host != "localhost" {
@@ -302,11 +506,16 @@ func runServer(cmd *ServerCmd) error {
 	}
 	cfg.Server.EnablePprof = cmd.Pprof
 
-	if err := cfg.EnsureDataDir(); err != nil {
-		return fmt.Errorf("ensure data dir: %w", err)
+	var store *storage.Store
+	if cmd.Memory {
+		log.Println("Using in-memory storage; profiles are discarded on exit")
+		store, err = storage.NewMemory()
+	} else {
+		if err := cfg.EnsureDataDir(); err != nil {
+			return fmt.Errorf("ensure data dir: %w", err)
+		}
+		store, err = storage.New(cfg.DBPath())
 	}
-
-	store, err := storage.New(cfg.DBPath())
 	if err != nil {
 		return fmt.Errorf("open storage: %w", err)
 	}
@@ -331,30 +540,90 @@ func runServer(cmd *ServerCmd) error {
 	return srv.Start()
 }
 
+// captureSettings records how a capture run was invoked, sent as the
+// provenance of its first profile
+func captureSettings(cmd *CaptureCmd, profiles []models.ProfileType) *capture.Settings {
+	settings := &capture.Settings{
+		Target:      cmd.Args.Target,
+		Set:         cmd.Set,
+		CPUDuration: cmd.CPUDuration.String(),
+		Count:       cmd.Count,
+		Context:     cmd.Context,
+	}
+	for _, pt := range profiles {
+		settings.Profiles = append(settings.Profiles, string(pt))
+	}
+	if cmd.Interval > 0 {
+		settings.Interval = cmd.Interval.String()
+	}
+	if cmd.Jitter > 0 {
+		settings.Jitter = cmd.Jitter.String()
+	}
+	if cmd.DeepEvery > 0 {
+		settings.DeepEvery = cmd.DeepEvery
+		settings.DeepCPU = cmd.DeepCPU.String()
+	}
+	return settings
+}
+
 func runCapture(cmd *CaptureCmd) error {
 	if cmd.Args.Target == "" {
 		return fmt.Errorf("target URL is required")
 	}
 
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if err := registerProfileTypes(cfg); err != nil {
+		return err
+	}
+
 	// Parse profile types
-	var profiles []models.ProfileType
-	if cmd.Profiles == "all" {
-		profiles = capture.AllProfiles
-	} else {
-		for _, p := range strings.Split(cmd.Profiles, ",") {
-			pt := models.ProfileType(strings.TrimSpace(p))
-			if !pt.IsValid() {
-				return fmt.Errorf("invalid profile type: %s", p)
-			}
-			profiles = append(profiles, pt)
+	names, err := captureProfileNames(cmd, cfg.Capture)
+	if err != nil {
+		return err
+	}
+	profiles, err := parseProfileTypes(names)
+	if err != nil {
+		return err
+	}
+	profileList := strings.Join(names, ",")
+	if len(names) == 0 {
+		profileList = "all"
+	}
+
+	baseline := cmd.Baseline
+	if baseline == "" {
+		baseline = cmd.Session
+	}
+	if cmd.Gate {
+		switch {
+		case cmd.Interval > 0 || cmd.DryRun:
+			return fmt.Errorf("--gate works with a single capture, not --interval or --dry-run")
+		case baseline == "":
+			return fmt.Errorf("--gate needs a --session or --baseline to compare against")
 		}
 	}
 
+	if cmd.Resume && (cmd.Interval == 0 || cmd.Session == "" || cmd.DryRun) {
+		return fmt.Errorf("--resume needs --interval and --session, and doesn't work with --dry-run")
+	}
+	if cmd.Jitter > 0 && (cmd.Interval == 0 || 2*cmd.Jitter >= cmd.Interval) {
+		return fmt.Errorf("--jitter needs an --interval more than twice as long")
+	}
+	if cmd.DeepEvery < 0 || (cmd.DeepEvery > 0 && cmd.Interval == 0) {
+		return fmt.Errorf("--deep-every needs an --interval and a positive round count")
+	}
+
 	// Create capturer
 	c := capture.New(cmd.Args.Target, cmd.Server)
 	c.CPUDuration = cmd.CPUDuration
 	c.Session = cmd.Session
 	c.Project = cmd.Project
+	c.RunID = cmd.RunID
+	c.Compress = cmd.Compress
+	c.Provenance = captureSettings(cmd, profiles)
 
 	// Setup signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -369,93 +638,254 @@ func runCapture(cmd *CaptureCmd) error {
 		cancel()
 	}()
 
+	if cmd.DryRun {
+		return runCaptureDryRun(c, profiles)
+	}
+
 	fmt.Printf("Capturing from %s → %s\n", cmd.Args.Target, cmd.Server)
 	if cmd.Session != "" {
 		fmt.Printf("Session: %s\n", cmd.Session)
 	}
-	if cmd.Interval > 0 {
-		fmt.Printf("Interval: %s | Profiles: %s\n", cmd.Interval, cmd.Profiles)
+	if cmd.Interval > 0 && cmd.Jitter > 0 {
+		fmt.Printf("Interval: %s ±%s | Profiles: %s\n", cmd.Interval, cmd.Jitter, profileList)
+	} else if cmd.Interval > 0 {
+		fmt.Printf("Interval: %s | Profiles: %s\n", cmd.Interval, profileList)
 	} else {
-		fmt.Printf("Profiles: %s\n", cmd.Profiles)
+		fmt.Printf("Profiles: %s\n", profileList)
+	}
+	if cmd.DeepEvery > 0 {
+		fmt.Printf("CPU duration: %s, %s every %d rounds\n", cmd.CPUDuration, cmd.DeepCPU, cmd.DeepEvery)
 	}
 	fmt.Println()
 
-	captureRound := func(round int) bool {
-		if round > 0 {
-			fmt.Printf("[%s] Capture round %d\n", time.Now().Format("15:04:05"), round)
-		} else {
-			fmt.Printf("[%s] Capturing profiles...\n", time.Now().Format("15:04:05"))
-		}
+	session := &capture.Session{
+		Capturer:        c,
+		Profiles:        profiles,
+		Interval:        cmd.Interval,
+		Jitter:          cmd.Jitter,
+		DeepEvery:       cmd.DeepEvery,
+		DeepCPUDuration: cmd.DeepCPU,
+		Count:           cmd.Count,
+		KeepRounds:      1,
+		Context:         cmd.Context != "",
+		ContextEndpoint: cmd.Context,
+		OnRound:         printCaptureRound,
+		OnContext:       printCaptureContext,
+		OnResult: func(result capture.CaptureResult) {
+			// The session sets the round's duration while it captures
+			printCaptureResult(result, c.CPUDuration)
+		},
+	}
+	if cmd.Context == "goroutines" {
+		session.ContextEndpoint = ""
+	}
 
-		for _, pt := range profiles {
-			select {
-			case <-ctx.Done():
-				return false
-			default:
-			}
-
-			result := c.CaptureAndSend(pt)
-			if result.Error != nil {
-				fmt.Printf("  ✗ %-12s %v\n", pt, result.Error)
-			} else {
-				label := "snapshot"
-				if pt.IsCumulative() {
-					label = "cumulative"
-				} else if pt == models.ProfileTypeCPU {
-					label = fmt.Sprintf("%s sample", cmd.CPUDuration)
-				}
-				fmt.Printf("  ✓ %-12s %s  (%s)\n", pt, formatSize(result.Size), label)
-			}
+	if cmd.Interval > 0 && cmd.Resume {
+		var wait time.Duration
+		session.FirstRound, wait, err = resumePoint(ctx, cmd.Server, cmd.Session, cmd.Interval, profiles)
+		if err != nil {
+			return err
+		}
+		if cmd.Count > 0 && session.FirstRound > cmd.Count {
+			fmt.Printf("\nAlready completed %d captures.\n", cmd.Count)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
 		}
-		return true
+	}
+
+	report, err := session.Run(ctx)
+	if err != nil {
+		return err
 	}
 
 	// Single capture mode
 	if cmd.Interval == 0 {
-		captureRound(0)
+		if cmd.Gate {
+			return runGate(ctx, cmd.Server, baseline, cmd.Threshold, cmd.AllowNoBase, cfg.Compare, report.LastRound().Results)
+		}
 		return nil
 	}
 
-	// Interval mode
-	round := 1
-	ticker := time.NewTicker(cmd.Interval)
-	defer ticker.Stop()
+	// Interval mode; a run cancelled mid-round ends without a summary
+	switch last := report.LastRound(); {
+	case report.Completed:
+		fmt.Printf("\nCompleted %d captures.\n", cmd.Count)
+	case last != nil && !last.Interrupted:
+		fmt.Printf("\nCaptured %d rounds.\n", last.Round)
+	}
+	return nil
+}
 
-	// First capture immediately
-	if !captureRound(round) {
-		return nil
+func printCaptureRound(round int, at time.Time) {
+	if round > 0 {
+		fmt.Printf("[%s] Capture round %d\n", at.Format("15:04:05"), round)
+	} else {
+		fmt.Printf("[%s] Capturing profiles...\n", at.Format("15:04:05"))
 	}
-	round++
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Printf("\nCaptured %d rounds.\n", round-1)
-			return nil
-		case <-ticker.C:
-			if cmd.Count > 0 && round > cmd.Count {
-				fmt.Printf("\nCompleted %d captures.\n", cmd.Count)
-				return nil
-			}
-			if !captureRound(round) {
-				return nil
-			}
-			round++
+func printCaptureContext(labels []string, err error) {
+	if err != nil {
+		fmt.Printf("  ✗ %-12s %v\n", "context", err)
+	} else {
+		fmt.Printf("  ✓ %-12s %s\n", "context", strings.Join(labels, " "))
+	}
+}
+
+func printCaptureResult(result capture.CaptureResult, cpuDuration time.Duration) {
+	pt := result.ProfileType
+	if result.Error != nil {
+		fmt.Printf("  ✗ %-12s %v\n", pt, result.Error)
+		return
+	}
+
+	label := "snapshot"
+	if pt.IsCumulative() {
+		label = "cumulative"
+	} else if pt.IsSampled() {
+		label = fmt.Sprintf("%s sample", cpuDuration)
+	}
+	fmt.Printf("  ✓ %-12s %s  (%s)\n", pt, formatSize(result.Size), label)
+	if result.Warning != "" {
+		fmt.Printf("    ! %s\n", result.Warning)
+	}
+}
+
+// captureProfileNames picks the profiles to capture: --profiles, else the
+// --set named in the config, else the config's default profiles. An empty
+// result means all.
+func captureProfileNames(cmd *CaptureCmd, cc config.CaptureConfig) ([]string, error) {
+	switch {
+	case cmd.Profiles != "" && cmd.Set != "":
+		return nil, fmt.Errorf("use either --profiles or --set, not both")
+	case cmd.Profiles != "":
+		return strings.Split(cmd.Profiles, ","), nil
+	case cmd.Set != "":
+		names, ok := cc.Sets[cmd.Set]
+		if !ok {
+			sets := slices.Sorted(maps.Keys(cc.Sets))
+			return nil, fmt.Errorf("unknown profile set %q (available: %s)", cmd.Set, strings.Join(sets, ", "))
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("profile set %q is empty", cmd.Set)
 		}
+		return names, nil
+	default:
+		return cc.DefaultProfiles, nil
 	}
 }
 
-func formatSize(bytes int) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
+// registerProfileTypes makes the config's custom profile types known to
+// capture, ingest and parsing
+func registerProfileTypes(cfg *config.Config) error {
+	for _, pt := range cfg.ProfileTypes {
+		err := models.RegisterProfileType(models.CustomProfileType{
+			Name:       models.ProfileType(pt.Name),
+			Path:       pt.Path,
+			SampleType: pt.SampleType,
+			Cumulative: pt.Cumulative,
+		})
+		if err != nil {
+			return fmt.Errorf("config profile_types: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseProfileTypes validates a list of profile type names; "all" (or an
+// empty list) expands to every pprof profile
+func parseProfileTypes(names []string) ([]models.ProfileType, error) {
+	if len(names) == 0 || (len(names) == 1 && strings.TrimSpace(names[0]) == "all") {
+		return capture.AllProfiles, nil
+	}
+
+	var profiles []models.ProfileType
+	for _, p := range names {
+		pt := models.ProfileType(strings.TrimSpace(p))
+		if !pt.IsValid() {
+			return nil, fmt.Errorf("invalid profile type: %s", p)
+		}
+		profiles = append(profiles, pt)
+	}
+	return profiles, nil
+}
+
+// runCaptureDryRun fetches each profile once and reports its size and
+// headline metric without sending anything to the server
+func runCaptureDryRun(c *capture.Capturer, profiles []models.ProfileType) error {
+	fmt.Printf("Dry run against %s (nothing will be uploaded)\n\n", c.TargetURL)
+
+	var total, failed int
+	for _, pt := range profiles {
+		result := c.CaptureProfile(pt)
+		if result.Error != nil {
+			fmt.Printf("  ✗ %-12s %v\n", pt, result.Error)
+			failed++
+			continue
+		}
+		total += result.Size
+		fmt.Printf("  ✓ %-12s %-10s %s\n", pt, formatSize(result.Size), headlineMetric(pt, result.Data))
+	}
+
+	fmt.Printf("\nEstimated storage per round: %s\n", formatSize(total))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d profiles failed", failed, len(profiles))
+	}
+	return nil
+}
+
+// headlineMetric summarizes a raw profile in one line. Detection from
+// sample types is ambiguous for some profiles (block vs mutex, threadcreate),
+// so the requested type picks the summary.
+func headlineMetric(pt models.ProfileType, data []byte) string {
+	if pt == models.ProfileTypeRuntime {
+		m, err := runtimestats.Parse(data)
+		if err != nil {
+			return fmt.Sprintf("(unparseable: %v)", err)
+		}
+		return fmt.Sprintf("heap inuse %s, %d GCs", formatSize(int(m.HeapInuse)), m.NumGC)
+	}
+	if pt == models.ProfileTypeTrace {
+		m, err := trace.Parse(data)
+		if err != nil {
+			return fmt.Sprintf("(unparseable: %v)", err)
+		}
+		return fmt.Sprintf("sched latency %s, %d goroutines", time.Duration(m.SchedLatencyNS), m.GoroutineCount)
+	}
+
+	parsed, err := pprof.ParseAs(data, pt)
+	if err != nil {
+		return fmt.Sprintf("(unparseable: %v)", err)
 	}
-	div, exp := int64(unit), 0
-	for n := int64(bytes) / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+
+	switch m := parsed.Metrics.(type) {
+	case *models.CPUMetrics:
+		if pt == models.ProfileTypeCPU {
+			return fmt.Sprintf("cpu time %s", time.Duration(m.TotalCPUTimeNS))
+		}
+	case *models.WallMetrics:
+		return fmt.Sprintf("wall time %s", time.Duration(m.TotalWallTimeNS))
+	case *models.HeapMetrics:
+		return fmt.Sprintf("inuse %s", formatSize(int(m.InuseSize)))
+	case *models.AllocsMetrics:
+		return fmt.Sprintf("allocated %s", formatSize(int(m.AllocSize)))
+	case *models.MutexMetrics:
+		if pt == models.ProfileTypeBlock {
+			return fmt.Sprintf("blocking %s", time.Duration(m.ContentionTimeNS))
+		}
+		return fmt.Sprintf("contention %s", time.Duration(m.ContentionTimeNS))
+	case *models.GoroutineMetrics:
+		return fmt.Sprintf("%d goroutines", m.GoroutineCount)
 	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+	return fmt.Sprintf("%d samples", parsed.TotalSamples)
+}
+
+func formatSize(bytes int) string {
+	return format.Bytes(int64(bytes))
 }
 
 func runSessionLs() error {
@@ -482,6 +912,14 @@ func runSessionLs() error {
 	}
 
 	for _, session := range sessions {
+		health, err := store.SessionHealth(ctx, session)
+		if err != nil {
+			return fmt.Errorf("session health: %w", err)
+		}
+		if health.Stalled {
+			fmt.Printf("%s  (stale: last capture %s ago)\n", session, time.Duration(health.SinceLastNS).Round(time.Second))
+			continue
+		}
 		fmt.Println(session)
 	}
 	return nil
@@ -506,9 +944,14 @@ func runSessionProfiles(sessionName string) error {
 	}
 
 	if len(profiles) == 0 {
-		fmt.Printf("No profiles found in session %q.\n", sessionName)
-		return nil
+		return notFound("no profiles in session %q", sessionName)
+	}
+
+	summary, err := store.SessionSummary(ctx, sessionName)
+	if err != nil {
+		return fmt.Errorf("summarize session: %w", err)
 	}
+	printSessionSummary(summary)
 
 	for _, p := range profiles {
 		fmt.Printf("%s  %-12s  %s  %s\n", p.ID, p.ProfileType, p.CreatedAt.Format("2006-01-02 15:04:05"), p.Name)
@@ -516,7 +959,24 @@ func runSessionProfiles(sessionName string) error {
 	return nil
 }
 
-func runGet(sessionName, profileID string, raw bool) error {
+// printSessionSummary prints a session's per-type rollup as a header
+func printSessionSummary(summary *models.SessionSummary) {
+	fmt.Printf("Session %s: %d profiles\n", summary.Session, summary.ProfileCount)
+	for _, t := range summary.Types {
+		if t.Metric == "" {
+			fmt.Printf("  %-12s  %4d\n", t.ProfileType, t.Count)
+			continue
+		}
+		fmt.Printf("  %-12s  %4d  %s avg %s, min %s, max %s\n", t.ProfileType, t.Count, t.Metric,
+			format.Value(t.Metric, *t.Avg), format.Value(t.Metric, *t.Min), format.Value(t.Metric, *t.Max))
+	}
+	for _, p := range summary.Provenance {
+		fmt.Printf("  captured %s (%s): %s\n", p.CreatedAt.Local().Format("2006-01-02 15:04:05"), p.ProfileID, p.Settings)
+	}
+	fmt.Println()
+}
+
+func runGet(sessionName, profileID string, raw, diffPrev bool) error {
 	cfg, err := config.Load(opts.Config)
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
@@ -530,13 +990,16 @@ func runGet(sessionName, profileID string, raw bool) error {
 
 	ctx := context.Background()
 	profile, err := store.GetProfile(ctx, profileID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return notFound("no profile with ID %s in session %q", profileID, sessionName)
+	}
 	if err != nil {
 		return fmt.Errorf("get profile: %w", err)
 	}
 
-	// Verify the profile belongs to the specified session
+	// A profile from another session is as good as missing here
 	if profile.Session != sessionName {
-		return fmt.Errorf("profile %s does not belong to session %q", profileID, sessionName)
+		return notFound("no profile with ID %s in session %q", profileID, sessionName)
 	}
 
 	if raw {
@@ -544,8 +1007,75 @@ func runGet(sessionName, profileID string, raw bool) error {
 		return err
 	}
 
+	if diffPrev {
+		prev, err := store.PreviousProfile(ctx, profile)
+		if err != nil {
+			return fmt.Errorf("find previous profile: %w", err)
+		}
+		if prev == nil {
+			fmt.Printf("No earlier %s profile in session %q to compare against.\n", profile.ProfileType, sessionName)
+			return nil
+		}
+		return printMetricsDiff(prev, profile, "PREVIOUS", "CURRENT")
+	}
+
 	// Output profile metadata as JSON
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
 	return enc.Encode(profile)
 }
+
+// printMetricsDiff prints a delta table of the numeric metrics two profiles
+// share, labelling the value columns with before and after
+func printMetricsDiff(prev, cur *models.Profile, before, after string) error {
+	var prevMetrics, curMetrics map[string]any
+	if len(prev.Metrics) > 0 {
+		if err := json.Unmarshal(prev.Metrics, &prevMetrics); err != nil {
+			return fmt.Errorf("decode metrics of %s: %w", prev.ID, err)
+		}
+	}
+	if len(cur.Metrics) > 0 {
+		if err := json.Unmarshal(cur.Metrics, &curMetrics); err != nil {
+			return fmt.Errorf("decode metrics of %s: %w", cur.ID, err)
+		}
+	}
+
+	keys := make([]string, 0, len(curMetrics))
+	for k := range curMetrics {
+		if _, ok := curMetrics[k].(float64); !ok {
+			continue
+		}
+		if _, ok := prevMetrics[k].(float64); !ok {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("%s (%s)  →  %s (%s)\n\n",
+		prev.Name, prev.CreatedAt.Format("2006-01-02 15:04:05"),
+		cur.Name, cur.CreatedAt.Format("2006-01-02 15:04:05"))
+
+	if len(keys) == 0 {
+		fmt.Println("No comparable metrics.")
+		return nil
+	}
+
+	fmt.Printf("%-22s %16s %16s %16s %9s\n", "METRIC", before, after, "DELTA", "CHANGE")
+	for _, k := range keys {
+		a, b := prevMetrics[k].(float64), curMetrics[k].(float64)
+		change := "—"
+		if a != 0 {
+			change = fmt.Sprintf("%+.1f%%", (b-a)/a*100)
+		}
+		fmt.Printf("%-22s %16s %16s %16s %9s\n", k, formatMetric(a), formatMetric(b), formatMetric(b-a), change)
+	}
+	return nil
+}
+
+func formatMetric(v float64) string {
+	if v == float64(int64(v)) {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type OpenCmd struct {
+	HTTP      string `long:"http" description:"Address for the pprof web UI to listen on" default:":0"`
+	NoBrowser bool   `long:"no-browser" description:"Don't open a browser, just print the pprof web UI address"`
+	Args      struct {
+		SessionName string `positional-arg-name:"session" description:"Session name" required:"yes"`
+		ProfileID   string `positional-arg-name:"profile_id" description:"Profile ID (default: the session's newest pprof profile)"`
+	} `positional-args:"yes"`
+}
+
+func (c *OpenCmd) Execute(args []string) error {
+	return runOpen(c)
+}
+
+// runOpen writes a stored profile to a temp file and serves it with
+// `go tool pprof -http` until the user interrupts it
+func runOpen(cmd *OpenCmd) error {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return errors.New("go toolchain not found in PATH: perfkit open runs `go tool pprof`; install Go from https://go.dev/dl, or save the profile with `perfkit get --raw` and open it elsewhere")
+	}
+
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	profile, err := openTarget(context.Background(), store, cmd.Args.SessionName, cmd.Args.ProfileID)
+	if err != nil {
+		return err
+	}
+	if !profile.ProfileType.IsPprof() {
+		return fmt.Errorf("%s profiles aren't pprof data and can't be opened in pprof", profile.ProfileType)
+	}
+
+	f, err := os.CreateTemp("", "perfkit-"+string(profile.ProfileType)+"-*.pb.gz")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(profile.RawData); err != nil {
+		f.Close()
+		return fmt.Errorf("write profile: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write profile: %w", err)
+	}
+
+	// Run the pprof binary itself: `go tool` reports a Ctrl+C as a failure
+	out, err := exec.Command(goBin, "tool", "-n", "pprof").Output()
+	if err != nil {
+		return fmt.Errorf("locate go tool pprof: %w", err)
+	}
+	pprofBin := strings.TrimSpace(string(out))
+
+	args := []string{"-http=" + cmd.HTTP}
+	if cmd.NoBrowser {
+		args = append(args, "-no_browser")
+	}
+	args = append(args, f.Name())
+
+	fmt.Printf("Opening %s profile %s (%s) in pprof, Ctrl+C to stop\n", profile.ProfileType, profile.ID, filepath.Base(f.Name()))
+
+	// pprof exits on Ctrl+C; keep perfkit alive until it has, so the temp
+	// file gets removed
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	pprofCmd := exec.Command(pprofBin, args...)
+	pprofCmd.Stdin = os.Stdin
+	pprofCmd.Stdout = os.Stdout
+	pprofCmd.Stderr = os.Stderr
+	err = pprofCmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && !exitErr.Exited() {
+		// Stopped by a signal, the way pprof's web UI is meant to end
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("pprof: %w", err)
+	}
+	return nil
+}
+
+// openTarget returns the profile to open: the given one, which must be in
+// the session, or the session's newest pprof profile
+func openTarget(ctx context.Context, store *storage.Store, sessionName, profileID string) (*models.Profile, error) {
+	if profileID != "" {
+		profile, err := store.GetProfile(ctx, profileID)
+		if errors.Is(err, storage.ErrNotFound) || (err == nil && profile.Session != sessionName) {
+			return nil, notFound("no profile with ID %s in session %q", profileID, sessionName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get profile: %w", err)
+		}
+		return profile, nil
+	}
+
+	profiles, err := store.ListProfilesBySession(ctx, sessionName)
+	if err != nil {
+		return nil, fmt.Errorf("list profiles: %w", err)
+	}
+	var newest *models.Profile
+	for _, p := range profiles {
+		if p.ProfileType.IsPprof() && (newest == nil || p.CreatedAt.After(newest.CreatedAt)) {
+			newest = p
+		}
+	}
+	if newest == nil {
+		return nil, notFound("no pprof profiles in session %q", sessionName)
+	}
+
+	// Listings leave out the raw data
+	profile, err := store.GetProfile(ctx, newest.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get profile: %w", err)
+	}
+	return profile, nil
+}
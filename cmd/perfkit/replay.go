@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flaticols/perfkit/client"
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type ReplayCmd struct {
+	Session   string        `short:"s" long:"session" description:"Only replay profiles from this session"`
+	Type      string        `short:"t" long:"type" description:"Only replay profiles of this type"`
+	Project   string        `long:"project" description:"Only replay profiles from this project"`
+	Since     time.Duration `long:"since" description:"Only replay profiles created within this duration (e.g., 24h)"`
+	DryRun    bool          `long:"dry-run" description:"List profiles that would be replayed without sending them"`
+	ContentID bool          `long:"content-id" description:"Derive profile IDs from their content, so replaying the same profiles again stores no duplicates"`
+	Args      struct {
+		Target string `positional-arg-name:"target" description:"Target perfkit server URL (e.g., http://perfkit.internal:8080)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *ReplayCmd) Execute(args []string) error {
+	return runReplay(c)
+}
+
+func runReplay(cmd *ReplayCmd) error {
+	if cmd.Args.Target == "" {
+		return fmt.Errorf("target URL is required")
+	}
+	if cmd.Type != "" && !models.ProfileType(cmd.Type).IsValid() {
+		return fmt.Errorf("invalid profile type: %s", cmd.Type)
+	}
+
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	filter := storage.ProfileFilter{
+		Session:     cmd.Session,
+		ProfileType: cmd.Type,
+		Project:     cmd.Project,
+	}
+	if cmd.Since > 0 {
+		filter.Since = time.Now().Add(-cmd.Since)
+	}
+
+	ctx := context.Background()
+	profiles, err := store.FindProfiles(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("list profiles: %w", err)
+	}
+
+	if len(profiles) == 0 {
+		fmt.Println("No profiles to replay.")
+		return nil
+	}
+
+	target := strings.TrimRight(cmd.Args.Target, "/")
+	if cmd.DryRun {
+		fmt.Printf("Would replay %d profiles → %s\n\n", len(profiles), target)
+	} else {
+		fmt.Printf("Replaying %d profiles → %s\n\n", len(profiles), target)
+	}
+
+	httpClient := &http.Client{Timeout: 5 * time.Minute}
+	var failed, skipped int
+	for _, p := range profiles {
+		if cmd.DryRun {
+			fmt.Printf("  - %s  %-12s  %s  %s\n", p.ID, p.ProfileType, formatSize(p.RawSize), p.Name)
+			continue
+		}
+
+		// List queries omit raw data, so fetch the full record
+		full, err := store.GetProfile(ctx, p.ID)
+		if err != nil {
+			fmt.Printf("  ✗ %s  %v\n", p.ID, err)
+			failed++
+			continue
+		}
+
+		duplicate, err := replayProfile(httpClient, target, full, cmd.ContentID)
+		if err != nil {
+			fmt.Printf("  ✗ %s  %v\n", p.ID, err)
+			failed++
+			continue
+		}
+		if duplicate {
+			fmt.Printf("  = %s  %-12s  already on the target\n", p.ID, p.ProfileType)
+			skipped++
+			continue
+		}
+		fmt.Printf("  ✓ %s  %-12s  %s  %s\n", p.ID, p.ProfileType, formatSize(p.RawSize), p.Name)
+	}
+	if skipped > 0 {
+		fmt.Printf("\n%d profiles were already on the target\n", skipped)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d profiles failed to replay", failed, len(profiles))
+	}
+	return nil
+}
+
+// replayProfile POSTs a stored profile to the target's ingest endpoint,
+// carrying its metadata over as query params. With contentID it reports
+// whether the target already had the profile.
+func replayProfile(httpClient *http.Client, target string, p *models.Profile, contentID bool) (bool, error) {
+	ingestURL, err := url.Parse(target + client.IngestPath(p.ProfileType))
+	if err != nil {
+		return false, fmt.Errorf("parse target URL: %w", err)
+	}
+
+	q := ingestURL.Query()
+	q.Set("type", string(p.ProfileType))
+	q.Set("name", p.Name)
+	q.Set("created_at", p.CreatedAt.Format(time.RFC3339Nano))
+	if p.ProfileTime != nil {
+		q.Set("profile_time", p.ProfileTime.Format(time.RFC3339Nano))
+	}
+	if p.WindowNS > 0 {
+		q.Set("window", time.Duration(p.WindowNS).String())
+	}
+	if p.Session != "" {
+		q.Set("session", p.Session)
+	}
+	if p.Project != "" {
+		q.Set("project", p.Project)
+	}
+	if p.Host != "" {
+		q.Set("host", p.Host)
+	}
+	if p.Source != "" {
+		q.Set("source", p.Source)
+	}
+	if p.IsCumulative {
+		q.Set("cumulative", "true")
+	}
+	// The source server accepted the type, heap or allocs alike
+	q.Set("force", "true")
+	if contentID {
+		q.Set("content_id", "true")
+	}
+	for _, tag := range p.Tags {
+		q.Add("tag", tag)
+	}
+	ingestURL.RawQuery = q.Encode()
+
+	resp, err := httpClient.Post(ingestURL.String(), "application/octet-stream", bytes.NewReader(p.RawData))
+	if err != nil {
+		return false, fmt.Errorf("send to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("server error: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Duplicate bool `json:"duplicate"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result.Duplicate, nil
+}
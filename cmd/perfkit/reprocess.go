@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/k6"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/pprof"
+	"github.com/flaticols/perfkit/internal/runtimestats"
+	"github.com/flaticols/perfkit/internal/storage"
+	"github.com/flaticols/perfkit/internal/trace"
+)
+
+type ReprocessCmd struct {
+	Session string `short:"s" long:"session" description:"Only reprocess profiles from this session"`
+	Type    string `short:"t" long:"type" description:"Only reprocess profiles of this type"`
+	Project string `long:"project" description:"Only reprocess profiles from this project"`
+	DryRun  bool   `long:"dry-run" description:"Report which profiles' metrics would change without saving them"`
+}
+
+func (c *ReprocessCmd) Execute(args []string) error {
+	return runReprocess(c)
+}
+
+func runReprocess(cmd *ReprocessCmd) error {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if err := registerProfileTypes(cfg); err != nil {
+		return err
+	}
+
+	if cmd.Type != "" && !models.ProfileType(cmd.Type).IsValid() {
+		return fmt.Errorf("invalid profile type: %s", cmd.Type)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	profiles, err := store.FindProfiles(ctx, storage.ProfileFilter{
+		Session:     cmd.Session,
+		ProfileType: cmd.Type,
+		Project:     cmd.Project,
+	})
+	if err != nil {
+		return fmt.Errorf("list profiles: %w", err)
+	}
+
+	if len(profiles) == 0 {
+		fmt.Println("No profiles to reprocess.")
+		return nil
+	}
+
+	if cmd.DryRun {
+		fmt.Printf("Checking %d profiles (dry run)\n\n", len(profiles))
+	} else {
+		fmt.Printf("Reprocessing %d profiles\n\n", len(profiles))
+	}
+
+	var changed, failed int
+	for _, p := range profiles {
+		// List queries omit raw data and metrics, so fetch the full record
+		full, err := store.GetProfile(ctx, p.ID)
+		if err != nil {
+			fmt.Printf("  ✗ %s  %v\n", p.ID, err)
+			failed++
+			continue
+		}
+
+		before, beforeTypes := full.Metrics, full.SampleTypes
+		if err := reparseProfile(full); err != nil {
+			fmt.Printf("  ✗ %s  %-12s  %v\n", p.ID, p.ProfileType, err)
+			failed++
+			continue
+		}
+		if bytes.Equal(before, full.Metrics) && slices.Equal(beforeTypes, full.SampleTypes) {
+			fmt.Printf("  = %s  %-12s  %s\n", p.ID, p.ProfileType, p.Name)
+			continue
+		}
+		changed++
+
+		if cmd.DryRun {
+			fmt.Printf("  ~ %s  %-12s  %s\n", p.ID, p.ProfileType, p.Name)
+			continue
+		}
+		full.UpdatedAt = time.Now()
+		if err := store.UpdateMetrics(ctx, full); err != nil {
+			fmt.Printf("  ✗ %s  %-12s  %v\n", p.ID, p.ProfileType, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  ✓ %s  %-12s  %s\n", p.ID, p.ProfileType, p.Name)
+	}
+
+	verb := "updated"
+	if cmd.DryRun {
+		verb = "would change"
+	}
+	fmt.Printf("\n%d %s, %d unchanged, %d failed\n", changed, verb, len(profiles)-changed-failed, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d profiles failed to reprocess", failed, len(profiles))
+	}
+	return nil
+}
+
+// reparseProfile runs a stored profile's raw data back through the current
+// parser for its type, replacing its metrics and quick-access fields the
+// same way ingest fills them.
+func reparseProfile(p *models.Profile) error {
+	var metrics any
+	switch p.ProfileType {
+	case models.ProfileTypeK6:
+		parsed, err := k6.Parse(p.RawData)
+		if err != nil {
+			return fmt.Errorf("parse k6 summary: %w", err)
+		}
+		p.DurationNS = parsed.DurationMS * 1_000_000
+		p.K6P95, p.K6P99, p.K6RPS, p.K6ErrorRate, p.K6DurationMS = nil, nil, nil, nil, nil
+		if parsed.Metrics != nil {
+			if parsed.Metrics.P95 > 0 {
+				p.K6P95 = &parsed.Metrics.P95
+			}
+			if parsed.Metrics.P99 > 0 {
+				p.K6P99 = &parsed.Metrics.P99
+			}
+			if parsed.Metrics.RPS > 0 {
+				p.K6RPS = &parsed.Metrics.RPS
+			}
+			p.K6ErrorRate = &parsed.Metrics.ErrorRate
+			if parsed.DurationMS > 0 {
+				p.K6DurationMS = &parsed.DurationMS
+			}
+			metrics = parsed.Metrics
+		}
+
+	case models.ProfileTypeRuntime:
+		parsed, err := runtimestats.Parse(p.RawData)
+		if err != nil {
+			return fmt.Errorf("parse runtime metrics: %w", err)
+		}
+		metrics = parsed
+
+	case models.ProfileTypeTrace:
+		parsed, err := trace.Parse(p.RawData)
+		if err != nil {
+			return fmt.Errorf("parse trace: %w", err)
+		}
+		p.DurationNS = parsed.DurationNS
+		metrics = parsed
+
+	default:
+		parsed, err := pprof.ParseAs(p.RawData, p.ProfileType)
+		if err != nil {
+			return fmt.Errorf("parse pprof: %w", err)
+		}
+		p.DurationNS = parsed.DurationNS
+		p.SampleTypes = parsed.SampleTypes
+		// A decimated profile keeps its sample count from before decimation,
+		// which the stored data no longer shows
+		if p.StoredSamples == nil {
+			p.TotalSamples = nil
+			if parsed.TotalSamples > 0 {
+				p.TotalSamples = &parsed.TotalSamples
+			}
+		}
+		p.TotalValue = nil
+		// Diff profiles can have a negative total
+		if parsed.TotalValue != 0 {
+			p.TotalValue = &parsed.TotalValue
+		}
+		// A rate comes from the previous capture, not the raw data
+		if m, ok := parsed.Metrics.(models.CounterMetrics); ok {
+			if rate, ok := p.MetricValue("rate"); ok {
+				m.SetRate(rate)
+			}
+		}
+		metrics = parsed.Metrics
+	}
+
+	p.Metrics = nil
+	if metrics != nil {
+		metricsJSON, err := json.Marshal(metrics)
+		if err != nil {
+			return fmt.Errorf("marshal metrics: %w", err)
+		}
+		p.Metrics = models.NullableJSON(metricsJSON)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/flaticols/perfkit/client"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// resumePoint works out where an interrupted interval capture left off in
+// session: the round to continue from, taken from the most captured of the
+// requested profile types so a failed capture doesn't throw the count off,
+// and how long to wait so the next round keeps the old cadence. An empty
+// session starts at round 1 straight away.
+func resumePoint(ctx context.Context, serverURL, session string, interval time.Duration, profiles []models.ProfileType) (round int, wait time.Duration, err error) {
+	cl := client.New(serverURL)
+
+	summary, err := cl.SessionSummary(ctx, session)
+	if client.IsNotFound(err) {
+		fmt.Printf("Resume: session %s has no profiles yet, starting fresh\n", session)
+		return 1, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("session summary: %w", err)
+	}
+
+	var done int
+	for _, t := range summary.Types {
+		if slices.Contains(profiles, t.ProfileType) {
+			done = max(done, t.Count)
+		}
+	}
+	if done == 0 {
+		fmt.Printf("Resume: session %s has none of the requested profiles, starting fresh\n", session)
+		return 1, 0, nil
+	}
+
+	health, err := cl.SessionHealth(ctx, session)
+	if err != nil {
+		return 0, 0, fmt.Errorf("session health: %w", err)
+	}
+
+	if health.LastCapture != nil {
+		since := time.Since(*health.LastCapture)
+		wait = max(interval-since, 0)
+		fmt.Printf("Resume: %d rounds in session %s, last capture %s ago", done, session, since.Round(time.Second))
+		if wait > 0 {
+			fmt.Printf(", next in %s", wait.Round(time.Second))
+		}
+		fmt.Println()
+	}
+	return done + 1, wait, nil
+}
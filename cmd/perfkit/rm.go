@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flaticols/perfkit/client"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+type RmCmd struct {
+	Server  string `long:"server" description:"Perfkit server URL" default:"http://localhost:8080"`
+	Session string `short:"s" long:"session" description:"Only delete profiles of this session"`
+	Type    string `short:"t" long:"type" description:"Only delete profiles of this type"`
+	Project string `long:"project" description:"Only delete profiles of this project"`
+	Host    string `long:"host" description:"Only delete profiles from this host"`
+	Tag     string `long:"tag" description:"Only delete profiles with this exact tag (e.g. env=staging)"`
+	Before  string `long:"before" description:"Only delete profiles created before this time (RFC3339) or longer ago than this duration (e.g. 720h)"`
+	Yes     bool   `short:"y" long:"yes" description:"Delete the matching profiles; without it they're only counted"`
+}
+
+func (c *RmCmd) Execute(args []string) error {
+	return runRm(c)
+}
+
+// runRm deletes every profile matching the filters on a server in one go.
+// Without --yes it only reports how many would go. The server refuses to
+// run without any filter.
+func runRm(cmd *RmCmd) error {
+	opts := client.DeleteOptions{
+		Type:    models.ProfileType(cmd.Type),
+		Session: cmd.Session,
+		Project: cmd.Project,
+		Host:    cmd.Host,
+		Tag:     cmd.Tag,
+		Confirm: cmd.Yes,
+	}
+	if cmd.Before != "" {
+		before, err := parseBefore(cmd.Before, time.Now())
+		if err != nil {
+			return err
+		}
+		opts.Before = before
+	}
+
+	result, err := client.New(cmd.Server).DeleteWhere(context.Background(), opts)
+	if err != nil {
+		return fmt.Errorf("delete profiles: %w", err)
+	}
+
+	if !cmd.Yes {
+		fmt.Printf("%d profiles match; rerun with --yes to delete them\n", result.Matched)
+		return nil
+	}
+	fmt.Printf("Deleted %d profiles\n", result.Deleted)
+	return nil
+}
+
+// parseBefore reads --before as a timestamp, or as an age relative to now
+func parseBefore(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("--before must be an RFC3339 time or a positive duration, got %q", v)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/flaticols/perfkit/client"
+	"github.com/flaticols/perfkit/internal/capture"
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/server"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type SelftestCmd struct {
+	CPUDuration time.Duration `long:"cpu-duration" description:"CPU profile duration" default:"1s"`
+	Verbose     bool          `short:"v" long:"verbose" description:"Show the in-process server's log"`
+}
+
+func (c *SelftestCmd) Execute(args []string) error {
+	return runSelftest(c)
+}
+
+// selftestSession groups the profiles the self-test captures
+const selftestSession = "selftest"
+
+// selftestTypes are captured from the in-process target; heap twice, so
+// there is a pair to compare
+var selftestTypes = []models.ProfileType{
+	models.ProfileTypeHeap,
+	models.ProfileTypeGoroutine,
+	models.ProfileTypeCPU,
+	models.ProfileTypeHeap,
+}
+
+// runSelftest runs the whole pipeline inside this process: a pprof target
+// and a perfkit server with in-memory storage, each on a random local port,
+// then captures, lists, compares and deletes through the HTTP API. Each
+// stage prints a ✓ or ✗ line, and the first failure stops the run.
+func runSelftest(cmd *SelftestCmd) error {
+	if !cmd.Verbose {
+		defer log.SetOutput(log.Writer())
+		log.SetOutput(io.Discard)
+	}
+	ctx := context.Background()
+
+	stage := func(name string, err error, detail string) error {
+		if err != nil {
+			fmt.Printf("  ✗ %-10s %v\n", name, err)
+			return fmt.Errorf("selftest failed at %s: %w", name, err)
+		}
+		fmt.Printf("  ✓ %-10s %s\n", name, detail)
+		return nil
+	}
+
+	fmt.Println("Running perfkit self-test")
+	fmt.Println()
+
+	target, stopTarget, err := startSelftestTarget()
+	if err := stage("target", err, target); err != nil {
+		return err
+	}
+	defer stopTarget()
+
+	serverURL, stopServer, err := startSelftestServer()
+	if err := stage("server", err, serverURL+" (in-memory storage)"); err != nil {
+		return err
+	}
+	defer stopServer()
+
+	c := capture.New(target, serverURL)
+	c.CPUDuration = cmd.CPUDuration
+	c.Session = selftestSession
+	c.Source = "selftest"
+
+	var ids, heapIDs []string
+	for _, pt := range selftestTypes {
+		result := c.CaptureAndSend(pt)
+		if result.Error == nil && result.ID == "" {
+			result.Error = errors.New("server returned no profile ID")
+		}
+		if err := stage("capture", result.Error, fmt.Sprintf("%-10s %s", pt, formatSize(result.Size))); err != nil {
+			return err
+		}
+		ids = append(ids, result.ID)
+		if pt == models.ProfileTypeHeap {
+			heapIDs = append(heapIDs, result.ID)
+		}
+	}
+
+	cl := client.New(serverURL)
+	cl.MaxRetries = 0
+
+	listed, err := cl.ListProfiles(ctx, client.ListOptions{Session: selftestSession})
+	if err == nil && len(listed) != len(ids) {
+		err = fmt.Errorf("listed %d profiles, expected %d", len(listed), len(ids))
+	}
+	if err := stage("list", err, fmt.Sprintf("%d profiles in session %s", len(listed), selftestSession)); err != nil {
+		return err
+	}
+
+	compared, err := cl.Compare(ctx, heapIDs...)
+	var detail string
+	if err == nil {
+		detail, err = selftestCompareDetail(compared)
+	}
+	if err := stage("compare", err, detail); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = cl.Delete(ctx, id); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if remaining, listErr := cl.ListProfiles(ctx, client.ListOptions{Session: selftestSession}); listErr != nil {
+			err = listErr
+		} else if len(remaining) > 0 {
+			err = fmt.Errorf("%d profiles left after delete", len(remaining))
+		}
+	}
+	if err := stage("delete", err, fmt.Sprintf("%d profiles", len(ids))); err != nil {
+		return err
+	}
+
+	fmt.Println("\nAll stages passed.")
+	return nil
+}
+
+// selftestCompareDetail checks a compare returned both heap profiles with
+// metrics and describes the change in their headline metric
+func selftestCompareDetail(profiles []*models.Profile) (string, error) {
+	if len(profiles) != 2 {
+		return "", fmt.Errorf("compare returned %d profiles, expected 2", len(profiles))
+	}
+	key := models.HeadlineMetrics[models.ProfileTypeHeap]
+	a, okA := profiles[0].MetricValue(key)
+	b, okB := profiles[1].MetricValue(key)
+	if !okA || !okB {
+		return "", fmt.Errorf("compared profiles have no %s metric", key)
+	}
+	return fmt.Sprintf("heap %s: %s → %s", key, formatSize(int(a)), formatSize(int(b))), nil
+}
+
+// startSelftestTarget serves net/http/pprof on a random local port, with a
+// little work going on so the profiles aren't empty
+func startSelftestTarget() (string, func(), error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+
+	done := make(chan struct{})
+	go selftestWork(done)
+
+	stop := func() {
+		close(done)
+		srv.Close()
+	}
+	return "http://" + l.Addr().String(), stop, nil
+}
+
+// selftestSink keeps selftestWork's allocations live for the heap profile
+var selftestSink [][]byte
+
+func selftestWork(done <-chan struct{}) {
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			selftestSink = nil
+			return
+		default:
+		}
+		selftestSink = append(selftestSink, make([]byte, 1024))
+		if len(selftestSink) > 4096 {
+			selftestSink = selftestSink[:0]
+		}
+		if i%1000 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// startSelftestServer runs a perfkit server with in-memory storage on a
+// random local port
+func startSelftestServer() (string, func(), error) {
+	store, err := storage.NewMemory()
+	if err != nil {
+		return "", nil, fmt.Errorf("open storage: %w", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		store.Close()
+		return "", nil, err
+	}
+
+	srv := server.New(config.Default(), store)
+	go srv.Serve(l)
+
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		store.Close()
+	}
+
+	// Make sure it answers before anything depends on it
+	serverURL := "http://" + l.Addr().String()
+	resp, err := http.Get(serverURL + "/api/config")
+	if err != nil {
+		// It may not be serving at all, so there's nothing to shut down
+		l.Close()
+		store.Close()
+		return "", nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		stop()
+		return "", nil, fmt.Errorf("server answered %s", resp.Status)
+	}
+	return serverURL, stop, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type SessionCloneCmd struct {
+	Args struct {
+		Source string `positional-arg-name:"src" description:"Session to copy" required:"yes"`
+		Dest   string `positional-arg-name:"dst" description:"New session to copy it into" required:"yes"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *SessionCloneCmd) Execute(args []string) error {
+	return runSessionClone(c.Args.Source, c.Args.Dest)
+}
+
+// runSessionClone snapshots a session's profiles into a new one, to compare
+// against while the original keeps receiving captures
+func runSessionClone(src, dst string) error {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	n, err := store.CountSessionProfiles(ctx, src)
+	if err != nil {
+		return fmt.Errorf("count profiles: %w", err)
+	}
+	if n == 0 {
+		return notFound("no profiles in session %q", src)
+	}
+
+	if err := store.CloneSession(ctx, src, dst); err != nil {
+		return fmt.Errorf("clone session: %w", err)
+	}
+
+	fmt.Printf("Cloned %d profiles from %q into %q, tagged %s\n", n, src, dst, models.TagBaseline)
+	return nil
+}
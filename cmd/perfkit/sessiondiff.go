@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/storage"
+)
+
+type SessionDiffCmd struct {
+	Threshold float64 `long:"threshold" description:"Percent change in a headline metric needed for a verdict (default: the config's compare tolerance)"`
+	Args      struct {
+		Base   string `positional-arg-name:"base" description:"Baseline session" required:"yes"`
+		Target string `positional-arg-name:"target" description:"Session to judge against the baseline" required:"yes"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *SessionDiffCmd) Execute(args []string) error {
+	return runSessionDiff(c)
+}
+
+// errRegression makes session diff exit with exitRegression, for CI gates
+var errRegression = errors.New("regression detected")
+
+func runSessionDiff(cmd *SessionDiffCmd) error {
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := storage.New(cfg.DBPath())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	base, err := latestByType(ctx, store, cmd.Args.Base)
+	if err != nil {
+		return err
+	}
+	target, err := latestByType(ctx, store, cmd.Args.Target)
+	if err != nil {
+		return err
+	}
+
+	var types []models.ProfileType
+	for pt := range base {
+		if _, ok := target[pt]; ok {
+			types = append(types, pt)
+		}
+	}
+	if len(types) == 0 {
+		return fmt.Errorf("sessions %q and %q have no profile types in common", cmd.Args.Base, cmd.Args.Target)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	verdicts := make(map[models.ProfileType]string, len(types))
+	var regressed int
+	for _, pt := range types {
+		// Listings omit metrics, so load the full records
+		b, err := store.GetProfile(ctx, base[pt].ID)
+		if err != nil {
+			return fmt.Errorf("get profile: %w", err)
+		}
+		t, err := store.GetProfile(ctx, target[pt].ID)
+		if err != nil {
+			return fmt.Errorf("get profile: %w", err)
+		}
+
+		fmt.Printf("== %s ==\n", pt)
+		if err := printMetricsDiff(b, t, "BASE", "TARGET"); err != nil {
+			return err
+		}
+
+		verdict, detail := judge(pt, b, t, tolerance(cmd.Threshold, cfg.Compare, pt))
+		if verdict == models.VerdictRegressed {
+			regressed++
+		}
+		verdicts[pt] = verdict + detail
+		fmt.Println()
+	}
+
+	fmt.Printf("%s → %s\n", cmd.Args.Base, cmd.Args.Target)
+	for _, pt := range types {
+		mark := "✓"
+		if strings.HasPrefix(verdicts[pt], models.VerdictRegressed) {
+			mark = "✗"
+		}
+		fmt.Printf("  %s %-12s %s\n", mark, pt, verdicts[pt])
+	}
+
+	if regressed > 0 {
+		return fmt.Errorf("%w in %d of %d profile types", errRegression, regressed, len(types))
+	}
+	return nil
+}
+
+// latestByType returns the newest profile of each type in a session
+func latestByType(ctx context.Context, store *storage.Store, session string) (map[models.ProfileType]*models.Profile, error) {
+	profiles, err := store.ListProfilesBySession(ctx, session)
+	if err != nil {
+		return nil, fmt.Errorf("list profiles: %w", err)
+	}
+	if len(profiles) == 0 {
+		return nil, notFound("no profiles in session %q", session)
+	}
+
+	// Listed newest first, so the first of each type wins
+	latest := make(map[models.ProfileType]*models.Profile)
+	for _, p := range profiles {
+		if _, ok := latest[p.ProfileType]; !ok {
+			latest[p.ProfileType] = p
+		}
+	}
+	return latest, nil
+}
+
+// verdictNone is shown for profile types that can't be judged
+const verdictNone = "no verdict"
+
+// tolerance is the --threshold flag when given, else the configured noise
+// tolerance for the profile type
+func tolerance(threshold float64, cc config.CompareConfig, pt models.ProfileType) float64 {
+	if threshold > 0 {
+		return threshold
+	}
+	return cc.Tolerance(string(pt))
+}
+
+// judge compares the headline metric of two profiles with models.Judge.
+// Types without a headline metric, or profiles missing it, get no verdict.
+// detail describes the change.
+func judge(pt models.ProfileType, base, target *models.Profile, tolerance float64) (verdict, detail string) {
+	v := models.Judge(pt, base, target, tolerance)
+	if v == nil {
+		return verdictNone, ""
+	}
+	return v.Verdict, fmt.Sprintf(" (%s %+.1f%%)", v.Metric, v.ChangePercent)
+}
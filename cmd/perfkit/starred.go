@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/flaticols/perfkit/client"
+)
+
+type StarredCmd struct {
+	Server  string `long:"server" description:"Perfkit server URL" default:"http://localhost:8080"`
+	Project string `long:"project" description:"Only list starred profiles of this project"`
+	Limit   int    `short:"n" long:"limit" description:"Number of profiles to show" default:"50"`
+}
+
+func (c *StarredCmd) Execute(args []string) error {
+	return runStarred(c)
+}
+
+// runStarred lists the server's starred profiles, newest first
+func runStarred(cmd *StarredCmd) error {
+	profiles, err := client.New(cmd.Server).ListProfiles(context.Background(), client.ListOptions{
+		Limit:   cmd.Limit,
+		Project: cmd.Project,
+		Starred: true,
+	})
+	if err != nil {
+		return fmt.Errorf("list starred profiles: %w", err)
+	}
+	if len(profiles) == 0 {
+		fmt.Println("No starred profiles")
+		return nil
+	}
+
+	fmt.Printf("%-36s  %-12s  %-19s  %-20s  %s\n", "ID", "TYPE", "CREATED", "SESSION", "NAME")
+	for _, p := range profiles {
+		fmt.Printf("%-36s  %-12s  %-19s  %-20s  %s\n", p.ID, p.ProfileType,
+			p.CreatedAt.Local().Format("2006-01-02 15:04:05"), orDash(p.Session), p.Name)
+	}
+	return nil
+}
@@ -2,13 +2,18 @@ package capture
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 
+	"github.com/flaticols/perfkit/client"
 	"github.com/flaticols/perfkit/internal/models"
 )
 
@@ -21,9 +26,14 @@ var ProfileEndpoint = map[models.ProfileType]string{
 	models.ProfileTypeMutex:        "/debug/pprof/mutex",
 	models.ProfileTypeAllocs:       "/debug/pprof/allocs",
 	models.ProfileTypeThreadCreate: "/debug/pprof/threadcreate",
+	models.ProfileTypeRuntime:      "/debug/vars",
+	models.ProfileTypeTrace:        "/debug/pprof/trace",
+	// fgprof's handler, where apps usually mount it
+	models.ProfileTypeWall: "/debug/fgprof",
 }
 
-// AllProfiles returns all capturable profile types
+// AllProfiles returns all capturable pprof profile types. Runtime metrics
+// need expvar on the target and traces are large, so they are opt-in.
 var AllProfiles = []models.ProfileType{
 	models.ProfileTypeCPU,
 	models.ProfileTypeHeap,
@@ -34,13 +44,22 @@ var AllProfiles = []models.ProfileType{
 	models.ProfileTypeThreadCreate,
 }
 
+// ErrTooLarge is returned for a profile bigger than a Capturer's MaxSize
+var ErrTooLarge = errors.New("profile too large")
+
 // CaptureResult holds the result of capturing a single profile
 type CaptureResult struct {
 	ProfileType models.ProfileType
 	Data        []byte
 	Size        int
 	Duration    time.Duration
-	Error       error
+	// CapturedAt is when the profile was fetched from the target
+	CapturedAt time.Time
+	// ID is the stored profile's ID once sent
+	ID string
+	// Warning is a non-fatal note from the server, e.g. an empty profile
+	Warning string
+	Error   error
 }
 
 // Capturer captures pprof profiles from a target and sends to perfkit server
@@ -51,7 +70,35 @@ type Capturer struct {
 	Session     string
 	Project     string
 	Source      string
-	client      *http.Client
+	// RunID ties captures to a load test, see models.LabelLoadRun
+	RunID string
+	// Compress gzips profiles that aren't already compressed before upload
+	Compress bool
+	// MaxSize caps the size of a fetched profile in bytes; 0 is no limit
+	MaxSize int64
+	// Tags are sent with every profile, e.g. the labels from FetchContext
+	Tags []string
+	// Provenance is sent with the first profile the capturer delivers, so
+	// the server records how the capture was taken
+	Provenance *Settings
+
+	provenanceSent atomic.Bool
+	client         *http.Client
+}
+
+// Settings are the effective options of a capture run, recorded as its
+// provenance. Keep it small: the server caps provenance at 2 KB.
+type Settings struct {
+	Target      string   `json:"target"`
+	Profiles    []string `json:"profiles"`
+	Set         string   `json:"set,omitempty"`
+	CPUDuration string   `json:"cpu_duration"`
+	Interval    string   `json:"interval,omitempty"`
+	Jitter      string   `json:"jitter,omitempty"`
+	DeepEvery   int      `json:"deep_every,omitempty"`
+	DeepCPU     string   `json:"deep_cpu_duration,omitempty"`
+	Count       int      `json:"count,omitempty"`
+	Context     string   `json:"context,omitempty"`
 }
 
 // New creates a new Capturer
@@ -67,12 +114,21 @@ func New(targetURL, serverURL string) *Capturer {
 	}
 }
 
+// SetHTTPClient replaces the client profiles are fetched with, e.g. to
+// restrict where redirects may lead
+func (c *Capturer) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
 // CaptureProfile fetches a single profile from the target
 func (c *Capturer) CaptureProfile(profileType models.ProfileType) CaptureResult {
 	result := CaptureResult{ProfileType: profileType}
 	start := time.Now()
 
 	endpoint, ok := ProfileEndpoint[profileType]
+	if custom, isCustom := profileType.Custom(); isCustom {
+		endpoint, ok = custom.Path, true
+	}
 	if !ok {
 		result.Error = fmt.Errorf("unknown profile type: %s", profileType)
 		return result
@@ -80,8 +136,8 @@ func (c *Capturer) CaptureProfile(profileType models.ProfileType) CaptureResult
 
 	targetURL := c.TargetURL + endpoint
 
-	// CPU profile needs duration parameter
-	if profileType == models.ProfileTypeCPU {
+	// CPU, wall-clock profiles and traces need a duration parameter
+	if profileType.IsSampled() {
 		seconds := int(c.CPUDuration.Seconds())
 		if seconds < 1 {
 			seconds = 1
@@ -97,33 +153,54 @@ func (c *Capturer) CaptureProfile(profileType models.ProfileType) CaptureResult
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
 		result.Error = fmt.Errorf("fetch %s: status %d: %s", profileType, resp.StatusCode, string(body))
 		return result
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	body := io.Reader(resp.Body)
+	if c.MaxSize > 0 {
+		// One byte over tells a profile at the limit from a larger one
+		body = io.LimitReader(resp.Body, c.MaxSize+1)
+	}
+	data, err := io.ReadAll(body)
 	if err != nil {
 		result.Error = fmt.Errorf("read %s: %w", profileType, err)
 		return result
 	}
+	if c.MaxSize > 0 && int64(len(data)) > c.MaxSize {
+		result.Error = fmt.Errorf("%w: %s is over %d bytes", ErrTooLarge, profileType, c.MaxSize)
+		return result
+	}
 
 	result.Data = data
 	result.Size = len(data)
-	result.Duration = time.Since(start)
+	result.CapturedAt = time.Now()
+	result.Duration = result.CapturedAt.Sub(start)
 	return result
 }
 
+// ingestResponse is the server's reply to an ingest request
+type ingestResponse struct {
+	ID      string `json:"id"`
+	Warning string `json:"warning"`
+}
+
 // SendToServer uploads a captured profile to the perfkit server
 func (c *Capturer) SendToServer(result CaptureResult) error {
+	_, err := c.send(result)
+	return err
+}
+
+func (c *Capturer) send(result CaptureResult) (*ingestResponse, error) {
 	if result.Error != nil {
-		return result.Error
+		return nil, result.Error
 	}
 
 	// Build ingest URL with query params
-	ingestURL, err := url.Parse(c.ServerURL + "/api/pprof/ingest")
+	ingestURL, err := url.Parse(c.ServerURL + client.IngestPath(result.ProfileType))
 	if err != nil {
-		return fmt.Errorf("parse server URL: %w", err)
+		return nil, fmt.Errorf("parse server URL: %w", err)
 	}
 
 	q := ingestURL.Query()
@@ -137,34 +214,106 @@ func (c *Capturer) SendToServer(result CaptureResult) error {
 	if c.Source != "" {
 		q.Set("source", c.Source)
 	}
+	if c.RunID != "" {
+		q.Set("run_id", c.RunID)
+	}
+	for _, tag := range c.Tags {
+		q.Add("tag", tag)
+	}
+	// Record which instance the profile came from
+	if target, err := url.Parse(c.TargetURL); err == nil && target.Hostname() != "" {
+		q.Set("host", target.Hostname())
+	}
 	// Mark cumulative profiles
 	if result.ProfileType.IsCumulative() {
 		q.Set("cumulative", "true")
 	}
+	// Send the fetch time so delayed uploads keep the real capture moment
+	if !result.CapturedAt.IsZero() {
+		q.Set("profile_time", result.CapturedAt.Format(time.RFC3339Nano))
+	}
 	// Generate name with timestamp
 	q.Set("name", fmt.Sprintf("%s-%s", result.ProfileType, time.Now().Format("20060102-150405")))
+	// Only one profile carries the provenance; if its upload fails the
+	// next one takes over
+	withProvenance := c.Provenance != nil && c.provenanceSent.CompareAndSwap(false, true)
+	if withProvenance {
+		data, err := json.Marshal(c.Provenance)
+		if err != nil {
+			c.provenanceSent.Store(false)
+			return nil, fmt.Errorf("encode provenance: %w", err)
+		}
+		q.Set("provenance", string(data))
+	}
 	ingestURL.RawQuery = q.Encode()
 
+	ir, err := c.post(ingestURL, result.Data)
+	if err != nil && withProvenance {
+		c.provenanceSent.Store(false)
+	}
+	return ir, err
+}
+
+// post uploads a profile body to an ingest URL
+func (c *Capturer) post(ingestURL *url.URL, body []byte) (*ingestResponse, error) {
+
+	compressed := false
+	if c.Compress && !isGzipped(body) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(body); err != nil {
+			return nil, fmt.Errorf("compress: %w", err)
+		}
+		if err := gw.Close(); err != nil {
+			return nil, fmt.Errorf("compress: %w", err)
+		}
+		body = buf.Bytes()
+		compressed = true
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ingestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
 	// POST the profile data
-	resp, err := c.client.Post(ingestURL.String(), "application/octet-stream", bytes.NewReader(result.Data))
+	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("send to server: %w", err)
+		return nil, fmt.Errorf("send to server: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server error: status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("server error: status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
+	var ir ingestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ir); err != nil {
+		return nil, fmt.Errorf("decode server response: %w", err)
+	}
+	return &ir, nil
+}
+
+// isGzipped reports whether data starts with the gzip magic bytes
+func isGzipped(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
 }
 
 // CaptureAndSend captures a profile and sends it to the server
 func (c *Capturer) CaptureAndSend(profileType models.ProfileType) CaptureResult {
 	result := c.CaptureProfile(profileType)
 	if result.Error == nil {
-		result.Error = c.SendToServer(result)
+		resp, err := c.send(result)
+		result.Error = err
+		if resp != nil {
+			result.ID = resp.ID
+			result.Warning = resp.Warning
+		}
 	}
 	return result
 }
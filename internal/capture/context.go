@@ -0,0 +1,111 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// goroutineHeader starts the first line of /debug/pprof/goroutine?debug=1
+const goroutineHeader = "goroutine profile: total "
+
+// maxContextLabels and maxContextValue keep a chatty context endpoint from
+// bloating every profile's tags
+const (
+	maxContextLabels = 32
+	maxContextValue  = 200
+)
+
+// FetchContext reads the target's runtime context, such as GOMAXPROCS and
+// the goroutine count, as labels (ctx.<key>=<value>) to store with the
+// profiles of a capture round. endpoint is a path on the target or a full
+// URL serving a flat JSON object; nested values are skipped. With no
+// endpoint only the goroutine count is read, from the header of the
+// goroutine debug=1 text that every pprof target serves.
+func (c *Capturer) FetchContext(endpoint string) ([]string, error) {
+	if endpoint == "" {
+		return c.fetchGoroutineCount()
+	}
+
+	u := endpoint
+	if !strings.Contains(endpoint, "://") {
+		u = c.TargetURL + endpoint
+	}
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("fetch context: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch context: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("read context: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("parse context: expected a JSON object: %w", err)
+	}
+
+	var labels []string
+	for key, v := range fields {
+		var value string
+		switch v := v.(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			continue
+		}
+		if !models.IsLabelKey(key) || value == "" || len(value) > maxContextValue {
+			continue
+		}
+		labels = append(labels, models.LabelContextPrefix+key+"="+value)
+	}
+	sort.Strings(labels)
+	if len(labels) > maxContextLabels {
+		labels = labels[:maxContextLabels]
+	}
+	return labels, nil
+}
+
+// fetchGoroutineCount reads the goroutine count from the goroutine debug=1
+// header, without downloading the stacks below it in full
+func (c *Capturer) fetchGoroutineCount() ([]string, error) {
+	resp, err := c.client.Get(c.TargetURL + ProfileEndpoint[models.ProfileTypeGoroutine] + "?debug=1")
+	if err != nil {
+		return nil, fmt.Errorf("fetch goroutine header: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch goroutine header: status %d", resp.StatusCode)
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 256)).ReadString('\n')
+	count, ok := strings.CutPrefix(strings.TrimSpace(line), goroutineHeader)
+	if !ok {
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("read goroutine header: %w", err)
+		}
+		return nil, fmt.Errorf("unexpected goroutine header %q", strings.TrimSpace(line))
+	}
+	if _, err := strconv.Atoi(count); err != nil {
+		return nil, fmt.Errorf("unexpected goroutine count %q", count)
+	}
+	return []string{models.LabelContextPrefix + "num_goroutine=" + count}, nil
+}
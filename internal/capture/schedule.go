@@ -0,0 +1,43 @@
+package capture
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Schedule times interval rounds on a fixed grid from Start, each moved by
+// a random offset within ±Jitter, so captures of a fleet that start
+// together drift apart instead of hitting every target at once. Like a
+// time.Ticker, it doesn't queue up slots that a slow round missed.
+type Schedule struct {
+	Start    time.Time
+	Interval time.Duration
+	// Jitter must be under half the Interval to keep rounds in order
+	Jitter time.Duration
+
+	slot int
+}
+
+// Next returns how long to wait at now for the next round
+func (s *Schedule) Next(now time.Time) time.Duration {
+	s.slot++
+	for !s.Start.Add(time.Duration(s.slot+1) * s.Interval).After(now) {
+		s.slot++
+	}
+
+	at := s.Start.Add(time.Duration(s.slot) * s.Interval)
+	if s.Jitter > 0 {
+		at = at.Add(rand.N(2*s.Jitter+1) - s.Jitter)
+	}
+	return max(at.Sub(now), 0)
+}
+
+// RoundCPUDuration returns how long sampled profiles run in round n: deep
+// on every deepEvery-th round, base otherwise. Rounds are numbered from 1,
+// so a resumed run keeps its deep rounds on the same cadence.
+func RoundCPUDuration(n int, base time.Duration, deepEvery int, deep time.Duration) time.Duration {
+	if deepEvery > 0 && n > 0 && n%deepEvery == 0 {
+		return deep
+	}
+	return base
+}
@@ -0,0 +1,200 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// Session drives a capture run with a Capturer: a single round of the
+// profiles, or in interval mode a round every Interval until Count rounds
+// are done or the context is cancelled. The On* hooks, when set, report
+// progress as it happens; Run's report has the whole run afterwards.
+type Session struct {
+	Capturer *Capturer
+	Profiles []models.ProfileType
+	// Interval between rounds; zero captures a single round
+	Interval time.Duration
+	// Jitter moves each interval round after the first by a random offset
+	// within ±Jitter (see Schedule)
+	Jitter time.Duration
+	// DeepEvery makes every DeepEvery-th interval round sample CPU,
+	// wall-clock and trace profiles for DeepCPUDuration instead of the
+	// Capturer's CPUDuration; zero keeps every round the same
+	DeepEvery       int
+	DeepCPUDuration time.Duration
+	// Count stops interval mode after this round; zero runs until cancelled
+	Count int
+	// FirstRound numbers the first interval round, to continue the count
+	// of an interrupted run; rounds start at 1 otherwise
+	FirstRound int
+	// KeepRounds limits the report to the latest rounds, so a run without
+	// Count doesn't grow it forever; zero keeps every round. The report's
+	// totals still cover the whole run.
+	KeepRounds int
+	// Context fetches the target's runtime context before each round and
+	// tags the round's profiles with it, from ContextEndpoint or, when
+	// that's empty, the goroutine count (see Capturer.FetchContext)
+	Context         bool
+	ContextEndpoint string
+
+	// OnRound is called as a round starts; round is 0 in single mode
+	OnRound func(round int, at time.Time)
+	// OnContext is called with the outcome of a round's context fetch
+	OnContext func(labels []string, err error)
+	// OnResult is called as each profile is captured and sent
+	OnResult func(result CaptureResult)
+}
+
+// RoundReport is what one capture round did
+type RoundReport struct {
+	// Round is 0 for a single capture, else the interval round's number
+	Round     int
+	StartedAt time.Time
+	// Context holds the labels the round's profiles were tagged with
+	Context    []string
+	ContextErr error
+	// Results has one entry per profile captured, in order, without the
+	// raw data
+	Results []CaptureResult
+	// Interrupted is set when cancellation cut the round short
+	Interrupted bool
+}
+
+// RunReport is the outcome of Session.Run
+type RunReport struct {
+	// Rounds are the rounds run, or the latest Session.KeepRounds of them
+	Rounds []RoundReport
+	// Completed is set when interval mode stopped after Count rounds
+	// rather than being cancelled
+	Completed bool
+	// Sent and Failed count profiles over all rounds; Bytes is the size
+	// of those sent
+	Sent   int
+	Failed int
+	Bytes  int64
+}
+
+// LastRound returns the latest round, or nil if none ran
+func (r *RunReport) LastRound() *RoundReport {
+	if len(r.Rounds) == 0 {
+		return nil
+	}
+	return &r.Rounds[len(r.Rounds)-1]
+}
+
+// Errors returns every failed capture's and context fetch's error in the
+// rounds kept
+func (r *RunReport) Errors() []error {
+	var errs []error
+	for _, round := range r.Rounds {
+		if round.ContextErr != nil {
+			errs = append(errs, round.ContextErr)
+		}
+		for _, result := range round.Results {
+			if result.Error != nil {
+				errs = append(errs, result.Error)
+			}
+		}
+	}
+	return errs
+}
+
+// Run captures until the run is done or ctx is cancelled. Capture failures
+// don't stop it; they are in the report. The error is only for a Session
+// that can't run.
+func (s *Session) Run(ctx context.Context) (*RunReport, error) {
+	if s.Capturer == nil {
+		return nil, errors.New("capture session has no capturer")
+	}
+	if len(s.Profiles) == 0 {
+		return nil, errors.New("capture session has no profiles")
+	}
+
+	report := &RunReport{}
+	if s.Interval == 0 {
+		s.round(ctx, report, 0)
+		return report, nil
+	}
+
+	round := max(s.FirstRound, 1)
+	schedule := &Schedule{Start: time.Now(), Interval: s.Interval, Jitter: s.Jitter}
+
+	// First capture immediately
+	if !s.round(ctx, report, round) {
+		return report, nil
+	}
+	round++
+
+	for {
+		timer := time.NewTimer(schedule.Next(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return report, nil
+		case <-timer.C:
+			if s.Count > 0 && round > s.Count {
+				report.Completed = true
+				return report, nil
+			}
+			if !s.round(ctx, report, round) {
+				return report, nil
+			}
+			round++
+		}
+	}
+}
+
+// round captures and sends each profile once, adding the round to report.
+// It returns false if cancellation cut it short.
+func (s *Session) round(ctx context.Context, report *RunReport, n int) bool {
+	rr := RoundReport{Round: n, StartedAt: time.Now()}
+	if s.OnRound != nil {
+		s.OnRound(n, rr.StartedAt)
+	}
+
+	if base := s.Capturer.CPUDuration; s.DeepEvery > 0 {
+		s.Capturer.CPUDuration = RoundCPUDuration(n, base, s.DeepEvery, s.DeepCPUDuration)
+		defer func() { s.Capturer.CPUDuration = base }()
+	}
+
+	if s.Context {
+		// Stale context is worse than none, so a failed fetch clears it
+		rr.Context, rr.ContextErr = s.Capturer.FetchContext(s.ContextEndpoint)
+		s.Capturer.Tags = rr.Context
+		if s.OnContext != nil {
+			s.OnContext(rr.Context, rr.ContextErr)
+		}
+	}
+
+	for _, pt := range s.Profiles {
+		if ctx.Err() != nil {
+			rr.Interrupted = true
+			break
+		}
+
+		result := s.Capturer.CaptureAndSend(pt)
+		if s.OnResult != nil {
+			s.OnResult(result)
+		}
+		if result.Error != nil {
+			report.Failed++
+		} else {
+			report.Sent++
+			report.Bytes += int64(result.Size)
+		}
+		// Long interval runs would otherwise hold every profile
+		result.Data = nil
+		rr.Results = append(rr.Results, result)
+	}
+
+	report.Rounds = append(report.Rounds, rr)
+	if s.KeepRounds > 0 && len(report.Rounds) > s.KeepRounds {
+		kept := copy(report.Rounds, report.Rounds[len(report.Rounds)-s.KeepRounds:])
+		clear(report.Rounds[kept:])
+		report.Rounds = report.Rounds[:kept]
+	}
+	return !rr.Interrupted
+}
@@ -3,31 +3,225 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	DataDir     string       `yaml:"data_dir"`
-	Project     string       `yaml:"project"`
-	Server      ServerConfig `yaml:"server"`
-	DefaultTags []string     `yaml:"default_tags"`
+	DataDir     string         `yaml:"data_dir"`
+	Project     string         `yaml:"project"`
+	Server      ServerConfig   `yaml:"server"`
+	UI          UIConfig       `yaml:"ui"`
+	DefaultTags []string       `yaml:"default_tags"`
+	Targets     []TargetConfig `yaml:"targets"`
+	Capture     CaptureConfig  `yaml:"capture"`
+	Compare     CompareConfig  `yaml:"compare"`
+	// ProfileTypes declares application-specific pprof profiles to capture
+	// and store beside the built-in types
+	ProfileTypes []ProfileTypeConfig `yaml:"profile_types"`
+
+	// IngestHooks are shell commands run after each ingested profile is
+	// saved, with its metadata in PERFKIT_* environment variables
+	IngestHooks []string `yaml:"ingest_hooks"`
+	// IngestHookTimeout kills a hook that runs longer than this
+	IngestHookTimeout time.Duration `yaml:"ingest_hook_timeout"`
 }
 
 type ServerConfig struct {
 	Host        string `yaml:"host"`
 	Port        int    `yaml:"port"`
 	EnablePprof bool   `yaml:"enable_pprof"`
+
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// IngestTimeout replaces the read/write deadlines on ingest routes so
+	// large uploads over slow links aren't cut off.
+	IngestTimeout time.Duration `yaml:"ingest_timeout"`
+
+	// MaxProfilesPerSession caps how many profiles a session may hold;
+	// 0 means no limit.
+	MaxProfilesPerSession int `yaml:"max_profiles_per_session"`
+	// SessionOverflow is what happens at the cap: reject new ingests, or
+	// evict the oldest profile to keep a rolling window.
+	SessionOverflow string `yaml:"session_overflow"`
+
+	// SessionLabels are tag labels (key=value) that name the session when an
+	// ingest doesn't give one, checked in order. A deploy's profiles tagged
+	// git_sha=abc123 are grouped into session abc123.
+	SessionLabels []string `yaml:"session_labels"`
+
+	// MaxIngestSize caps the size of an ingested profile in bytes; 0 means
+	// no limit.
+	MaxIngestSize int64 `yaml:"max_ingest_size"`
+
+	// MaxInlineSize caps the raw size of profiles whose data is embedded in
+	// list responses with include_raw=true
+	MaxInlineSize int `yaml:"max_inline_size"`
+
+	// AnomalySigma flags an ingested profile as an anomaly when its headline
+	// metric is more than this many standard deviations above the session's
+	// mean for its type; 0 disables flagging.
+	AnomalySigma float64 `yaml:"anomaly_sigma"`
+
+	// SessionNames is what happens when an ingest reuses a profile name
+	// already in its session: allow the duplicate, suffix the new name, or
+	// reject the ingest.
+	SessionNames string `yaml:"session_names"`
+
+	// FetchAllowlist lists the targets POST /api/pprof/ingest/url may fetch
+	// from: hostnames, host:port pairs, *.domain wildcards, IPs or CIDR
+	// ranges. Names match case-insensitively; the address a name resolves
+	// to is checked when connecting. Empty disables fetching by URL.
+	FetchAllowlist []string `yaml:"fetch_allowlist"`
+
+	// Decimate maps a pprof profile type to a percentile of sample weight:
+	// samples below it are folded into one before the profile is stored,
+	// trading the long tail for space. Types not listed are stored whole.
+	Decimate map[string]float64 `yaml:"decimate"`
+}
+
+// Session overflow modes for ServerConfig.SessionOverflow
+const (
+	SessionOverflowReject = "reject"
+	SessionOverflowEvict  = "evict"
+)
+
+// Duplicate name policies for ServerConfig.SessionNames
+const (
+	SessionNamesAllow  = "allow"
+	SessionNamesSuffix = "suffix"
+	SessionNamesReject = "reject"
+)
+
+// UIConfig customizes the embedded web UI
+type UIConfig struct {
+	Title string `yaml:"title" json:"title"`
+	// Theme is light, dark, or auto (follow the browser)
+	Theme string `yaml:"theme" json:"theme"`
+	// Logo is an optional path to an image shown in the header
+	Logo string `yaml:"logo" json:"-"`
+	// DefaultFilter narrows the profile list the UI opens with; query
+	// params on the index page override it
+	DefaultFilter UIFilter `yaml:"default_filter" json:"default_filter"`
+}
+
+// UIFilter selects the profiles the UI's profile list shows; empty fields
+// don't filter
+type UIFilter struct {
+	Project string `yaml:"project" json:"project,omitempty"`
+	Type    string `yaml:"type" json:"type,omitempty"`
+	Session string `yaml:"session" json:"session,omitempty"`
+}
+
+// CaptureConfig holds defaults for perfkit capture
+type CaptureConfig struct {
+	// DefaultProfiles are captured when neither --profiles nor --set is
+	// given; empty means all
+	DefaultProfiles []string `yaml:"default_profiles"`
+	// Sets are named profile lists picked with --set, so a team can agree
+	// on what to capture for a kind of investigation. Sets from the config
+	// file are added to the built-in ones, replacing any of the same name.
+	Sets map[string][]string `yaml:"sets"`
+}
+
+// CompareConfig sets how much a headline metric may move between two
+// profiles before it counts as a regression or an improvement
+type CompareConfig struct {
+	// NoiseTolerance is the band, in percent either way, within which a
+	// change is judged unchanged
+	NoiseTolerance float64 `yaml:"noise_tolerance"`
+	// TypeTolerances overrides NoiseTolerance per profile type, since some
+	// (cpu, mutex) are much noisier than others (heap)
+	TypeTolerances map[string]float64 `yaml:"type_tolerances"`
+	// MaxGap is how far apart two profiles may have been captured before
+	// comparing them warns of a stale baseline; zero turns the check off
+	MaxGap time.Duration `yaml:"max_gap"`
+}
+
+// Tolerance returns the noise tolerance for a profile type
+func (c CompareConfig) Tolerance(profileType string) float64 {
+	if t, ok := c.TypeTolerances[profileType]; ok {
+		return t
+	}
+	return c.NoiseTolerance
+}
+
+// ProfileTypeConfig declares a custom pprof profile type, e.g. one served
+// at /debug/pprof/mymetric by an app that registers it with pprof.NewProfile
+type ProfileTypeConfig struct {
+	Name string `yaml:"name"`
+	// Path is the endpoint on the target
+	Path string `yaml:"path"`
+	// SampleType is the sample type metrics rank functions by; empty uses
+	// the profile's default
+	SampleType string `yaml:"sample_type"`
+	// Cumulative marks profiles that count since the process started, so
+	// comparisons get the restart checks
+	Cumulative bool `yaml:"cumulative"`
+}
+
+// TargetConfig is a pprof endpoint the agent captures continuously
+type TargetConfig struct {
+	URL         string        `yaml:"url"`
+	Interval    time.Duration `yaml:"interval"`
+	Jitter      time.Duration `yaml:"jitter"`
+	Profiles    []string      `yaml:"profiles"`
+	Session     string        `yaml:"session"`
+	Project     string        `yaml:"project"`
+	CPUDuration time.Duration `yaml:"cpu_duration"`
+	// DeepEvery samples for DeepCPUDuration instead on every Nth round
+	DeepEvery       int           `yaml:"deep_every"`
+	DeepCPUDuration time.Duration `yaml:"deep_cpu_duration"`
+}
+
+// UnmarshalYAML also accepts a bare URL in place of a target, since service
+// discovery tools often emit plain lists of addresses
+func (t *TargetConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.URL = node.Value
+		return nil
+	}
+	type plain TargetConfig
+	return node.Decode((*plain)(t))
 }
 
 func Default() *Config {
 	return &Config{
-		DataDir:     ".perfkit",
-		Project:     "",
-		DefaultTags: []string{},
+		DataDir:           ".perfkit",
+		Project:           "",
+		DefaultTags:       []string{},
+		IngestHookTimeout: 30 * time.Second,
 		Server: ServerConfig{
-			Host: "localhost",
-			Port: 8080,
+			Host:              "localhost",
+			Port:              8080,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			IngestTimeout:     5 * time.Minute,
+			SessionOverflow:   SessionOverflowReject,
+			SessionLabels:     []string{"git_sha", "deploy_id"},
+			MaxIngestSize:     256 << 20,
+			MaxInlineSize:     64 * 1024,
+			AnomalySigma:      3,
+			SessionNames:      SessionNamesAllow,
+		},
+		UI: UIConfig{
+			Title: "perfkit",
+			Theme: "auto",
+		},
+		Compare: CompareConfig{
+			NoiseTolerance: 5,
+			MaxGap:         7 * 24 * time.Hour,
+		},
+		Capture: CaptureConfig{
+			Sets: map[string][]string{
+				"memory":  {"heap", "allocs"},
+				"latency": {"cpu", "block", "mutex"},
+			},
 		},
 	}
 }
@@ -0,0 +1,98 @@
+// Package format renders raw metric values (nanoseconds, bytes) for people,
+// shared by the API's units=human mode and the CLI.
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// byteKeys are metric fields measured in bytes. Durations are recognized
+// by their _ns and _ms suffixes instead.
+var byteKeys = map[string]bool{
+	"raw_size":   true,
+	"alloc_size": true,
+	"inuse_size": true,
+	"heap_alloc": true,
+	"heap_inuse": true,
+	"heap_sys":   true,
+	"heap_goal":  true,
+	"next_gc":    true,
+
+	"data_received":     true,
+	"data_sent":         true,
+	"bytes_per_request": true,
+}
+
+// Bytes renders a byte count with a binary unit, e.g. "1.5 MB"
+func Bytes(n int64) string {
+	const unit = 1024
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := abs / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Duration renders nanoseconds in the largest unit that keeps the value
+// readable, e.g. "850ns", "12.5µs", "3.2ms", "1.25s"
+func Duration(ns int64) string {
+	abs := ns
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < 1_000:
+		return fmt.Sprintf("%dns", ns)
+	case abs < 1_000_000:
+		return fmt.Sprintf("%.1fµs", float64(ns)/1e3)
+	case abs < 1_000_000_000:
+		return fmt.Sprintf("%.1fms", float64(ns)/1e6)
+	default:
+		return fmt.Sprintf("%.2fs", float64(ns)/1e9)
+	}
+}
+
+// Value renders a metric by its key: durations and byte counts with units,
+// anything else as a plain number
+func Value(key string, v float64) string {
+	switch {
+	case strings.HasSuffix(key, "_ns"):
+		return Duration(int64(v))
+	case strings.HasSuffix(key, "_ms"):
+		return Duration(int64(v * 1e6))
+	case byteKeys[key]:
+		return Bytes(int64(v))
+	case v == float64(int64(v)):
+		return fmt.Sprintf("%d", int64(v))
+	default:
+		return fmt.Sprintf("%.2f", v)
+	}
+}
+
+// Humanize adds a <key>_display string next to every duration and byte
+// field of a decoded JSON object. Raw values are left in place.
+func Humanize(fields map[string]any) {
+	for key, v := range fields {
+		n, ok := v.(float64)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(key, "_ns"):
+			fields[key+"_display"] = Duration(int64(n))
+		case strings.HasSuffix(key, "_ms"):
+			fields[key+"_display"] = Duration(int64(n * 1e6))
+		case byteKeys[key]:
+			fields[key+"_display"] = Bytes(int64(n))
+		}
+	}
+}
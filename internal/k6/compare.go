@@ -0,0 +1,124 @@
+package k6
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// MetricDelta is how one k6 metric moved between two runs
+type MetricDelta struct {
+	Metric string  `json:"metric"`
+	Base   float64 `json:"base"`
+	Target float64 `json:"target"`
+	Delta  float64 `json:"delta"`
+	// Percent is the change relative to the base; nil when the base is 0
+	Percent *float64 `json:"percent"`
+}
+
+// defaultStats are tried in order for a summary metric named without a
+// stat: a counter's count, a gauge's value, a rate's rate, a trend's avg
+var defaultStats = []string{"count", "value", "rate", "avg"}
+
+// CompareMetrics diffs the named metrics between two runs, in the order
+// asked. A name is one of:
+//   - a K6Metrics field by its JSON key, optionally without the unit
+//     suffix: p95_ms or p95, data_received
+//   - a summary metric, custom ones included: iterations, or
+//     http_req_duration.p(90) for one of its stats
+//
+// Names either run lacks are an error listing what both have.
+func CompareMetrics(base, target *models.K6Metrics, names []string) ([]MetricDelta, error) {
+	baseFields, targetFields := fieldValues(base), fieldValues(target)
+
+	var unknown []string
+	out := make([]MetricDelta, 0, len(names))
+	for _, name := range names {
+		b, bok := lookup(base, baseFields, name)
+		t, tok := lookup(target, targetFields, name)
+		if !bok || !tok {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		d := MetricDelta{Metric: name, Base: b, Target: t, Delta: t - b}
+		if b != 0 {
+			pct := (t - b) / b * 100
+			d.Percent = &pct
+		}
+		out = append(out, d)
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown k6 metric %s; both runs have %s, and name.stat for a summary metric's stats",
+			strings.Join(unknown, ", "), strings.Join(available(base, target, baseFields, targetFields), ", "))
+	}
+	return out, nil
+}
+
+// lookup resolves a metric name against a run's fields and summary
+func lookup(m *models.K6Metrics, fields map[string]float64, name string) (float64, bool) {
+	if v, ok := fields[name]; ok {
+		return v, true
+	}
+	if v, ok := fields[name+"_ms"]; ok {
+		return v, true
+	}
+
+	// Metric names have no dots, but stats like p(99.9) do
+	metric, stat, hasStat := strings.Cut(name, ".")
+	stats, ok := m.Summary[metric]
+	if !ok {
+		return 0, false
+	}
+	if hasStat {
+		v, ok := stats[stat]
+		return v, ok
+	}
+	for _, stat := range defaultStats {
+		if v, ok := stats[stat]; ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// fieldValues reads a run's numeric K6Metrics fields by JSON key
+func fieldValues(m *models.K6Metrics) map[string]float64 {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil
+	}
+
+	fields := make(map[string]float64, len(all))
+	for key, v := range all {
+		if f, ok := v.(float64); ok {
+			fields[key] = f
+		}
+	}
+	return fields
+}
+
+// available lists the field and summary metric names both runs have
+func available(base, target *models.K6Metrics, baseFields, targetFields map[string]float64) []string {
+	var names []string
+	for key := range baseFields {
+		if _, ok := targetFields[key]; ok {
+			names = append(names, key)
+		}
+	}
+	for name := range base.Summary {
+		if _, ok := target.Summary[name]; ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -1,8 +1,14 @@
 package k6
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/flaticols/perfkit/internal/models"
 )
@@ -28,10 +34,62 @@ type K6RootGroup struct {
 type ParsedK6 struct {
 	Metrics    *models.K6Metrics
 	DurationMS int64
+	// Dropped names the summary metrics, and metric.stat pairs, left out of
+	// Metrics.Summary by MaxSummaryMetrics or for not being a k6 aggregation
+	Dropped []string
 }
 
-// Parse parses k6 JSON summary data
+// MaxSummaryMetrics caps how many metrics a k6 profile's summary keeps, so
+// a script that tags submetrics by URL or user can't bloat every profile.
+// k6's own metrics are kept first, then custom ones, then submetrics, each
+// in name order.
+const MaxSummaryMetrics = 200
+
+// builtinPrefixes start the names of the metrics k6 itself reports
+var builtinPrefixes = []string{"http_req", "iteration", "dropped_iterations", "vus", "checks", "data_", "group_duration", "ws_", "grpc_", "browser_"}
+
+// summaryRank orders metric names for MaxSummaryMetrics: k6's own, custom,
+// then submetrics like http_reqs{status:200}
+func summaryRank(name string) int {
+	if strings.Contains(name, "{") {
+		return 2
+	}
+	for _, prefix := range builtinPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return 0
+		}
+	}
+	return 1
+}
+
+// summaryStat matches the aggregations k6 reports for a metric: counter,
+// gauge and rate values, trend stats and percentiles such as p(99.9)
+var summaryStat = regexp.MustCompile(`^(count|rate|value|passes|fails|avg|min|med|max|p\(\d+(\.\d+)?\))$`)
+
+// statusSubmetric matches the per-status submetrics k6 reports for
+// http_reqs when a threshold or tag filter breaks requests down by status,
+// e.g. "http_reqs{status:200}"
+var statusSubmetric = regexp.MustCompile(`^http_reqs\{status:(\d{3})\}$`)
+
+// gzipMagic is the two-byte header that starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Parse parses k6 JSON summary data, gzipped or plain
 func Parse(data []byte) (*ParsedK6, error) {
+	// Decompress if gzipped
+	if bytes.HasPrefix(data, gzipMagic) {
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		defer gr.Close()
+
+		data, err = io.ReadAll(gr)
+		if err != nil {
+			return nil, fmt.Errorf("decompress k6 summary: %w", err)
+		}
+	}
+
 	var summary K6Summary
 	if err := json.Unmarshal(data, &summary); err != nil {
 		return nil, fmt.Errorf("parse k6 json: %w", err)
@@ -78,6 +136,59 @@ func Parse(data []byte) (*ParsedK6, error) {
 		}
 	}
 
+	// Keep every metric's stats, up to MaxSummaryMetrics, so fields beyond
+	// the ones above, custom metrics included, can be compared by name
+	names := make([]string, 0, len(summary.Metrics))
+	for name := range summary.Metrics {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ri, rj := summaryRank(names[i]), summaryRank(names[j]); ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		stats := make(map[string]float64, len(summary.Metrics[name].Values))
+		for stat, v := range summary.Metrics[name].Values {
+			f, ok := v.(float64)
+			if !ok {
+				continue
+			}
+			if !summaryStat.MatchString(stat) {
+				result.Dropped = append(result.Dropped, name+"."+stat)
+				continue
+			}
+			stats[stat] = f
+		}
+		if len(stats) == 0 {
+			continue
+		}
+		if len(result.Metrics.Summary) == MaxSummaryMetrics {
+			result.Dropped = append(result.Dropped, name)
+			continue
+		}
+		if result.Metrics.Summary == nil {
+			result.Metrics.Summary = make(map[string]map[string]float64)
+		}
+		result.Metrics.Summary[name] = stats
+	}
+	sort.Strings(result.Dropped)
+
+	// Extract the status code distribution, when the run has one
+	for name, metric := range summary.Metrics {
+		m := statusSubmetric.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		if v, ok := metric.Values["count"].(float64); ok {
+			if result.Metrics.StatusCodes == nil {
+				result.Metrics.StatusCodes = make(map[string]int64)
+			}
+			result.Metrics.StatusCodes[m[1]] = int64(v)
+		}
+	}
+
 	// Extract VUs
 	if metric, ok := summary.Metrics["vus"]; ok {
 		if vals := metric.Values; vals != nil {
@@ -102,9 +213,12 @@ func Parse(data []byte) (*ParsedK6, error) {
 			if rate, ok := vals["rate"].(float64); ok {
 				result.Metrics.ErrorRate = rate
 			}
-			// Count of failed requests
+			// Count of failed requests; rate metrics count true values,
+			// i.e. failures, as passes
 			if count, ok := vals["count"].(float64); ok {
 				result.Metrics.FailedRequests = int64(count)
+			} else if passes, ok := vals["passes"].(float64); ok {
+				result.Metrics.FailedRequests = int64(passes)
 			}
 		}
 	} else if metric, ok := summary.Metrics["checks"]; ok {
@@ -126,6 +240,24 @@ func Parse(data []byte) (*ParsedK6, error) {
 		}
 	}
 
+	// Extract data transfer totals
+	if metric, ok := summary.Metrics["data_received"]; ok {
+		if v, ok := metric.Values["count"].(float64); ok {
+			result.Metrics.DataReceived = int64(v)
+		}
+	}
+	if metric, ok := summary.Metrics["data_sent"]; ok {
+		if v, ok := metric.Values["count"].(float64); ok {
+			result.Metrics.DataSent = int64(v)
+		}
+	}
+
+	// Normalize totals by request count
+	if n := result.Metrics.TotalRequests; n > 0 {
+		result.Metrics.BytesPerRequest = float64(result.Metrics.DataReceived+result.Metrics.DataSent) / float64(n)
+		result.Metrics.ErrorsPerRequest = float64(result.Metrics.FailedRequests) / float64(n)
+	}
+
 	// Set duration in metrics
 	result.Metrics.DurationMS = result.DurationMS
 
@@ -0,0 +1,98 @@
+package k6
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// StatusShift is how one status code, or class of codes such as "5xx",
+// moved between two runs. Shares are fractions of each run's requests, so
+// runs of different lengths compare fairly.
+type StatusShift struct {
+	Status      string  `json:"status"`
+	BaseCount   int64   `json:"base_count"`
+	TargetCount int64   `json:"target_count"`
+	BaseShare   float64 `json:"base_share"`
+	TargetShare float64 `json:"target_share"`
+	// ShareDelta is the change in share, in fractions: 0.02 is two
+	// percentage points more of the target's requests
+	ShareDelta float64 `json:"share_delta"`
+}
+
+// StatusDiff compares the status code mix of two k6 runs
+type StatusDiff struct {
+	BaseTotal   int64         `json:"base_total"`
+	TargetTotal int64         `json:"target_total"`
+	Classes     []StatusShift `json:"classes"`
+	Codes       []StatusShift `json:"codes"`
+}
+
+// DiffStatusCodes compares two runs' requests by status code and by class
+// (2xx, 3xx, ...). Codes are ordered by the size of their share change,
+// largest first; classes in numeric order.
+func DiffStatusCodes(base, target map[string]int64) *StatusDiff {
+	diff := &StatusDiff{
+		BaseTotal:   total(base),
+		TargetTotal: total(target),
+	}
+	diff.Codes = shifts(base, target, diff.BaseTotal, diff.TargetTotal)
+	diff.Classes = shifts(classes(base), classes(target), diff.BaseTotal, diff.TargetTotal)
+
+	slices.SortStableFunc(diff.Codes, func(a, b StatusShift) int {
+		return cmp.Compare(math.Abs(b.ShareDelta), math.Abs(a.ShareDelta))
+	})
+	return diff
+}
+
+// shifts pairs up the counts of every status in either run, in status order
+func shifts(base, target map[string]int64, baseTotal, targetTotal int64) []StatusShift {
+	var statuses []string
+	for s := range base {
+		statuses = append(statuses, s)
+	}
+	for s := range target {
+		if _, ok := base[s]; !ok {
+			statuses = append(statuses, s)
+		}
+	}
+	slices.Sort(statuses)
+
+	out := make([]StatusShift, 0, len(statuses))
+	for _, s := range statuses {
+		shift := StatusShift{
+			Status:      s,
+			BaseCount:   base[s],
+			TargetCount: target[s],
+			BaseShare:   share(base[s], baseTotal),
+			TargetShare: share(target[s], targetTotal),
+		}
+		shift.ShareDelta = shift.TargetShare - shift.BaseShare
+		out = append(out, shift)
+	}
+	return out
+}
+
+// classes sums status code counts by their leading digit
+func classes(codes map[string]int64) map[string]int64 {
+	out := make(map[string]int64)
+	for code, n := range codes {
+		out[code[:1]+"xx"] += n
+	}
+	return out
+}
+
+func total(codes map[string]int64) int64 {
+	var n int64
+	for _, c := range codes {
+		n += c
+	}
+	return n
+}
+
+func share(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
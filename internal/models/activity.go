@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Actions recorded in the activity log
+const (
+	ActivityIngest = "ingest"
+	ActivityDelete = "delete"
+)
+
+// ActivityEvent is one entry in the server's activity log: a profile being
+// stored or removed. Only what identifies the profile is kept, so an event
+// outlives the profile it describes.
+type ActivityEvent struct {
+	ID          int64       `db:"id" json:"-"`
+	Time        time.Time   `db:"created_at" json:"time"`
+	Action      string      `db:"action" json:"action"`
+	ProfileID   string      `db:"profile_id" json:"profile_id"`
+	ProfileType ProfileType `db:"profile_type" json:"profile_type"`
+	Name        string      `db:"name" json:"name"`
+	Project     string      `db:"project" json:"project,omitempty"`
+	Session     string      `db:"session" json:"session,omitempty"`
+	Host        string      `db:"host" json:"host,omitempty"`
+}
@@ -1,10 +1,19 @@
 package models
 
 import (
+	"crypto/sha256"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // NullableJSON represents a json.RawMessage that can be NULL in the database
@@ -49,6 +58,74 @@ func (n *NullableJSON) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// IDList is a list of profile IDs stored as a JSON array, NULL when empty
+type IDList []string
+
+func (l *IDList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for IDList: %T", value)
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+func (l IDList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// ValueType is a pprof sample type: what a sample value counts, and in
+// which unit
+type ValueType struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"`
+}
+
+// ValueTypeList is a list of sample types stored as a JSON array, NULL when
+// empty
+type ValueTypeList []ValueType
+
+func (l *ValueTypeList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for ValueTypeList: %T", value)
+	}
+	return json.Unmarshal(data, (*[]ValueType)(l))
+}
+
+func (l ValueTypeList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]ValueType(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
 type ProfileType string
 
 const (
@@ -61,6 +138,11 @@ const (
 	ProfileTypeK6           ProfileType = "k6"
 	ProfileTypeAllocs       ProfileType = "allocs"
 	ProfileTypeThreadCreate ProfileType = "threadcreate"
+	ProfileTypeRuntime      ProfileType = "runtime"
+	ProfileTypeTrace        ProfileType = "trace"
+	// ProfileTypeWall is a wall-clock profile from fgprof, sampling on- and
+	// off-CPU goroutines alike
+	ProfileTypeWall ProfileType = "wall"
 )
 
 var validProfileTypes = map[ProfileType]bool{
@@ -73,6 +155,9 @@ var validProfileTypes = map[ProfileType]bool{
 	ProfileTypeK6:           true,
 	ProfileTypeAllocs:       true,
 	ProfileTypeThreadCreate: true,
+	ProfileTypeRuntime:      true,
+	ProfileTypeTrace:        true,
+	ProfileTypeWall:         true,
 }
 
 // Cumulative profiles accumulate data since program start
@@ -82,14 +167,125 @@ var cumulativeProfileTypes = map[ProfileType]bool{
 	ProfileTypeAllocs: true,
 }
 
+// Non-pprof profiles carry JSON payloads or execution traces rather than
+// pprof protobufs
+var nonPprofProfileTypes = map[ProfileType]bool{
+	ProfileTypeK6:      true,
+	ProfileTypeRuntime: true,
+	ProfileTypeTrace:   true,
+}
+
+// CustomProfileType is an application-specific pprof profile declared in
+// the config, such as one an app registers with pprof.NewProfile
+type CustomProfileType struct {
+	Name ProfileType
+	// Path is the endpoint serving it on the target
+	Path string
+	// SampleType names the sample type its metrics rank functions by;
+	// empty uses the profile's default sample type
+	SampleType string
+	// Cumulative profiles count since the process started, like mutex
+	Cumulative bool
+}
+
+var customProfileTypes = map[ProfileType]CustomProfileType{}
+
+// profileTypesMu guards the type maps, as the agent registers custom types
+// again on reload while it captures
+var profileTypesMu sync.RWMutex
+
+var profileTypeName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// RegisterProfileType makes a custom profile type valid for capture and
+// ingest. Registering a name again replaces it, so a reloaded config can
+// change it. Call it at startup, before profiles are handled.
+func RegisterProfileType(ct CustomProfileType) error {
+	if !profileTypeName.MatchString(string(ct.Name)) {
+		return fmt.Errorf("invalid profile type name %q: use lowercase letters, digits and underscores", ct.Name)
+	}
+	if !strings.HasPrefix(ct.Path, "/") {
+		return fmt.Errorf("profile type %s: path must start with /", ct.Name)
+	}
+	if _, custom := ct.Name.Custom(); ct.Name.IsValid() && !custom {
+		return fmt.Errorf("%s is a built-in profile type", ct.Name)
+	}
+
+	profileTypesMu.Lock()
+	defer profileTypesMu.Unlock()
+	validProfileTypes[ct.Name] = true
+	if ct.Cumulative {
+		cumulativeProfileTypes[ct.Name] = true
+	} else {
+		delete(cumulativeProfileTypes, ct.Name)
+	}
+	customProfileTypes[ct.Name] = ct
+	return nil
+}
+
 func (pt ProfileType) IsValid() bool {
+	profileTypesMu.RLock()
+	defer profileTypesMu.RUnlock()
 	return validProfileTypes[pt]
 }
 
+// Custom returns the declaration of a custom profile type
+func (pt ProfileType) Custom() (CustomProfileType, bool) {
+	profileTypesMu.RLock()
+	defer profileTypesMu.RUnlock()
+	ct, ok := customProfileTypes[pt]
+	return ct, ok
+}
+
 func (pt ProfileType) IsCumulative() bool {
+	profileTypesMu.RLock()
+	defer profileTypesMu.RUnlock()
 	return cumulativeProfileTypes[pt]
 }
 
+// IsSampled reports whether profiles of the type are recorded over a
+// duration rather than taken as a snapshot
+func (pt ProfileType) IsSampled() bool {
+	return pt == ProfileTypeCPU || pt == ProfileTypeTrace || pt == ProfileTypeWall
+}
+
+func (pt ProfileType) IsPprof() bool {
+	return !nonPprofProfileTypes[pt]
+}
+
+// TagEmpty marks profiles that were ingested without any samples
+const TagEmpty = "empty"
+
+// TagAnomaly marks profiles whose headline metric was an outlier against
+// their session's history at ingest
+const TagAnomaly = "anomaly"
+
+// TagDiff marks profiles computed as the difference of two others
+const TagDiff = "diff"
+
+// TagBaseline marks profiles copied into a baseline snapshot of a session
+const TagBaseline = "baseline"
+
+// TagCorrupt marks profiles whose stored data failed an integrity check
+const TagCorrupt = "corrupt"
+
+// contentIDNamespace scopes the UUIDs derived for content_id ingests
+var contentIDNamespace = uuid.MustParse("6f1c2a5e-8b3d-4e7a-9c41-2d5f7b0e9a63")
+
+// ContentID derives a profile ID from the uploaded data, type, project and
+// session, so that importing the same file again maps to the same profile
+func ContentID(data []byte, pt ProfileType, project, session string) string {
+	key := []byte(string(pt) + "\x00" + project + "\x00" + session + "\x00")
+	return uuid.NewSHA1(contentIDNamespace, append(key, data...)).String()
+}
+
+// ContentHash returns the hex SHA-256 of a profile's raw data. It's
+// recorded when the profile is stored, so db verify can tell when the data
+// has changed since.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 type Profile struct {
 	ID        string    `db:"id" json:"id"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
@@ -99,22 +295,46 @@ type Profile struct {
 	ProfileType ProfileType `db:"profile_type" json:"profile_type"`
 	Project     string      `db:"project" json:"project"`
 	Session     string      `db:"session" json:"session,omitempty"`
+	Host        string      `db:"host" json:"host,omitempty"`
 	Tags        []string    `db:"-" json:"tags"`
 	TagsJSON    string      `db:"tags" json:"-"`
 	Source      string      `db:"source" json:"source"`
+	// ParentIDs are the profiles a derived one (e.g. a diff) was computed from
+	ParentIDs IDList `db:"parent_ids" json:"parent_ids,omitempty"`
 
-	RawData      []byte `db:"raw_data" json:"-"`
-	RawSize      int    `db:"raw_size" json:"raw_size"`
+	RawData []byte `db:"raw_data" json:"-"`
+	// InlineRaw carries raw data in list responses when requested with
+	// include_raw; base64 in JSON
+	InlineRaw []byte `db:"-" json:"raw_data,omitempty"`
+	RawSize   int    `db:"raw_size" json:"raw_size"`
+	// ContentHash is ContentHash(RawData) as stored, for db verify
+	ContentHash  string `db:"content_hash" json:"content_hash,omitempty"`
 	IsCumulative bool   `db:"is_cumulative" json:"is_cumulative,omitempty"`
+	// Starred bookmarks the profile for quick access; unlike retention
+	// settings it doesn't keep the profile from being deleted
+	Starred bool `db:"starred" json:"starred,omitempty"`
 
 	ProfileTime *time.Time `db:"profile_time" json:"profile_time,omitempty"`
 	DurationNS  int64      `db:"duration_ns" json:"duration_ns,omitempty"`
+	// WindowNS is the monitoring window the uploader says a snapshot
+	// stands for, e.g. a heap taken after a 5-minute soak. Unlike
+	// DurationNS it isn't read from the profile.
+	WindowNS int64 `db:"window_ns" json:"window_ns,omitempty"`
 
 	Metrics NullableJSON `db:"metrics" json:"metrics"`
+	// Provenance records how the profile was captured, as sent by the
+	// capturing tool with the first profile of a run (see capture.Settings)
+	Provenance NullableJSON `db:"provenance" json:"provenance,omitempty"`
+	// SampleTypes lists a pprof profile's sample types as the profiler
+	// wrote them, which explains metrics that come out unexpectedly zero
+	SampleTypes ValueTypeList `db:"sample_types" json:"sample_types,omitempty"`
 
 	// pprof quick-access fields
 	TotalSamples *int64 `db:"total_samples" json:"total_samples,omitempty"`
 	TotalValue   *int64 `db:"total_value" json:"total_value,omitempty"`
+	// StoredSamples is set when the server decimated the profile before
+	// storing it: how many of its TotalSamples samples were kept
+	StoredSamples *int64 `db:"stored_samples" json:"stored_samples,omitempty"`
 
 	// k6 quick-access fields
 	K6P95        *float64 `db:"k6_p95" json:"k6_p95,omitempty"`
@@ -124,6 +344,207 @@ type Profile struct {
 	K6DurationMS *int64   `db:"k6_duration_ms" json:"k6_duration_ms,omitempty"`
 }
 
+// Validate checks that the fields required for storage are set
+func (p *Profile) Validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("id: required")
+	}
+	if !p.ProfileType.IsValid() {
+		return fmt.Errorf("profile_type: invalid value %q", p.ProfileType)
+	}
+	if p.CreatedAt.IsZero() {
+		return fmt.Errorf("created_at: required")
+	}
+	return nil
+}
+
+// Label returns the value of a key=value tag, e.g. Label("git_sha") for a
+// profile tagged git_sha=abc123
+func (p *Profile) Label(key string) string {
+	return LabelValue(p.Tags, key)
+}
+
+// LabelValue finds the value of the first key=value tag for key
+func LabelValue(tags []string, key string) string {
+	for _, tag := range tags {
+		if value, ok := strings.CutPrefix(tag, key+"="); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// LabelRunID is the tag label identifying a process run
+const LabelRunID = "run_id"
+
+// LabelLoadRun is the tag label tying a load test's k6 summary to the
+// profiles captured while it ran. It's separate from LabelRunID, which
+// marks a process run, so one process can serve several load tests.
+const LabelLoadRun = "load_run"
+
+// LabelHost is the tag label naming the instance a profile came from
+const LabelHost = "host"
+
+// LabelContextPrefix starts the labels holding the target's runtime context
+// at capture time, e.g. ctx.gomaxprocs=8
+const LabelContextPrefix = "ctx."
+
+// labelKeyPattern is what a label key may contain
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// IsLabelKey reports whether key can name a key=value tag label
+func IsLabelKey(key string) bool {
+	return labelKeyPattern.MatchString(key)
+}
+
+// ErrDifferentRuns is returned when cumulative profiles come from different
+// process runs and can't be meaningfully compared
+var ErrDifferentRuns = errors.New("profiles are from different process runs")
+
+// CheckSameRun guards comparisons of cumulative profiles, whose counters
+// reset when the process restarts. Differing run_id labels are an error;
+// without labels, a target total below the base total suggests a restart
+// and is returned as a warning.
+func CheckSameRun(base, target *Profile) (string, error) {
+	if !base.ProfileType.IsCumulative() && !base.IsCumulative {
+		return "", nil
+	}
+
+	baseRun, targetRun := base.Label(LabelRunID), target.Label(LabelRunID)
+	if baseRun != "" && targetRun != "" {
+		if baseRun != targetRun {
+			return "", fmt.Errorf("%w: %s is from run %s, %s from run %s", ErrDifferentRuns, base.ID, baseRun, target.ID, targetRun)
+		}
+		return "", nil
+	}
+
+	// Empty profiles are stored without a total
+	var baseTotal, targetTotal int64
+	if base.TotalValue != nil {
+		baseTotal = *base.TotalValue
+	}
+	if target.TotalValue != nil {
+		targetTotal = *target.TotalValue
+	}
+	if targetTotal < baseTotal {
+		return fmt.Sprintf("%s totals dropped from %d to %d; the process may have restarted between captures, so deltas are unreliable",
+			base.ProfileType, baseTotal, targetTotal), nil
+	}
+	return "", nil
+}
+
+// LabelGitSHA is the tag label naming the build a profile was captured from
+const LabelGitSHA = "git_sha"
+
+// CheckAge guards against comparing with a stale baseline. It returns the
+// time from base's capture to target's, and a warning when that's more than
+// maxGap either way; zero maxGap never warns. Differing git_sha labels are
+// mentioned in the warning, but aren't one on their own, since comparing
+// builds is what most comparisons are for.
+func CheckAge(base, target *Profile, maxGap time.Duration) (time.Duration, string) {
+	gap := target.CapturedAt().Sub(base.CapturedAt())
+	if maxGap <= 0 || gap.Abs() <= maxGap {
+		return gap, ""
+	}
+
+	warning := fmt.Sprintf("profiles were captured %s apart, more than the %s allowed", FormatGap(gap), FormatGap(maxGap))
+	baseSHA, targetSHA := base.Label(LabelGitSHA), target.Label(LabelGitSHA)
+	if baseSHA != "" && targetSHA != "" && baseSHA != targetSHA {
+		warning += fmt.Sprintf(", from builds %s and %s", baseSHA, targetSHA)
+	}
+	return gap, warning + "; the baseline may be stale"
+}
+
+// FormatGap renders the time between two captures in its two largest
+// units, e.g. "31d 2h", "5h 12m", "7d" or "45s"
+func FormatGap(d time.Duration) string {
+	d = d.Abs().Round(time.Second)
+	units := []struct {
+		size   time.Duration
+		suffix string
+	}{{24 * time.Hour, "d"}, {time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}}
+
+	for i, u := range units {
+		n := int64(d / u.size)
+		if n == 0 && i < len(units)-1 {
+			continue
+		}
+		out := fmt.Sprintf("%d%s", n, u.suffix)
+		if i < len(units)-1 {
+			if rest := int64(d % u.size / units[i+1].size); rest > 0 {
+				out += fmt.Sprintf(" %d%s", rest, units[i+1].suffix)
+			}
+		}
+		return out
+	}
+	return ""
+}
+
+// HeadlineMetrics maps each profile type to the metrics key that best sums
+// it up. Lower is better for all of them.
+var HeadlineMetrics = map[ProfileType]string{
+	ProfileTypeCPU:       "total_cpu_time_ns",
+	ProfileTypeHeap:      "inuse_size",
+	ProfileTypeAllocs:    "alloc_size",
+	ProfileTypeMutex:     "contention_time_ns",
+	ProfileTypeBlock:     "blocking_time_ns",
+	ProfileTypeGoroutine: "goroutine_count",
+	ProfileTypeRuntime:   "heap_inuse",
+	ProfileTypeK6:        "p95_ms",
+	ProfileTypeTrace:     "sched_latency_ns",
+	ProfileTypeWall:      "total_wall_time_ns",
+}
+
+// MetricValue reads one numeric metric from the profile's metrics JSON
+func (p *Profile) MetricValue(key string) (float64, bool) {
+	var metrics map[string]any
+	if err := json.Unmarshal(p.Metrics, &metrics); err != nil {
+		return 0, false
+	}
+	v, ok := metrics[key].(float64)
+	return v, ok
+}
+
+// KeepCommonMetrics drops every metrics key that isn't present in all of
+// the profiles, so profiles of different types show only what they share
+// (e.g. a heap and an allocs profile keep just the alloc_* metrics). It
+// returns the kept keys, sorted.
+func KeepCommonMetrics(profiles []*Profile) []string {
+	all := make([]map[string]json.RawMessage, len(profiles))
+	counts := make(map[string]int)
+	for i, p := range profiles {
+		if err := json.Unmarshal(p.Metrics, &all[i]); err != nil {
+			all[i] = nil
+		}
+		for key := range all[i] {
+			counts[key]++
+		}
+	}
+
+	for i, p := range profiles {
+		if all[i] == nil {
+			continue
+		}
+		for key := range all[i] {
+			if counts[key] < len(profiles) {
+				delete(all[i], key)
+			}
+		}
+		if data, err := json.Marshal(all[i]); err == nil {
+			p.Metrics = NullableJSON(data)
+		}
+	}
+
+	var kept []string
+	for key, n := range counts {
+		if n == len(profiles) {
+			kept = append(kept, key)
+		}
+	}
+	sort.Strings(kept)
+	return kept
+}
+
 func (p *Profile) UnmarshalTags() error {
 	if p.TagsJSON == "" || p.TagsJSON == "null" {
 		p.Tags = []string{}
@@ -160,9 +581,25 @@ type StackSample struct {
 }
 
 type CPUMetrics struct {
-	TotalCPUTimeNS int64            `json:"total_cpu_time_ns"`
-	SampleCount    int64            `json:"sample_count"`
-	TopFunctions   []FunctionSample `json:"top_functions"`
+	TotalCPUTimeNS int64 `json:"total_cpu_time_ns"`
+	SampleCount    int64 `json:"sample_count"`
+	WallDurationNS int64 `json:"wall_duration_ns"`
+	// Parallelism is CPU time over wall time: ~1 means one busy core,
+	// higher values mean work spread across cores.
+	Parallelism  float64          `json:"parallelism"`
+	TopFunctions []FunctionSample `json:"top_functions"`
+}
+
+// WallMetrics summarize a wall-clock profile, where every goroutine is
+// sampled whether it runs or waits
+type WallMetrics struct {
+	TotalWallTimeNS int64 `json:"total_wall_time_ns"`
+	SampleCount     int64 `json:"sample_count"`
+	WallDurationNS  int64 `json:"wall_duration_ns"`
+	// Concurrency is wall time over the capture duration: the average
+	// number of goroutines sampled at once
+	Concurrency  float64          `json:"concurrency"`
+	TopFunctions []FunctionSample `json:"top_functions"`
 }
 
 type HeapMetrics struct {
@@ -171,18 +608,88 @@ type HeapMetrics struct {
 	InuseSize     int64            `json:"inuse_size"`
 	InuseObjects  int64            `json:"inuse_objects"`
 	TopAllocators []FunctionSample `json:"top_allocators"`
+	// TopInuseObjects ranks functions by live object count. A function
+	// whose count keeps growing is a leak even when the bytes look stable.
+	TopInuseObjects []FunctionSample `json:"top_inuse_objects"`
+}
+
+// AllocsMetrics summarizes an allocs profile: cumulative allocations since
+// the process started. Unlike heap metrics there is no inuse view.
+type AllocsMetrics struct {
+	AllocSize     int64            `json:"alloc_size"`
+	AllocObjects  int64            `json:"alloc_objects"`
+	TopAllocators []FunctionSample `json:"top_allocators"`
+	// TopObjectAllocators ranks functions by allocation count, which
+	// surfaces many small allocations hidden by the by-size ranking
+	TopObjectAllocators []FunctionSample `json:"top_object_allocators"`
+	// Rate is allocations per second since the session's previous allocs
+	// profile, when there is one from the same process run
+	Rate float64 `json:"rate,omitempty"`
 }
 
 type MutexMetrics struct {
 	ContentionTimeNS int64            `json:"contention_time_ns"`
 	ContentionCount  int64            `json:"contention_count"`
 	TopContenders    []FunctionSample `json:"top_contenders"`
+	// SamplingPeriod is the profile's recorded sampling rate, e.g. a mutex
+	// profile fraction of N records 1 in N contention events.
+	SamplingPeriod     int64  `json:"sampling_period,omitempty"`
+	SamplingPeriodType string `json:"sampling_period_type,omitempty"`
+	// Rate is contentions per second since the session's previous mutex
+	// profile, as for AllocsMetrics
+	Rate float64 `json:"rate,omitempty"`
 }
 
 type BlockMetrics struct {
 	BlockingTimeNS int64            `json:"blocking_time_ns"`
 	BlockingCount  int64            `json:"blocking_count"`
 	TopBlockers    []FunctionSample `json:"top_blockers"`
+	// SamplingPeriod is the profile's recorded sampling rate, as for
+	// MutexMetrics.
+	SamplingPeriod     int64  `json:"sampling_period,omitempty"`
+	SamplingPeriodType string `json:"sampling_period_type,omitempty"`
+	// Rate is blocking events per second since the session's previous
+	// block profile, as for AllocsMetrics
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// CounterMetrics are the metrics of cumulative profiles, whose event count
+// grows from process start, so only its rate between captures says how
+// busy the process is now
+type CounterMetrics interface {
+	// CountKey is the metrics key of the event count
+	CountKey() string
+	EventCount() int64
+	SetRate(perSecond float64)
+}
+
+func (m *AllocsMetrics) CountKey() string  { return "alloc_objects" }
+func (m *AllocsMetrics) EventCount() int64 { return m.AllocObjects }
+func (m *AllocsMetrics) SetRate(r float64) { m.Rate = r }
+func (m *MutexMetrics) CountKey() string   { return "contention_count" }
+func (m *MutexMetrics) EventCount() int64  { return m.ContentionCount }
+func (m *MutexMetrics) SetRate(r float64)  { m.Rate = r }
+func (m *BlockMetrics) CountKey() string   { return "blocking_count" }
+func (m *BlockMetrics) EventCount() int64  { return m.BlockingCount }
+func (m *BlockMetrics) SetRate(r float64)  { m.Rate = r }
+
+// CapturedAt is when the profile was captured, falling back to when it was
+// stored
+func (p *Profile) CapturedAt() time.Time {
+	if p.ProfileTime != nil && !p.ProfileTime.IsZero() {
+		return *p.ProfileTime
+	}
+	return p.CreatedAt
+}
+
+// GenericMetrics sum up a pprof profile of a type without its own metrics,
+// such as threadcreate or a custom type, by one of its sample types
+type GenericMetrics struct {
+	SampleType   string           `json:"sample_type"`
+	Unit         string           `json:"unit"`
+	Total        int64            `json:"total"`
+	SampleCount  int64            `json:"sample_count"`
+	TopFunctions []FunctionSample `json:"top_functions"`
 }
 
 type GoroutineMetrics struct {
@@ -211,4 +718,51 @@ type K6Metrics struct {
 	DurationMS     int64   `json:"duration_ms"`
 	VUs            int     `json:"vus"`
 	VUsMax         int     `json:"vus_max"`
+	DataReceived   int64   `json:"data_received"`
+	DataSent       int64   `json:"data_sent"`
+	// Per-request costs, so runs at different load levels or durations
+	// compare fairly; latencies are per-request already
+	BytesPerRequest  float64 `json:"bytes_per_request"`
+	ErrorsPerRequest float64 `json:"errors_per_request"`
+	// StatusCodes counts requests by HTTP status code, when the summary
+	// breaks http_reqs down by status
+	StatusCodes map[string]int64 `json:"status_codes,omitempty"`
+	// Summary holds the metrics in the k6 summary, custom ones included, by
+	// name with their stats (count, rate, avg, p(95), ...), up to
+	// k6.MaxSummaryMetrics
+	Summary map[string]map[string]float64 `json:"summary,omitempty"`
+}
+
+// TraceMetrics summarizes a Go execution trace
+type TraceMetrics struct {
+	DurationNS int64 `json:"duration_ns"`
+	// SchedLatencyNS is the total time goroutines spent runnable, waiting
+	// for a P, before they ran
+	SchedLatencyNS int64           `json:"sched_latency_ns"`
+	GCTimeNS       int64           `json:"gc_time_ns"`
+	GCCount        int64           `json:"gc_count"`
+	SyscallTimeNS  int64           `json:"syscall_time_ns"`
+	GoroutineCount int64           `json:"goroutine_count"`
+	TopGoroutines  []GoroutineRuns `json:"top_goroutines"`
+}
+
+// GoroutineRuns counts how often goroutines started by one function were
+// scheduled onto a thread during a trace
+type GoroutineRuns struct {
+	Function   string `json:"function"`
+	Goroutines int64  `json:"goroutines"`
+	Runs       int64  `json:"runs"`
+}
+
+// RuntimeMetrics is a lightweight runtime health snapshot, ingested as JSON
+// in this shape or derived from runtime.MemStats / expvar output
+type RuntimeMetrics struct {
+	HeapAlloc      int64 `json:"heap_alloc"`
+	HeapInuse      int64 `json:"heap_inuse"`
+	HeapSys        int64 `json:"heap_sys"`
+	NextGC         int64 `json:"next_gc"`
+	NumGC          int64 `json:"num_gc"`
+	GCPauseTotalNS int64 `json:"gc_pause_total_ns"`
+	GCLastPauseNS  int64 `json:"gc_last_pause_ns"`
+	Goroutines     int64 `json:"goroutines"`
 }
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SessionHealth describes how recently a session received captures and
+// whether it appears to have stopped.
+type SessionHealth struct {
+	Session           string     `json:"session"`
+	ProfileCount      int        `json:"profile_count"`
+	LastCapture       *time.Time `json:"last_capture,omitempty"`
+	SinceLastNS       int64      `json:"since_last_ns,omitempty"`
+	TypicalIntervalNS int64      `json:"typical_interval_ns,omitempty"`
+	Stalled           bool       `json:"stalled"`
+}
+
+// NewSessionHealth infers session health from its profiles. The typical
+// interval is the median gap between consecutive captures of the same
+// profile type, so a round capturing several types at once doesn't skew it.
+// A session is stalled when nothing arrived for more than twice that interval.
+func NewSessionHealth(session string, profiles []*Profile, now time.Time) *SessionHealth {
+	health := &SessionHealth{
+		Session:      session,
+		ProfileCount: len(profiles),
+	}
+	if len(profiles) == 0 {
+		return health
+	}
+
+	byType := make(map[ProfileType][]time.Time)
+	var last time.Time
+	for _, p := range profiles {
+		byType[p.ProfileType] = append(byType[p.ProfileType], p.CreatedAt)
+		if p.CreatedAt.After(last) {
+			last = p.CreatedAt
+		}
+	}
+
+	health.LastCapture = &last
+	health.SinceLastNS = int64(now.Sub(last))
+
+	var gaps []time.Duration
+	for _, times := range byType {
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		for i := 1; i < len(times); i++ {
+			gaps = append(gaps, times[i].Sub(times[i-1]))
+		}
+	}
+	if len(gaps) == 0 {
+		return health
+	}
+
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	interval := gaps[len(gaps)/2]
+	health.TypicalIntervalNS = int64(interval)
+	health.Stalled = interval > 0 && now.Sub(last) > 2*interval
+
+	return health
+}
+
+// SessionSummary rolls up a session's profiles type by type
+type SessionSummary struct {
+	Session      string        `json:"session"`
+	ProfileCount int           `json:"profile_count"`
+	Types        []TypeSummary `json:"types"`
+	// Provenance lists the settings each capture run recorded, oldest first
+	Provenance []SessionProvenance `json:"provenance,omitempty"`
+}
+
+// SessionProvenance is how one capture run into a session was taken, as
+// sent with the run's first profile
+type SessionProvenance struct {
+	ProfileID string       `db:"id" json:"profile_id"`
+	CreatedAt time.Time    `db:"created_at" json:"created_at"`
+	Settings  NullableJSON `db:"provenance" json:"settings"`
+}
+
+// TypeSummary aggregates one profile type's headline metric (see
+// HeadlineMetrics) across a session: e.g. the average and peak CPU time,
+// or the worst k6 p95. Min, Max and Avg are nil when no profile has it.
+type TypeSummary struct {
+	ProfileType ProfileType `db:"profile_type" json:"profile_type"`
+	Count       int         `db:"count" json:"count"`
+	Metric      string      `db:"-" json:"metric,omitempty"`
+	Min         *float64    `db:"min" json:"min,omitempty"`
+	Max         *float64    `db:"max" json:"max,omitempty"`
+	Avg         *float64    `db:"avg" json:"avg,omitempty"`
+}
+
+// Percentiles describes the distribution of a quick-access metric across a
+// session's profiles of one type, telling a steady load apart from one that
+// usually idles but occasionally spikes. Percentiles are nearest-rank.
+type Percentiles struct {
+	Session     string      `json:"session"`
+	ProfileType ProfileType `json:"profile_type"`
+	Metric      string      `json:"metric"`
+	Count       int         `json:"count"`
+	Min         float64     `json:"min"`
+	Max         float64     `json:"max"`
+	Mean        float64     `json:"mean"`
+	P50         float64     `json:"p50"`
+	P90         float64     `json:"p90"`
+	P95         float64     `json:"p95"`
+	P99         float64     `json:"p99"`
+}
+
+// NewPercentiles summarizes values, which must be sorted lowest first and
+// not empty
+func NewPercentiles(session string, pt ProfileType, metric string, values []float64) *Percentiles {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(values)))) - 1
+		return values[max(i, 0)]
+	}
+	return &Percentiles{
+		Session:     session,
+		ProfileType: pt,
+		Metric:      metric,
+		Count:       len(values),
+		Min:         values[0],
+		Max:         values[len(values)-1],
+		Mean:        sum / float64(len(values)),
+		P50:         rank(50),
+		P90:         rank(90),
+		P95:         rank(95),
+		P99:         rank(99),
+	}
+}
+
+// MetricPoint is one profile's value of a metric at its capture time
+type MetricPoint struct {
+	CreatedAt time.Time `db:"created_at"`
+	Value     float64   `db:"value"`
+}
+
+// Heatmap buckets a session's metric by day and time of day, so recurring
+// patterns (a CPU spike every day at noon) line up in columns. Each row is
+// a calendar day; each of its cells covers one Bucket-wide slot of that
+// day, matching Columns. Slots aggregates each column across all days.
+// Cells with no profiles are null.
+type Heatmap struct {
+	Session  string         `json:"session"`
+	Metric   string         `json:"metric"`
+	Bucket   string         `json:"bucket"`
+	Timezone string         `json:"timezone"`
+	Count    int            `json:"count"`
+	Min      float64        `json:"min"`
+	Max      float64        `json:"max"`
+	Columns  []string       `json:"columns"`
+	Rows     []HeatmapRow   `json:"rows"`
+	Slots    []*HeatmapCell `json:"slots"`
+}
+
+// HeatmapRow is one day of a Heatmap
+type HeatmapRow struct {
+	Date  string         `json:"date"`
+	Cells []*HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell aggregates the profiles captured in one slot
+type HeatmapCell struct {
+	Count int     `json:"count"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+	sum   float64
+}
+
+func (c *HeatmapCell) add(v float64) {
+	if c.Count == 0 || v > c.Max {
+		c.Max = v
+	}
+	c.Count++
+	c.sum += v
+	c.Avg = c.sum / float64(c.Count)
+}
+
+// NewHeatmap lays points out on a day by time-of-day grid in loc. bucket
+// must divide a day evenly; days without any points are left out.
+func NewHeatmap(session, metric string, points []MetricPoint, bucket time.Duration, loc *time.Location) *Heatmap {
+	slots := int(24 * time.Hour / bucket)
+	hm := &Heatmap{
+		Session:  session,
+		Metric:   metric,
+		Bucket:   shortDuration(bucket),
+		Timezone: loc.String(),
+		Count:    len(points),
+		Columns:  make([]string, slots),
+		Rows:     []HeatmapRow{},
+		Slots:    make([]*HeatmapCell, slots),
+	}
+	for i := range slots {
+		hm.Columns[i] = time.Time{}.Add(time.Duration(i) * bucket).Format("15:04")
+	}
+
+	rows := make(map[string][]*HeatmapCell)
+	for i, p := range points {
+		if i == 0 || p.Value < hm.Min {
+			hm.Min = p.Value
+		}
+		if i == 0 || p.Value > hm.Max {
+			hm.Max = p.Value
+		}
+
+		t := p.CreatedAt.In(loc)
+		date := t.Format(time.DateOnly)
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		// Clamped for the extra hour of a day with a DST fall back
+		slot := min(int(t.Sub(midnight)/bucket), slots-1)
+
+		cells, ok := rows[date]
+		if !ok {
+			cells = make([]*HeatmapCell, slots)
+			rows[date] = cells
+		}
+		if cells[slot] == nil {
+			cells[slot] = &HeatmapCell{}
+		}
+		cells[slot].add(p.Value)
+		if hm.Slots[slot] == nil {
+			hm.Slots[slot] = &HeatmapCell{}
+		}
+		hm.Slots[slot].add(p.Value)
+	}
+
+	for date, cells := range rows {
+		hm.Rows = append(hm.Rows, HeatmapRow{Date: date, Cells: cells})
+	}
+	sort.Slice(hm.Rows, func(i, j int) bool { return hm.Rows[i].Date < hm.Rows[j].Date })
+	return hm
+}
+
+// shortDuration formats d without zero trailing units: 1h rather than 1h0m0s
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,64 @@
+package models
+
+// RankedProfile is a profile listing with the metric value it was ranked by
+type RankedProfile struct {
+	Profile
+	Value float64 `db:"value" json:"value"`
+	// URL links to the profile in the web UI
+	URL string `db:"-" json:"url"`
+}
+
+// Verdicts on how a headline metric moved between two profiles
+const (
+	VerdictImproved  = "improved"
+	VerdictRegressed = "regressed"
+	VerdictUnchanged = "unchanged"
+)
+
+// Verdict judges the change in a profile type's headline metric (see
+// HeadlineMetrics) from a base profile to a target
+type Verdict struct {
+	Verdict       string  `json:"verdict"`
+	Metric        string  `json:"metric"`
+	Base          float64 `json:"base"`
+	Target        float64 `json:"target"`
+	ChangePercent float64 `json:"change_percent"`
+	// Tolerance is the noise band, in percent either way, within which a
+	// change counts as unchanged
+	Tolerance float64 `json:"tolerance"`
+}
+
+// Judge compares the headline metric of two profiles of type pt. Changes
+// within tolerance percent either way are unchanged, so run-to-run noise
+// doesn't read as a regression. It returns nil for types without a
+// headline metric and for profiles missing it or with a zero base.
+func Judge(pt ProfileType, base, target *Profile, tolerance float64) *Verdict {
+	key, ok := HeadlineMetrics[pt]
+	if !ok {
+		return nil
+	}
+
+	a, okA := base.MetricValue(key)
+	b, okB := target.MetricValue(key)
+	if !okA || !okB || a == 0 {
+		return nil
+	}
+
+	// Lower is better for every headline metric
+	v := &Verdict{
+		Metric:        key,
+		Base:          a,
+		Target:        b,
+		ChangePercent: (b - a) / a * 100,
+		Tolerance:     tolerance,
+	}
+	switch {
+	case v.ChangePercent > tolerance:
+		v.Verdict = VerdictRegressed
+	case v.ChangePercent < -tolerance:
+		v.Verdict = VerdictImproved
+	default:
+		v.Verdict = VerdictUnchanged
+	}
+	return v
+}
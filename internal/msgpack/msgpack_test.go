@@ -0,0 +1,243 @@
+package msgpack
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	reference "github.com/vmihailenco/msgpack/v5"
+)
+
+// decode reads data back with an established MessagePack implementation,
+// widening every number so values compare regardless of encoded size
+func decode(t *testing.T, data []byte) any {
+	t.Helper()
+	dec := reference.NewDecoder(bytes.NewReader(data))
+	dec.UseLooseInterfaceDecoding(true)
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("reference decoder: %v", err)
+	}
+	if rest, _ := dec.Buffered().Read(make([]byte, 1)); rest > 0 {
+		t.Fatal("trailing bytes after the value")
+	}
+	return widen(v)
+}
+
+func widen(v any) any {
+	switch v := v.(type) {
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+	case map[string]any:
+		for k, item := range v {
+			v[k] = widen(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = widen(item)
+		}
+	}
+	return v
+}
+
+func TestMarshalInts(t *testing.T) {
+	tests := []struct {
+		n    int64
+		code byte // first byte: the smallest format that holds n
+		size int
+	}{
+		{0, 0x00, 1},
+		{math.MaxInt8, 0x7f, 1},
+		{math.MaxInt8 + 1, 0xcc, 2},
+		{math.MaxUint8, 0xcc, 2},
+		{math.MaxUint8 + 1, 0xcd, 3},
+		{math.MaxUint16, 0xcd, 3},
+		{math.MaxUint16 + 1, 0xce, 5},
+		{math.MaxUint32, 0xce, 5},
+		{math.MaxUint32 + 1, 0xcf, 9},
+		{math.MaxInt64, 0xcf, 9},
+		{-1, 0xff, 1},
+		{-32, 0xe0, 1},
+		{-33, 0xd0, 2},
+		{math.MinInt8, 0xd0, 2},
+		{math.MinInt8 - 1, 0xd1, 3},
+		{math.MinInt16, 0xd1, 3},
+		{math.MinInt16 - 1, 0xd2, 5},
+		{math.MinInt32, 0xd2, 5},
+		{math.MinInt32 - 1, 0xd3, 9},
+		{math.MinInt64, 0xd3, 9},
+	}
+	for _, tt := range tests {
+		data, err := Marshal(tt.n)
+		if err != nil {
+			t.Fatalf("%d: %v", tt.n, err)
+		}
+		if data[0] != tt.code || len(data) != tt.size {
+			t.Errorf("%d: encoded as %#x in %d bytes, want %#x in %d", tt.n, data[0], len(data), tt.code, tt.size)
+		}
+		if got := decode(t, data); got != tt.n {
+			t.Errorf("%d: decoded %v (%T)", tt.n, got, got)
+		}
+	}
+}
+
+func TestMarshalScalars(t *testing.T) {
+	tests := []struct {
+		in   any
+		want any
+	}{
+		{nil, nil},
+		{true, true},
+		{false, false},
+		{1.5, 1.5},
+		{-0.25, -0.25},
+		{1e300, 1e300},
+		{math.SmallestNonzeroFloat64, math.SmallestNonzeroFloat64},
+		// JSON doesn't tell 2.0 from 2, so whole floats come out as ints
+		{2.0, int64(2)},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64)},
+	}
+	for _, tt := range tests {
+		data, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.in, err)
+		}
+		if got := decode(t, data); got != tt.want {
+			t.Errorf("%v: decoded %v (%T), want %v (%T)", tt.in, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestMarshalStrings(t *testing.T) {
+	tests := []struct {
+		n    int
+		code byte
+	}{
+		{0, 0xa0},
+		{31, 0xbf},
+		{32, 0xd9},
+		{math.MaxUint8, 0xd9},
+		{math.MaxUint8 + 1, 0xda},
+		{math.MaxUint16, 0xda},
+		{math.MaxUint16 + 1, 0xdb},
+	}
+	for _, tt := range tests {
+		s := strings.Repeat("x", tt.n)
+		data, err := Marshal(s)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", tt.n, err)
+		}
+		if data[0] != tt.code {
+			t.Errorf("%d bytes: encoded as %#x, want %#x", tt.n, data[0], tt.code)
+		}
+		if got := decode(t, data); got != s {
+			t.Errorf("%d bytes: decoded a %d byte string", tt.n, len(got.(string)))
+		}
+	}
+
+	// Multi-byte characters count in bytes
+	data, err := Marshal("héllo, 世界")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decode(t, data); got != "héllo, 世界" {
+		t.Errorf("decoded %q", got)
+	}
+}
+
+func TestMarshalNested(t *testing.T) {
+	type point struct {
+		Metric string  `json:"metric"`
+		Value  float64 `json:"value"`
+		Note   string  `json:"note,omitempty"`
+	}
+	in := map[string]any{
+		"id":    "abc",
+		"total": 3,
+		"empty": map[string]any{},
+		"none":  nil,
+		"points": []point{
+			{Metric: "p95", Value: 120.5},
+			{Metric: "rps", Value: 900, Note: "steady"},
+		},
+		"nested": map[string]any{
+			"deeper": map[string]any{"list": []any{1, "two", 3.5, nil, []int{}}},
+		},
+	}
+	want := map[string]any{
+		"id":    "abc",
+		"total": int64(3),
+		"empty": map[string]any{},
+		"none":  nil,
+		"points": []any{
+			map[string]any{"metric": "p95", "value": 120.5},
+			map[string]any{"metric": "rps", "value": int64(900), "note": "steady"},
+		},
+		"nested": map[string]any{
+			"deeper": map[string]any{"list": []any{int64(1), "two", 3.5, nil, []any{}}},
+		},
+	}
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decode(t, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %#v\nwant %#v", got, want)
+	}
+}
+
+func TestMarshalLengths(t *testing.T) {
+	tests := []struct {
+		n                  int
+		arrayCode, mapCode byte
+	}{
+		{15, 0x9f, 0x8f},
+		{16, 0xdc, 0xde},
+		{math.MaxUint16, 0xdc, 0xde},
+		{math.MaxUint16 + 1, 0xdd, 0xdf},
+	}
+	for _, tt := range tests {
+		n := tt.n
+		list := make([]int, n)
+		m := make(map[string]int, n)
+		for i := range n {
+			list[i] = i
+			m[fmt.Sprintf("key-%d", i)] = i
+		}
+
+		data, err := Marshal(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[0] != tt.arrayCode {
+			t.Errorf("array of %d: encoded as %#x, want %#x", n, data[0], tt.arrayCode)
+		}
+		if got := decode(t, data).([]any); len(got) != n || got[n-1] != int64(n-1) {
+			t.Errorf("array of %d: decoded %d items", n, len(got))
+		}
+
+		data, err = Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[0] != tt.mapCode {
+			t.Errorf("map of %d: encoded as %#x, want %#x", n, data[0], tt.mapCode)
+		}
+		got := decode(t, data).(map[string]any)
+		if len(got) != n {
+			t.Errorf("map of %d: decoded %d entries", n, len(got))
+		}
+		for k, v := range m {
+			if got[k] != int64(v) {
+				t.Errorf("map of %d: %q = %v, want %d", n, k, got[k], v)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package pprof
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// DecimatedFrame names the frame that stands in for the samples Decimate
+// drops
+const DecimatedFrame = "[decimated]"
+
+// Decimate drops the long tail of a raw profile: every sample whose value in
+// the default sample type is below the given percentile of all samples'
+// values. The dropped values are folded into a single sample under
+// DecimatedFrame, so totals stay exact and each kept stack keeps its full
+// value, while the rest of the tail's stacks, functions and locations go.
+//
+// It returns the rewritten profile, gzipped, how many samples it has and
+// whether any were dropped. When nothing falls below the cut the input is
+// returned as is.
+func Decimate(data []byte, percentile float64) ([]byte, int, bool, error) {
+	p, err := decode(data)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if len(p.Sample) < 2 || percentile <= 0 {
+		return data, len(p.Sample), false, nil
+	}
+
+	idx, err := sampleIndex(p, "", "")
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	values := make([]int64, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		if idx < len(s.Value) {
+			values = append(values, s.Value[idx])
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	// Nearest-rank percentile
+	rank := int(math.Ceil(min(percentile, 100)/100*float64(len(values)))) - 1
+	cut := values[max(rank, 0)]
+
+	dropped := &profile.Sample{Value: make([]int64, len(p.SampleType))}
+	kept := p.Sample[:0]
+	var ndropped int
+	for _, s := range p.Sample {
+		if idx < len(s.Value) && s.Value[idx] < cut {
+			for i, v := range s.Value {
+				if i < len(dropped.Value) {
+					dropped.Value[i] += v
+				}
+			}
+			ndropped++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if ndropped == 0 {
+		return data, len(p.Sample), false, nil
+	}
+
+	var maxFn, maxLoc uint64
+	for _, fn := range p.Function {
+		maxFn = max(maxFn, fn.ID)
+	}
+	for _, loc := range p.Location {
+		maxLoc = max(maxLoc, loc.ID)
+	}
+	fn := &profile.Function{ID: maxFn + 1, Name: DecimatedFrame, SystemName: DecimatedFrame}
+	loc := &profile.Location{ID: maxLoc + 1, Line: []profile.Line{{Function: fn}}}
+	p.Function = append(p.Function, fn)
+	p.Location = append(p.Location, loc)
+	dropped.Location = []*profile.Location{loc}
+	p.Sample = append(kept, dropped)
+
+	// Compact drops the functions and locations only the tail used
+	p = p.Compact()
+	if err := p.CheckValid(); err != nil {
+		return nil, 0, false, fmt.Errorf("decimated profile is invalid: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return nil, 0, false, fmt.Errorf("write profile: %w", err)
+	}
+	return buf.Bytes(), len(p.Sample), true, nil
+}
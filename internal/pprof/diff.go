@@ -0,0 +1,404 @@
+package pprof
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/google/pprof/profile"
+)
+
+// Grouping modes for DiffOptions.GroupBy
+const (
+	GroupByFunction = "function"
+	GroupByPackage  = "package"
+	GroupByFile     = "file"
+)
+
+// topChanges is how many entries Diff.Regressed and Diff.Improved keep
+const topChanges = 5
+
+// DiffOptions controls how two profiles are compared
+type DiffOptions struct {
+	// ValueType and Unit select the sample type, as in TopOptions
+	ValueType string
+	Unit      string
+	// GroupBy rolls values up by function (default), package or source file
+	GroupBy string
+	// MinPercent drops functions below this share of their profile's total
+	// on both sides; MinDelta drops functions whose value moved by less.
+	// Both cut the noise of many tiny functions in large profiles.
+	MinPercent float64
+	MinDelta   int64
+}
+
+// FunctionDelta is the change in flat value for one function or package
+type FunctionDelta struct {
+	Name         string  `json:"name"`
+	Base         int64   `json:"base"`
+	Target       int64   `json:"target"`
+	Delta        int64   `json:"delta"`
+	DeltaPercent float64 `json:"delta_percent"`
+	// Objects is the matching change in object count when comparing heap
+	// bytes (see Diff.ObjectType)
+	Objects *ObjectDelta `json:"objects,omitempty"`
+}
+
+// ObjectDelta is the change in a function's object count
+type ObjectDelta struct {
+	Base   int64 `json:"base"`
+	Target int64 `json:"target"`
+	Delta  int64 `json:"delta"`
+}
+
+// Diff is a per-function comparison between a base and a target profile
+type Diff struct {
+	SampleType  string          `json:"sample_type"`
+	Unit        string          `json:"unit"`
+	GroupBy     string          `json:"group_by"`
+	BaseTotal   int64           `json:"base_total"`
+	TargetTotal int64           `json:"target_total"`
+	Functions   []FunctionDelta `json:"functions"`
+	// Total counts the functions that passed the filters, which Functions
+	// may hold only a page of (see Page)
+	Total int `json:"total"`
+	// Added and Removed repeat the functions only the target or only the
+	// base has, largest first: new code that's now hot, or work that went
+	// away, which a list sorted by change buries among the rest
+	Added   []FunctionDelta `json:"added"`
+	Removed []FunctionDelta `json:"removed"`
+	// Regressed and Improved are the few entries that grew or shrank the
+	// most, the files a change should be traced back to when grouping by
+	// file
+	Regressed []FunctionDelta `json:"regressed"`
+	Improved  []FunctionDelta `json:"improved"`
+	// ValueTypes lists the sample types the profiles can be compared by
+	ValueTypes []string `json:"value_types"`
+	// ObjectType is set when a heap's bytes are compared, naming the
+	// object count sample type each function's Objects comes from
+	ObjectType string `json:"object_type,omitempty"`
+	// Verdict judges the profiles' headline metric; it's filled in by the
+	// caller, which knows the profile type and the noise tolerance
+	Verdict *models.Verdict `json:"verdict,omitempty"`
+	// Gap is the time from the base's capture to the target's, filled in
+	// by the caller like Verdict
+	Gap time.Duration `json:"gap_ns"`
+	// Filtered counts functions dropped by MinPercent and MinDelta
+	Filtered int `json:"filtered,omitempty"`
+	// Warnings flag differences that can skew the comparison, such as a
+	// changed sampling rate
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// DiffProfiles compares the flat values of every function in two raw
+// profiles, largest absolute change first
+func DiffProfiles(base, target []byte, opts DiffOptions) (*Diff, error) {
+	groupBy := opts.GroupBy
+	if groupBy == "" {
+		groupBy = GroupByFunction
+	}
+	var key func(fn *profile.Function) string
+	switch groupBy {
+	case GroupByFunction:
+		key = functionName
+	case GroupByPackage:
+		key = func(fn *profile.Function) string { return PackageName(fn.Name) }
+	case GroupByFile:
+		key = fileName
+	default:
+		return nil, fmt.Errorf("unknown grouping: %s", groupBy)
+	}
+
+	bp, err := decode(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	tp, err := decode(target)
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+
+	bIdx, err := sampleIndex(bp, opts.ValueType, opts.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	st := bp.SampleType[bIdx]
+
+	// Compare the same sample type on both sides
+	tIdx := -1
+	for i, t := range tp.SampleType {
+		if t.Type == st.Type && t.Unit == st.Unit {
+			tIdx = i
+		}
+	}
+	if tIdx < 0 {
+		return nil, fmt.Errorf("target has no %s/%s sample type", st.Type, st.Unit)
+	}
+
+	baseValues, baseTotal := flatValues(bp, bIdx, key)
+	targetValues, targetTotal := flatValues(tp, tIdx, key)
+
+	// Comparing heap bytes, also diff the matching object counts: a leak of
+	// many small live objects shows in the count well before the bytes
+	var objectType string
+	var baseObjects, targetObjects map[string]int64
+	if prefix, ok := strings.CutSuffix(st.Type, "_space"); ok {
+		bo, to := sampleTypeIndex(bp, prefix+"_objects"), sampleTypeIndex(tp, prefix+"_objects")
+		if bo >= 0 && to >= 0 {
+			objectType = prefix + "_objects"
+			baseObjects, _ = flatValues(bp, bo, key)
+			targetObjects, _ = flatValues(tp, to, key)
+		}
+	}
+
+	names := make(map[string]bool, len(baseValues)+len(targetValues))
+	for name := range baseValues {
+		names[name] = true
+	}
+	for name := range targetValues {
+		names[name] = true
+	}
+
+	deltas := make([]FunctionDelta, 0, len(names))
+	var filtered int
+	for name := range names {
+		d := FunctionDelta{
+			Name:   name,
+			Base:   baseValues[name],
+			Target: targetValues[name],
+		}
+		if d.Base == 0 && d.Target == 0 {
+			continue
+		}
+		d.Delta = d.Target - d.Base
+		if d.Base != 0 {
+			d.DeltaPercent = float64(d.Delta) / float64(d.Base) * 100
+		}
+		if objectType != "" {
+			d.Objects = &ObjectDelta{Base: baseObjects[name], Target: targetObjects[name]}
+			d.Objects.Delta = d.Objects.Target - d.Objects.Base
+		}
+		if abs(d.Delta) < opts.MinDelta ||
+			(share(d.Base, baseTotal) < opts.MinPercent && share(d.Target, targetTotal) < opts.MinPercent) {
+			filtered++
+			continue
+		}
+		deltas = append(deltas, d)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		a, b := abs(deltas[i].Delta), abs(deltas[j].Delta)
+		if a != b {
+			return a > b
+		}
+		return deltas[i].Name < deltas[j].Name
+	})
+
+	added, removed := []FunctionDelta{}, []FunctionDelta{}
+	for _, d := range deltas {
+		switch {
+		case d.Base == 0:
+			added = append(added, d)
+		case d.Target == 0:
+			removed = append(removed, d)
+		}
+	}
+	sort.SliceStable(added, func(i, j int) bool { return abs(added[i].Target) > abs(added[j].Target) })
+	sort.SliceStable(removed, func(i, j int) bool { return abs(removed[i].Base) > abs(removed[j].Base) })
+
+	regressed, improved := []FunctionDelta{}, []FunctionDelta{}
+	for _, d := range deltas {
+		switch {
+		case d.Delta > 0 && len(regressed) < topChanges:
+			regressed = append(regressed, d)
+		case d.Delta < 0 && len(improved) < topChanges:
+			improved = append(improved, d)
+		}
+	}
+
+	var warnings []string
+	if bp.Period != tp.Period {
+		warnings = append(warnings, fmt.Sprintf("sampling period differs (base %d, target %d %s); a rate change can look like a change in the profile",
+			bp.Period, tp.Period, periodType(tp)))
+	}
+
+	return &Diff{
+		SampleType:  st.Type,
+		Unit:        st.Unit,
+		ValueTypes:  ValueTypes(bp),
+		ObjectType:  objectType,
+		GroupBy:     groupBy,
+		BaseTotal:   baseTotal,
+		TargetTotal: targetTotal,
+		Functions:   deltas,
+		Total:       len(deltas),
+		Added:       added,
+		Removed:     removed,
+		Regressed:   regressed,
+		Improved:    improved,
+		Filtered:    filtered,
+		Warnings:    warnings,
+	}, nil
+}
+
+// Page cuts Functions down to limit entries from offset on, in the same
+// largest-change-first order
+func (d *Diff) Page(offset, limit int) {
+	d.Functions = d.Functions[min(offset, len(d.Functions)):]
+	d.Functions = d.Functions[:min(limit, len(d.Functions))]
+}
+
+// share is value as a percentage of total
+func share(value, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(abs(value)) / float64(abs(total)) * 100
+}
+
+// WriteCSV writes one row per function, for spreadsheets
+func (d *Diff) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"function", "base_value", "target_value", "delta", "delta_percent"}
+	if d.ObjectType != "" {
+		header = append(header, "base_objects", "target_objects", "objects_delta")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, f := range d.Functions {
+		row := []string{
+			f.Name,
+			strconv.FormatInt(f.Base, 10),
+			strconv.FormatInt(f.Target, 10),
+			strconv.FormatInt(f.Delta, 10),
+			strconv.FormatFloat(f.DeltaPercent, 'f', 2, 64),
+		}
+		if f.Objects != nil {
+			row = append(row,
+				strconv.FormatInt(f.Objects.Base, 10),
+				strconv.FormatInt(f.Objects.Target, 10),
+				strconv.FormatInt(f.Objects.Delta, 10),
+			)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// DiffProfile builds a pprof diff profile like `go tool pprof -diff_base`:
+// the base's samples are negated and merged into the target, so every
+// value is target minus base. With normalize the base is first scaled to
+// the target's total, comparing shape rather than volume. The result is
+// gzipped protobuf, readable by any pprof tool.
+func DiffProfile(base, target []byte, normalize bool) ([]byte, error) {
+	bp, err := decode(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	tp, err := decode(target)
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+
+	ratio := -1.0
+	if normalize {
+		idx, err := sampleIndex(bp, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("base: %w", err)
+		}
+		_, baseTotal := flatValues(bp, idx, functionName)
+		_, targetTotal := flatValues(tp, idx, functionName)
+		if baseTotal != 0 {
+			ratio = -float64(targetTotal) / float64(baseTotal)
+		}
+	}
+	bp.Scale(ratio)
+
+	// Mark base samples the way pprof does, so tools can tell them apart
+	for _, sample := range bp.Sample {
+		if sample.Label == nil {
+			sample.Label = make(map[string][]string)
+		}
+		sample.Label["pprof::base"] = []string{"true"}
+	}
+
+	merged, err := profile.Merge([]*profile.Profile{tp, bp})
+	if err != nil {
+		return nil, fmt.Errorf("merge profiles: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := merged.Write(&buf); err != nil {
+		return nil, fmt.Errorf("write diff profile: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// PackageName extracts the Go package path from a symbol name, e.g.
+// "github.com/x/y.(*T).Method" → "github.com/x/y", "main.run.func1" → "main"
+func PackageName(fn string) string {
+	slash := strings.LastIndex(fn, "/")
+	if dot := strings.Index(fn[slash+1:], "."); dot >= 0 {
+		return fn[:slash+1+dot]
+	}
+	return fn
+}
+
+func functionName(fn *profile.Function) string {
+	return fn.Name
+}
+
+// fileName is the source file a function is defined in; functions without
+// line info, such as stripped binaries', fall under their package
+func fileName(fn *profile.Function) string {
+	if fn.Filename != "" {
+		return fn.Filename
+	}
+	return PackageName(fn.Name) + " (no file)"
+}
+
+// sampleTypeIndex returns the index of the sample type named typ, or -1
+func sampleTypeIndex(p *profile.Profile, typ string) int {
+	for i, st := range p.SampleType {
+		if st.Type == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+// flatValues sums each sample's value into its leaf function's key
+func flatValues(p *profile.Profile, idx int, key func(*profile.Function) string) (map[string]int64, int64) {
+	values := make(map[string]int64)
+	var total int64
+	for _, sample := range p.Sample {
+		if idx >= len(sample.Value) {
+			continue
+		}
+		value := sample.Value[idx]
+		total += value
+		if len(sample.Location) == 0 || len(sample.Location[0].Line) == 0 {
+			continue
+		}
+		if fn := sample.Location[0].Line[0].Function; fn != nil {
+			values[key(fn)] += value
+		}
+	}
+	return values, total
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package pprof
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// heapProfile builds a heap profile whose samples are allocated in fn, with
+// per-function values for alloc_objects, alloc_space, inuse_objects and
+// inuse_space in that order
+func heapProfile(t *testing.T, values map[string][4]int64) []byte {
+	t.Helper()
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		DefaultSampleType: "inuse_space",
+		PeriodType:        &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:            512 * 1024,
+	}
+	for name, v := range values {
+		id := uint64(len(p.Function) + 1)
+		fn := &profile.Function{ID: id, Name: name, SystemName: name}
+		loc := &profile.Location{ID: id, Line: []profile.Line{{Function: fn}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		p.Sample = append(p.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: v[:]})
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// A cache filling up with many small entries while evicting a few large
+// ones keeps its live bytes flat; only the object count shows the change
+func TestDiffProfilesObjectDelta(t *testing.T) {
+	base := heapProfile(t, map[string][4]int64{
+		"cache.Put":   {100, 64 << 10, 10, 64 << 10},
+		"server.Read": {50, 32 << 10, 5, 8 << 10},
+	})
+	target := heapProfile(t, map[string][4]int64{
+		"cache.Put":   {5000, 640 << 10, 1000, 64 << 10},
+		"server.Read": {50, 32 << 10, 5, 8 << 10},
+	})
+
+	diff, err := DiffProfiles(base, target, DiffOptions{ValueType: "inuse_space"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.ObjectType != "inuse_objects" {
+		t.Errorf("ObjectType = %q, want inuse_objects", diff.ObjectType)
+	}
+
+	var put *FunctionDelta
+	for i := range diff.Functions {
+		if diff.Functions[i].Name == "cache.Put" {
+			put = &diff.Functions[i]
+		}
+	}
+	if put == nil {
+		t.Fatalf("cache.Put missing from %+v", diff.Functions)
+	}
+	if put.Delta != 0 {
+		t.Errorf("cache.Put bytes delta = %d, want 0", put.Delta)
+	}
+	want := ObjectDelta{Base: 10, Target: 1000, Delta: 990}
+	if put.Objects == nil || *put.Objects != want {
+		t.Errorf("cache.Put objects = %+v, want %+v", put.Objects, want)
+	}
+
+	// Comparing the counts themselves has no object counts to attach
+	diff, err = DiffProfiles(base, target, DiffOptions{ValueType: "inuse_objects"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.ObjectType != "" || diff.Functions[0].Objects != nil {
+		t.Errorf("objects diff carries object deltas: %q %+v", diff.ObjectType, diff.Functions[0].Objects)
+	}
+}
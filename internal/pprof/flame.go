@@ -0,0 +1,149 @@
+package pprof
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// FlameOptions controls how a differential flame graph is built
+type FlameOptions struct {
+	// ValueType and Unit select the sample type, as in TopOptions
+	ValueType string
+	Unit      string
+	// Normalize scales the base to the target's total first, comparing
+	// shape rather than volume, as in DiffProfile
+	Normalize bool
+	// MinPercent drops frames below this share of their profile's total
+	// on both sides, with everything they call
+	MinPercent float64
+}
+
+// FlameNode is a frame of a differential flame graph, with the value of
+// the stacks through it in each profile. Children are the frames it
+// calls, by name.
+type FlameNode struct {
+	Name     string       `json:"name"`
+	Base     int64        `json:"base"`
+	Target   int64        `json:"target"`
+	Delta    int64        `json:"delta"`
+	Children []*FlameNode `json:"children,omitempty"`
+
+	index map[string]*FlameNode
+}
+
+// FlameDiff is the call tree of two profiles merged by stack path, root
+// first: a frame present in only one profile has zero on the other side
+type FlameDiff struct {
+	SampleType  string     `json:"sample_type"`
+	Unit        string     `json:"unit"`
+	BaseTotal   int64      `json:"base_total"`
+	TargetTotal int64      `json:"target_total"`
+	Root        *FlameNode `json:"root"`
+	// Filtered counts frames dropped by MinPercent
+	Filtered int `json:"filtered,omitempty"`
+	// Warnings flag differences that can skew the comparison, as in Diff
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// DiffFlameGraph builds the call trees of two raw profiles and merges them
+// into one, each node carrying the base and target values and the change
+func DiffFlameGraph(base, target []byte, opts FlameOptions) (*FlameDiff, error) {
+	bp, err := decode(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	tp, err := decode(target)
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+
+	bIdx, err := sampleIndex(bp, opts.ValueType, opts.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	st := bp.SampleType[bIdx]
+	if tIdx := sampleTypeIndex(tp, st.Type); tIdx < 0 || tp.SampleType[tIdx].Unit != st.Unit {
+		return nil, fmt.Errorf("target has no %s/%s sample type", st.Type, st.Unit)
+	}
+
+	bv, tv := newStackView(bp, st.Type), newStackView(tp, st.Type)
+	ratio := 1.0
+	if opts.Normalize && bv.total != 0 {
+		ratio = float64(tv.total) / float64(bv.total)
+	}
+
+	root := &FlameNode{Name: "root"}
+	for _, s := range bv.stacks {
+		value := int64(math.Round(float64(s.value) * ratio))
+		root.add(s.frames, func(n *FlameNode) { n.Base += value })
+	}
+	for _, s := range tv.stacks {
+		root.add(s.frames, func(n *FlameNode) { n.Target += s.value })
+	}
+
+	d := &FlameDiff{
+		SampleType:  st.Type,
+		Unit:        st.Unit,
+		BaseTotal:   root.Base,
+		TargetTotal: root.Target,
+		Root:        root,
+	}
+	d.Filtered = root.finish(d.BaseTotal, d.TargetTotal, opts.MinPercent)
+
+	if bp.Period != tp.Period {
+		d.Warnings = append(d.Warnings, fmt.Sprintf("sampling period differs (base %d, target %d %s); a rate change can look like a change in the profile",
+			bp.Period, tp.Period, periodType(tp)))
+	}
+	return d, nil
+}
+
+// add walks a leaf-first stack down from n, root first, applying fn to n
+// and every frame on the path
+func (n *FlameNode) add(frames []string, fn func(*FlameNode)) {
+	fn(n)
+	for i := len(frames) - 1; i >= 0; i-- {
+		child := n.index[frames[i]]
+		if child == nil {
+			if n.index == nil {
+				n.index = make(map[string]*FlameNode)
+			}
+			child = &FlameNode{Name: frames[i]}
+			n.index[frames[i]] = child
+			n.Children = append(n.Children, child)
+		}
+		fn(child)
+		n = child
+	}
+}
+
+// finish fills in the deltas below n, drops the children under minPercent
+// of both totals and sorts the rest by name, so the two sides of a change
+// keep their place. It returns how many frames were dropped.
+func (n *FlameNode) finish(baseTotal, targetTotal int64, minPercent float64) int {
+	n.Delta = n.Target - n.Base
+	n.index = nil
+
+	var filtered int
+	kept := n.Children[:0]
+	for _, child := range n.Children {
+		if share(child.Base, baseTotal) < minPercent && share(child.Target, targetTotal) < minPercent {
+			filtered += child.size()
+			continue
+		}
+		filtered += child.finish(baseTotal, targetTotal, minPercent)
+		kept = append(kept, child)
+	}
+	n.Children = kept
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	return filtered
+}
+
+// size counts n and the frames below it
+func (n *FlameNode) size() int {
+	count := 1
+	for _, child := range n.Children {
+		count += child.size()
+	}
+	return count
+}
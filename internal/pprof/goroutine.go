@@ -0,0 +1,182 @@
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/google/pprof/profile"
+)
+
+var (
+	// goroutineHeader starts each goroutine in a debug=2 dump, e.g.
+	// "goroutine 42 [chan receive, 5 minutes]:"
+	goroutineHeader = regexp.MustCompile(`^goroutine \d+ .*\[.*\]:$`)
+	// createdBy is the trailing frame naming the spawning goroutine, e.g.
+	// "created by main.main in goroutine 1"
+	createdBy = regexp.MustCompile(`^created by (.+?)(?: in goroutine \d+)?$`)
+)
+
+// isGoroutineDump reports whether data is a text goroutine dump as served by
+// /debug/pprof/goroutine?debug=2
+func isGoroutineDump(data []byte) bool {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	return goroutineHeader.Match(bytes.TrimSpace(line))
+}
+
+// dumpFrame is a call in a goroutine dump stack, its function normalized
+type dumpFrame struct {
+	function string
+	file     string
+	line     int64
+}
+
+// scanGoroutineDump calls fn with the stack of each goroutine in a debug=2
+// dump, innermost frame first, ending with its "created by" frame if any
+func scanGoroutineDump(data []byte, fn func(stack []dumpFrame)) {
+	var stack []dumpFrame
+	inGoroutine := false
+	flush := func() {
+		if inGoroutine {
+			fn(stack)
+		}
+		stack = stack[:0]
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case goroutineHeader.MatchString(line):
+			flush()
+			inGoroutine = true
+		case strings.HasPrefix(line, "\t"):
+			// "\t/src/main.go:42 +0x1d" places the frame above it
+			if len(stack) > 0 {
+				stack[len(stack)-1].file, stack[len(stack)-1].line = fileLine(line)
+			}
+		case line == "":
+		case inGoroutine:
+			stack = append(stack, dumpFrame{function: normalizeFrame(line)})
+		}
+	}
+	flush()
+}
+
+// fileLine splits a dump's "\tfile:line +0x1d" line, dropping the PC offset
+func fileLine(line string) (string, int64) {
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, " +0x"); i >= 0 {
+		line = line[:i]
+	}
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return line, 0
+	}
+	n, err := strconv.ParseInt(line[i+1:], 10, 64)
+	if err != nil {
+		return line, 0
+	}
+	return line[:i], n
+}
+
+// parseGoroutineDump groups the goroutines of a debug=2 dump by stack.
+// Frames are normalized before grouping: argument values, PC offsets and
+// goroutine IDs differ between otherwise identical goroutines and would
+// split them into separate stacks.
+func parseGoroutineDump(data []byte) *ParsedProfile {
+	metrics := &models.GoroutineMetrics{}
+	stackCounts := make(map[string]int64)
+
+	scanGoroutineDump(data, func(stack []dumpFrame) {
+		var key strings.Builder
+		for _, f := range stack {
+			key.WriteString(f.function)
+			key.WriteByte('\n')
+		}
+		metrics.GoroutineCount++
+		stackCounts[key.String()]++
+	})
+
+	metrics.TopStacks = topStacks(stackCounts, 10)
+
+	return &ParsedProfile{
+		Type:         models.ProfileTypeGoroutine,
+		Detected:     models.ProfileTypeGoroutine,
+		TotalSamples: metrics.GoroutineCount,
+		TotalValue:   metrics.GoroutineCount,
+		Metrics:      metrics,
+		Empty:        metrics.GoroutineCount == 0,
+	}
+}
+
+// goroutineDumpProfile converts a debug=2 dump into the goroutine profile
+// /debug/pprof/goroutine serves as protobuf: one sample per distinct stack,
+// counting its goroutines. Top, reports, comparisons and decimation then
+// work on stored dumps like on any other profile.
+func goroutineDumpProfile(data []byte) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		PeriodType: &profile.ValueType{Type: "goroutine", Unit: "count"},
+		Period:     1,
+	}
+	functions := make(map[[2]string]*profile.Function)
+	locations := make(map[dumpFrame]*profile.Location)
+	samples := make(map[string]*profile.Sample)
+
+	scanGoroutineDump(data, func(stack []dumpFrame) {
+		var key strings.Builder
+		var locs []*profile.Location
+		for _, f := range stack {
+			// The creating call isn't on the goroutine's stack
+			if strings.HasPrefix(f.function, "created by ") {
+				continue
+			}
+			loc := locations[f]
+			if loc == nil {
+				fn := functions[[2]string{f.function, f.file}]
+				if fn == nil {
+					fn = &profile.Function{ID: uint64(len(p.Function) + 1), Name: f.function, SystemName: f.function, Filename: f.file}
+					functions[[2]string{f.function, f.file}] = fn
+					p.Function = append(p.Function, fn)
+				}
+				loc = &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn, Line: f.line}}}
+				locations[f] = loc
+				p.Location = append(p.Location, loc)
+			}
+			locs = append(locs, loc)
+			key.WriteString(strconv.FormatUint(loc.ID, 10))
+			key.WriteByte(',')
+		}
+
+		if s := samples[key.String()]; s != nil {
+			s.Value[0]++
+			return
+		}
+		s := &profile.Sample{Location: locs, Value: []int64{1}}
+		samples[key.String()] = s
+		p.Sample = append(p.Sample, s)
+	})
+	return p
+}
+
+// normalizeFrame reduces a dump's function line to the function name:
+// "main.(*S).run(0xc000010000, {0x1, 0x2})" → "main.(*S).run",
+// "created by main.main in goroutine 1" → "created by main.main"
+func normalizeFrame(line string) string {
+	if m := createdBy.FindStringSubmatch(line); m != nil {
+		return "created by " + m[1]
+	}
+	// Arguments are the last parenthesized group; receivers like (*S) come
+	// earlier in the name
+	if strings.HasSuffix(line, ")") {
+		if i := strings.LastIndex(line, "("); i > 0 {
+			return line[:i]
+		}
+	}
+	return line
+}
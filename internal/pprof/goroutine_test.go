@@ -0,0 +1,107 @@
+package pprof
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/google/pprof/profile"
+)
+
+// Two goroutines parked at the same place: they differ only in goroutine
+// ID, wait time, argument values and PC offsets
+const sameStackDump = `goroutine 7 [chan receive, 5 minutes]:
+main.(*Worker).run(0xc000010000, {0x1, 0x2})
+	/src/app/worker.go:42 +0x1d
+created by main.main in goroutine 1
+	/src/app/main.go:17 +0x85
+
+goroutine 9 [chan receive]:
+main.(*Worker).run(0xc0000a4000, {0x3, 0x4})
+	/src/app/worker.go:42 +0x2f
+created by main.main in goroutine 1
+	/src/app/main.go:17 +0x91
+
+goroutine 1 [running]:
+main.main()
+	/src/app/main.go:20 +0x1c4
+`
+
+func TestParseGoroutineDumpGroupsByAddress(t *testing.T) {
+	parsed := parseGoroutineDump([]byte(sameStackDump))
+
+	if parsed.TotalSamples != 3 {
+		t.Errorf("TotalSamples = %d, want 3", parsed.TotalSamples)
+	}
+	metrics := parsed.Metrics.(*models.GoroutineMetrics)
+	if len(metrics.TopStacks) != 2 {
+		t.Fatalf("got %d stacks, want 2: %+v", len(metrics.TopStacks), metrics.TopStacks)
+	}
+	top := metrics.TopStacks[0]
+	want := []string{"main.(*Worker).run", "created by main.main"}
+	if top.Count != 2 || !slices.Equal(top.Stack, want) {
+		t.Errorf("top stack = %d × %q, want 2 × %q", top.Count, top.Stack, want)
+	}
+}
+
+func TestGoroutineDumpDecodes(t *testing.T) {
+	p, err := decode([]byte(sameStackDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("converted profile is invalid: %v", err)
+	}
+	if len(p.Sample) != 2 {
+		t.Fatalf("got %d samples, want 2", len(p.Sample))
+	}
+	s := p.Sample[0]
+	if s.Value[0] != 2 || len(s.Location) != 1 {
+		t.Fatalf("first sample = %v over %d frames, want 2 over 1", s.Value, len(s.Location))
+	}
+	if line := s.Location[0].Line[0]; line.Function.Name != "main.(*Worker).run" || line.Function.Filename != "/src/app/worker.go" || line.Line != 42 {
+		t.Errorf("frame = %s %s:%d, want main.(*Worker).run /src/app/worker.go:42", line.Function.Name, line.Function.Filename, line.Line)
+	}
+
+	// Decimation and stored diffs write the converted profile back out
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := profile.Parse(&buf); err != nil {
+		t.Fatalf("reparse: %v", err)
+	}
+
+	report, err := Top([]byte(sameStackDump), TopOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 3 || report.Rows[0].Function != "main.(*Worker).run" || report.Rows[0].Flat != 2 {
+		t.Errorf("top = %+v, want 3 goroutines, 2 in main.(*Worker).run", report)
+	}
+}
+
+// Goroutines on distinct stacks are one sample each once decoded; with
+// nothing under the cut the dump must come back untouched
+func TestDecimateGoroutineDumpKeepsDump(t *testing.T) {
+	dump := `goroutine 1 [running]:
+main.main()
+	/src/app/main.go:20 +0x1c4
+
+goroutine 7 [chan receive]:
+main.(*Worker).run(0xc000010000)
+	/src/app/worker.go:42 +0x1d
+
+goroutine 8 [select]:
+main.(*Server).serve(0xc000020000)
+	/src/app/server.go:88 +0x40
+`
+	data, kept, dropped, err := Decimate([]byte(dump), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped || kept != 3 || string(data) != dump {
+		t.Errorf("Decimate = %d samples, dropped %v, data changed %v; want the dump as is", kept, dropped, string(data) != dump)
+	}
+}
@@ -0,0 +1,377 @@
+package pprof
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/google/pprof/profile"
+)
+
+// Insight severities
+const (
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Insight is one finding of the rule set: a plain-language reading of the
+// profile, with the functions that support it
+type Insight struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Percent is the share of the profile's total the finding is about
+	Percent   float64                 `json:"percent"`
+	Functions []models.FunctionSample `json:"functions"`
+}
+
+// insightRule looks at one sample type of the profile types it applies to
+// and returns a finding, or nil when there's nothing to say
+type insightRule struct {
+	name      string
+	types     []models.ProfileType
+	valueType string
+	check     func(v *stackView) *Insight
+}
+
+// Thresholds of the rules; percents are of the sample type's total
+const (
+	gcInsightPercent        = 25
+	mallocInsightPercent    = 15
+	schedulerInsightPercent = 20
+	hotspotInsightPercent   = 40
+	concentratedInsightPct  = 50
+	smallObjectInsightBytes = 64
+	smallObjectInsightCount = 100_000
+	goroutineLeakCount      = 10_000
+	goroutinePileupCount    = 100
+	insightSupportFunctions = 5
+)
+
+var insightRules = []insightRule{
+	{
+		name:      "gc_dominates_cpu",
+		types:     []models.ProfileType{models.ProfileTypeCPU},
+		valueType: "cpu",
+		check: func(v *stackView) *Insight {
+			share, funcs := v.cumShare(isGCFrame)
+			if share < gcInsightPercent {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityWarning,
+				Message:   fmt.Sprintf("GC appears to dominate CPU (%.0f%% in garbage collection). Reduce the allocation rate, or give the heap more room with GOGC or GOMEMLIMIT.", share),
+				Percent:   share,
+				Functions: funcs,
+			}
+		},
+	},
+	{
+		name:      "allocation_heavy_cpu",
+		types:     []models.ProfileType{models.ProfileTypeCPU},
+		valueType: "cpu",
+		check: func(v *stackView) *Insight {
+			share, _ := v.cumShare(func(name string) bool { return name == "runtime.mallocgc" })
+			if share < mallocInsightPercent {
+				return nil
+			}
+			callers := v.sites(func(frames []string) bool { return slices.Contains(frames, "runtime.mallocgc") }, isRuntimeFrame)
+			return &Insight{
+				Severity:  SeverityWarning,
+				Message:   fmt.Sprintf("Allocation costs %.0f%% of CPU (runtime.mallocgc). The callers below allocate in a hot path; reuse buffers or preallocate.", share),
+				Percent:   share,
+				Functions: callers,
+			}
+		},
+	},
+	{
+		name:      "scheduler_overhead",
+		types:     []models.ProfileType{models.ProfileTypeCPU},
+		valueType: "cpu",
+		check: func(v *stackView) *Insight {
+			share, funcs := v.cumShare(isSchedulerFrame)
+			if share < schedulerInsightPercent {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityInfo,
+				Message:   fmt.Sprintf("The scheduler takes %.0f%% of CPU. Many short-lived goroutines or frequent channel hand-offs can cause this; batching work helps.", share),
+				Percent:   share,
+				Functions: funcs,
+			}
+		},
+	},
+	{
+		name:      "cpu_hotspot",
+		types:     []models.ProfileType{models.ProfileTypeCPU},
+		valueType: "cpu",
+		check: func(v *stackView) *Insight {
+			top := v.sites(nil, isRuntimeFrame)
+			if len(top) == 0 || top[0].Percent < hotspotInsightPercent {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityInfo,
+				Message:   fmt.Sprintf("%s accounts for %.0f%% of CPU, counting the runtime work it calls. It's the first place to optimize.", top[0].Name, top[0].Percent),
+				Percent:   top[0].Percent,
+				Functions: top[:1],
+			}
+		},
+	},
+	{
+		name:      "allocation_concentrated",
+		types:     []models.ProfileType{models.ProfileTypeHeap, models.ProfileTypeAllocs},
+		valueType: "alloc_space",
+		check: func(v *stackView) *Insight {
+			top := v.sites(nil, isRuntimeFrame)
+			if len(top) == 0 || top[0].Percent < concentratedInsightPct {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityInfo,
+				Message:   fmt.Sprintf("%s allocates %.0f%% of all bytes. If it runs in a loop, hoisting or pooling its allocations pays off most.", top[0].Name, top[0].Percent),
+				Percent:   top[0].Percent,
+				Functions: top,
+			}
+		},
+	},
+	{
+		name:      "small_allocations",
+		types:     []models.ProfileType{models.ProfileTypeHeap, models.ProfileTypeAllocs},
+		valueType: "alloc_objects",
+		check: func(v *stackView) *Insight {
+			bytes := v.totals["alloc_space"]
+			if v.total < smallObjectInsightCount || bytes/v.total >= smallObjectInsightBytes {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityInfo,
+				Message:   fmt.Sprintf("%d objects averaging %d bytes were allocated. Many small allocations cost more GC work than their size suggests.", v.total, bytes/v.total),
+				Percent:   100,
+				Functions: v.sites(nil, isRuntimeFrame),
+			}
+		},
+	},
+	{
+		name:      "memory_retained",
+		types:     []models.ProfileType{models.ProfileTypeHeap},
+		valueType: "inuse_space",
+		check: func(v *stackView) *Insight {
+			top := v.sites(nil, isRuntimeFrame)
+			if len(top) == 0 || top[0].Percent < concentratedInsightPct {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityInfo,
+				Message:   fmt.Sprintf("Memory allocated by %s holds %.0f%% of the live heap. If this grows between captures, something keeps a reference to it.", top[0].Name, top[0].Percent),
+				Percent:   top[0].Percent,
+				Functions: top,
+			}
+		},
+	},
+	{
+		name:      "lock_contention_concentrated",
+		types:     []models.ProfileType{models.ProfileTypeMutex, models.ProfileTypeBlock},
+		valueType: "delay",
+		check: func(v *stackView) *Insight {
+			top := v.sites(nil, isLockFrame)
+			if len(top) == 0 || top[0].Percent < concentratedInsightPct {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityWarning,
+				Message:   fmt.Sprintf("Contention is concentrated in one place: %.0f%% of the waiting happens in %s. Shorten the critical section or shard the lock.", top[0].Percent, top[0].Name),
+				Percent:   top[0].Percent,
+				Functions: top,
+			}
+		},
+	},
+	{
+		name:      "goroutine_count",
+		types:     []models.ProfileType{models.ProfileTypeGoroutine},
+		valueType: "goroutine",
+		check: func(v *stackView) *Insight {
+			if v.total < goroutineLeakCount {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityWarning,
+				Message:   fmt.Sprintf("%d goroutines are running. Unless the load explains it, goroutines may be leaking.", v.total),
+				Percent:   100,
+				Functions: v.sites(nil, isRuntimeFrame),
+			}
+		},
+	},
+	{
+		name:      "goroutine_pileup",
+		types:     []models.ProfileType{models.ProfileTypeGoroutine},
+		valueType: "goroutine",
+		check: func(v *stackView) *Insight {
+			top := v.sites(nil, isRuntimeFrame)
+			if len(top) == 0 || top[0].Value < goroutinePileupCount || top[0].Percent < concentratedInsightPct {
+				return nil
+			}
+			return &Insight{
+				Severity:  SeverityWarning,
+				Message:   fmt.Sprintf("%d goroutines (%.0f%%) are waiting in %s. Goroutines piling up in one place are often blocked on a channel or lock that's never released.", top[0].Value, top[0].Percent, top[0].Name),
+				Percent:   top[0].Percent,
+				Functions: top[:1],
+			}
+		},
+	},
+}
+
+// Insights runs the rule set over a raw profile and returns its findings,
+// warnings first, then by share. Rules for other profile types, or whose
+// sample type the profile doesn't have, are skipped.
+func Insights(data []byte, pt models.ProfileType) ([]Insight, error) {
+	p, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make(map[string]*stackView)
+	insights := []Insight{}
+	for _, rule := range insightRules {
+		if !slices.Contains(rule.types, pt) {
+			continue
+		}
+		v, ok := views[rule.valueType]
+		if !ok {
+			v = newStackView(p, rule.valueType)
+			views[rule.valueType] = v
+		}
+		if v == nil || v.total == 0 {
+			continue
+		}
+		if insight := rule.check(v); insight != nil {
+			insight.Rule = rule.name
+			insights = append(insights, *insight)
+		}
+	}
+
+	sort.SliceStable(insights, func(i, j int) bool {
+		a, b := insights[i], insights[j]
+		if a.Severity != b.Severity {
+			return a.Severity == SeverityWarning
+		}
+		return a.Percent > b.Percent
+	})
+	return insights, nil
+}
+
+// stackView is a profile's stacks valued by one sample type
+type stackView struct {
+	stacks []weightedStack
+	total  int64
+	// totals holds the total of every sample type, for rules that relate
+	// two of them
+	totals map[string]int64
+}
+
+type weightedStack struct {
+	frames []string // leaf first
+	value  int64
+}
+
+// newStackView returns nil if the profile has no sample type valueType
+func newStackView(p *profile.Profile, valueType string) *stackView {
+	idx := sampleTypeIndex(p, valueType)
+	if idx < 0 {
+		return nil
+	}
+
+	v := &stackView{totals: make(map[string]int64, len(p.SampleType))}
+	for _, sample := range p.Sample {
+		if idx >= len(sample.Value) {
+			continue
+		}
+		for i, st := range p.SampleType {
+			if i < len(sample.Value) {
+				v.totals[st.Type] += sample.Value[i]
+			}
+		}
+		if sample.Value[idx] == 0 {
+			continue
+		}
+
+		var frames []string
+		for _, loc := range sample.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil {
+					frames = append(frames, line.Function.Name)
+				}
+			}
+		}
+		v.stacks = append(v.stacks, weightedStack{frames: frames, value: sample.Value[idx]})
+		v.total += sample.Value[idx]
+	}
+	return v
+}
+
+// cumShare returns the percent of the total in stacks with a frame that
+// matches, and the matching functions by cumulative value
+func (v *stackView) cumShare(match func(name string) bool) (float64, []models.FunctionSample) {
+	var value int64
+	funcs := make(map[string]int64)
+	for _, s := range v.stacks {
+		seen := make(map[string]bool)
+		for _, name := range s.frames {
+			if match(name) && !seen[name] {
+				seen[name] = true
+				funcs[name] += s.value
+			}
+		}
+		if len(seen) > 0 {
+			value += s.value
+		}
+	}
+	return percent(value, v.total), topFunctions(funcs, v.total, insightSupportFunctions)
+}
+
+// sites attributes each stack, or each one filter accepts, to its innermost
+// frame that skip rejects: the user code behind runtime or library work.
+// It returns the top sites by value.
+func (v *stackView) sites(filter func(frames []string) bool, skip func(name string) bool) []models.FunctionSample {
+	values := make(map[string]int64)
+	for _, s := range v.stacks {
+		if filter != nil && !filter(s.frames) {
+			continue
+		}
+		for _, name := range s.frames {
+			if !skip(name) {
+				values[name] += s.value
+				break
+			}
+		}
+	}
+	return topFunctions(values, v.total, insightSupportFunctions)
+}
+
+func isRuntimeFrame(name string) bool {
+	return strings.HasPrefix(name, "runtime.") || strings.HasPrefix(name, "internal/")
+}
+
+// isLockFrame also skips the sync package, so contention lands on the code
+// taking the lock rather than on sync.(*Mutex).Unlock
+func isLockFrame(name string) bool {
+	return isRuntimeFrame(name) || strings.HasPrefix(name, "sync.")
+}
+
+func isGCFrame(name string) bool {
+	switch name {
+	case "runtime.gcBgMarkWorker", "runtime.gcAssistAlloc", "runtime.bgsweep", "runtime.bgscavenge":
+		return true
+	}
+	return false
+}
+
+func isSchedulerFrame(name string) bool {
+	switch name {
+	case "runtime.schedule", "runtime.findRunnable", "runtime.park_m", "runtime.goready", "runtime.ready":
+		return true
+	}
+	return false
+}
@@ -12,36 +12,62 @@ import (
 )
 
 type ParsedProfile struct {
-	Type         models.ProfileType
+	Type models.ProfileType
+	// Detected is the type the profile's sample types indicate, or empty
+	// when they don't identify one and Type is a guess
+	Detected     models.ProfileType
 	DurationNS   int64
 	TotalSamples int64
 	TotalValue   int64
 	Metrics      any
+	// SampleTypes are the profile's sample types in order; nil for a text
+	// goroutine dump
+	SampleTypes models.ValueTypeList
+	// Empty is set when the profile has no samples, e.g. block profiling
+	// disabled in the target or an idle process during a CPU capture
+	Empty bool
 }
 
+// Parse parses a pprof profile, detecting its type from its sample types
 func Parse(data []byte) (*ParsedProfile, error) {
-	// Try to decompress if gzipped
-	reader := bytes.NewReader(data)
-	var r io.Reader = reader
+	return ParseAs(data, "")
+}
 
-	if gr, err := gzip.NewReader(reader); err == nil {
-		r = gr
-		defer gr.Close()
-	} else {
-		reader.Seek(0, io.SeekStart)
+// ParseAs parses a pprof profile known to be of type pt, for types that
+// can't be told apart by sample types alone: an allocs profile has the same
+// sample types as a heap profile. An empty pt detects the type.
+func ParseAs(data []byte, pt models.ProfileType) (*ParsedProfile, error) {
+	// Text goroutine dumps (debug=2) aren't protobuf
+	if isGoroutineDump(data) {
+		return parseGoroutineDump(data), nil
 	}
 
-	p, err := profile.Parse(r)
+	p, err := decode(data)
 	if err != nil {
-		return nil, fmt.Errorf("parse profile: %w", err)
+		return nil, err
 	}
 
 	result := &ParsedProfile{
 		DurationNS: p.DurationNanos,
 	}
+	for _, st := range p.SampleType {
+		result.SampleTypes = append(result.SampleTypes, models.ValueType{Type: st.Type, Unit: st.Unit})
+	}
 
-	// Determine profile type from sample types
-	result.Type = detectProfileType(p)
+	// Determine profile type from sample types; within heap and allocs the
+	// caller's word wins, as only the default sample type differs, and a
+	// custom type's sample types can be anything
+	result.Detected = detectProfileType(p)
+	result.Type = result.Detected
+	custom, isCustom := pt.Custom()
+	switch {
+	case isCustom:
+		result.Type = pt
+	case result.Type == "":
+		result.Type = models.ProfileTypeCPU
+	case typeFamily(pt) == models.ProfileTypeHeap && typeFamily(result.Type) == models.ProfileTypeHeap:
+		result.Type = pt
+	}
 
 	// Calculate totals and extract metrics based on type
 	switch result.Type {
@@ -49,12 +75,20 @@ func Parse(data []byte) (*ParsedProfile, error) {
 		result.Metrics = extractCPUMetrics(p)
 	case models.ProfileTypeHeap:
 		result.Metrics = extractHeapMetrics(p)
+	case models.ProfileTypeAllocs:
+		result.Metrics = extractAllocsMetrics(p)
 	case models.ProfileTypeMutex:
 		result.Metrics = extractMutexMetrics(p)
 	case models.ProfileTypeBlock:
 		result.Metrics = extractBlockMetrics(p)
 	case models.ProfileTypeGoroutine:
 		result.Metrics = extractGoroutineMetrics(p)
+	case models.ProfileTypeWall:
+		result.Metrics = extractWallMetrics(p)
+	default:
+		// Any other type, threadcreate or custom, gets at least its top
+		// functions
+		result.Metrics = extractGenericMetrics(p, custom.SampleType)
 	}
 
 	// Calculate totals
@@ -64,11 +98,42 @@ func Parse(data []byte) (*ParsedProfile, error) {
 			result.TotalValue += sample.Value[0]
 		}
 	}
+	result.Empty = result.TotalSamples == 0
 
 	return result, nil
 }
 
+// decode parses raw pprof data, gzipped or plain. A text goroutine dump,
+// stored as uploaded, is converted to the equivalent goroutine profile.
+func decode(data []byte) (*profile.Profile, error) {
+	if isGoroutineDump(data) {
+		return goroutineDumpProfile(data), nil
+	}
+
+	// Try to decompress if gzipped
+	reader := bytes.NewReader(data)
+	var r io.Reader = reader
+
+	if gr, err := gzip.NewReader(reader); err == nil {
+		r = gr
+		defer gr.Close()
+	} else {
+		reader.Seek(0, io.SeekStart)
+	}
+
+	p, err := profile.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("parse profile: %w", err)
+	}
+	return p, nil
+}
+
 func detectProfileType(p *profile.Profile) models.ProfileType {
+	// fgprof's wall-clock profiles start with samples/count like CPU
+	// profiles; their period type and time sample type tell them apart
+	if (p.PeriodType != nil && p.PeriodType.Type == "wallclock") || sampleTypeIndex(p, "time") >= 0 {
+		return models.ProfileTypeWall
+	}
 	for _, st := range p.SampleType {
 		switch st.Type {
 		case "cpu", "samples":
@@ -76,6 +141,11 @@ func detectProfileType(p *profile.Profile) models.ProfileType {
 				return models.ProfileTypeCPU
 			}
 		case "alloc_objects", "alloc_space", "inuse_objects", "inuse_space":
+			// /debug/pprof/allocs serves the heap profile with alloc_space
+			// as its default sample type
+			if p.DefaultSampleType == "alloc_space" {
+				return models.ProfileTypeAllocs
+			}
 			return models.ProfileTypeHeap
 		case "contentions", "delay":
 			return models.ProfileTypeMutex
@@ -83,24 +153,70 @@ func detectProfileType(p *profile.Profile) models.ProfileType {
 			return models.ProfileTypeBlock
 		case "goroutine":
 			return models.ProfileTypeGoroutine
+		case "threadcreate":
+			return models.ProfileTypeThreadCreate
 		}
 	}
-	return models.ProfileTypeCPU
+	return ""
+}
+
+// typeFamily groups profile types whose profiles share sample types: allocs
+// with heap, and block with mutex, which even look the same
+func typeFamily(pt models.ProfileType) models.ProfileType {
+	switch pt {
+	case models.ProfileTypeAllocs:
+		return models.ProfileTypeHeap
+	case models.ProfileTypeBlock:
+		return models.ProfileTypeMutex
+	}
+	return pt
+}
+
+// TypeMismatch reports whether the profile doesn't look like the declared
+// type, e.g. a CPU profile uploaded as heap. Profiles whose sample types
+// don't identify a type never mismatch. ambiguous is set when declared and
+// detected are heap and allocs: only the default sample type tells those
+// apart, so the declared type may well be right.
+func (p *ParsedProfile) TypeMismatch(declared models.ProfileType) (mismatch, ambiguous bool) {
+	if declared == "" || p.Detected == "" || declared == p.Detected {
+		return false, false
+	}
+	if _, ok := declared.Custom(); ok {
+		return false, false
+	}
+	if typeFamily(declared) != typeFamily(p.Detected) {
+		return true, false
+	}
+	// Block and mutex profiles can't be told apart at all
+	if typeFamily(declared) == models.ProfileTypeMutex {
+		return false, false
+	}
+	return true, true
 }
 
 func extractCPUMetrics(p *profile.Profile) *models.CPUMetrics {
 	metrics := &models.CPUMetrics{
-		SampleCount: int64(len(p.Sample)),
+		SampleCount:    int64(len(p.Sample)),
+		WallDurationNS: p.DurationNanos,
+	}
+
+	// Go CPU profiles carry samples/count then cpu/nanoseconds; use the
+	// nanoseconds value when present so totals are real CPU time
+	valueIdx := 0
+	for i, st := range p.SampleType {
+		if st.Type == "cpu" && st.Unit == "nanoseconds" {
+			valueIdx = i
+		}
 	}
 
 	funcValues := make(map[string]int64)
 	var totalValue int64
 
 	for _, sample := range p.Sample {
-		if len(sample.Value) == 0 || len(sample.Location) == 0 {
+		if len(sample.Value) <= valueIdx || len(sample.Location) == 0 {
 			continue
 		}
-		value := sample.Value[0]
+		value := sample.Value[valueIdx]
 		totalValue += value
 
 		for _, loc := range sample.Location {
@@ -113,11 +229,96 @@ func extractCPUMetrics(p *profile.Profile) *models.CPUMetrics {
 	}
 
 	metrics.TotalCPUTimeNS = totalValue
+	if metrics.WallDurationNS > 0 {
+		metrics.Parallelism = float64(totalValue) / float64(metrics.WallDurationNS)
+	}
 	metrics.TopFunctions = topFunctions(funcValues, totalValue, 10)
 
 	return metrics
 }
 
+func extractWallMetrics(p *profile.Profile) *models.WallMetrics {
+	metrics := &models.WallMetrics{
+		SampleCount:    int64(len(p.Sample)),
+		WallDurationNS: p.DurationNanos,
+	}
+
+	// fgprof records samples/count then time/nanoseconds
+	valueIdx := max(sampleTypeIndex(p, "time"), 0)
+
+	funcValues := make(map[string]int64)
+	var totalValue int64
+
+	for _, sample := range p.Sample {
+		if len(sample.Value) <= valueIdx || len(sample.Location) == 0 {
+			continue
+		}
+		value := sample.Value[valueIdx]
+		totalValue += value
+
+		for _, loc := range sample.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil {
+					funcValues[line.Function.Name] += value
+				}
+			}
+		}
+	}
+
+	metrics.TotalWallTimeNS = totalValue
+	if metrics.WallDurationNS > 0 {
+		metrics.Concurrency = float64(totalValue) / float64(metrics.WallDurationNS)
+	}
+	metrics.TopFunctions = topFunctions(funcValues, totalValue, 10)
+
+	return metrics
+}
+
+// extractGenericMetrics ranks the functions of a profile without a type of
+// its own by the named sample type, else the profile's declared default,
+// else the first
+func extractGenericMetrics(p *profile.Profile, sampleType string) *models.GenericMetrics {
+	if len(p.SampleType) == 0 {
+		return &models.GenericMetrics{}
+	}
+	valueIdx := sampleTypeIndex(p, sampleType)
+	if valueIdx < 0 && p.DefaultSampleType != "" {
+		valueIdx = sampleTypeIndex(p, p.DefaultSampleType)
+	}
+	valueIdx = max(valueIdx, 0)
+
+	metrics := &models.GenericMetrics{
+		SampleType:  p.SampleType[valueIdx].Type,
+		Unit:        p.SampleType[valueIdx].Unit,
+		SampleCount: int64(len(p.Sample)),
+	}
+	funcValues := make(map[string]int64)
+
+	for _, sample := range p.Sample {
+		if len(sample.Value) <= valueIdx {
+			continue
+		}
+		value := sample.Value[valueIdx]
+		metrics.Total += value
+
+		// Count a function once per stack, so recursion can't push it
+		// past the total; unsymbolized frames have no name to show
+		seen := make(map[string]bool)
+		for _, loc := range sample.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil && line.Function.Name != "" && !seen[line.Function.Name] {
+					seen[line.Function.Name] = true
+					funcValues[line.Function.Name] += value
+				}
+			}
+		}
+	}
+
+	metrics.TopFunctions = topFunctions(funcValues, metrics.Total, 10)
+
+	return metrics
+}
+
 func extractHeapMetrics(p *profile.Profile) *models.HeapMetrics {
 	metrics := &models.HeapMetrics{}
 
@@ -137,6 +338,7 @@ func extractHeapMetrics(p *profile.Profile) *models.HeapMetrics {
 	}
 
 	funcValues := make(map[string]int64)
+	inuseObjValues := make(map[string]int64)
 
 	for _, sample := range p.Sample {
 		if allocSpaceIdx >= 0 && allocSpaceIdx < len(sample.Value) {
@@ -158,18 +360,72 @@ func extractHeapMetrics(p *profile.Profile) *models.HeapMetrics {
 					if line.Function != nil && allocSpaceIdx >= 0 {
 						funcValues[line.Function.Name] += sample.Value[allocSpaceIdx]
 					}
+					if line.Function != nil && inuseObjIdx >= 0 && inuseObjIdx < len(sample.Value) {
+						inuseObjValues[line.Function.Name] += sample.Value[inuseObjIdx]
+					}
 				}
 			}
 		}
 	}
 
 	metrics.TopAllocators = topFunctions(funcValues, metrics.AllocSize, 10)
+	metrics.TopInuseObjects = topFunctions(inuseObjValues, metrics.InuseObjects, 10)
 
 	return metrics
 }
 
+func extractAllocsMetrics(p *profile.Profile) *models.AllocsMetrics {
+	metrics := &models.AllocsMetrics{}
+
+	allocSpaceIdx, allocObjIdx := -1, -1
+	for i, st := range p.SampleType {
+		switch st.Type {
+		case "alloc_space":
+			allocSpaceIdx = i
+		case "alloc_objects":
+			allocObjIdx = i
+		}
+	}
+
+	spaceValues := make(map[string]int64)
+	objectValues := make(map[string]int64)
+
+	for _, sample := range p.Sample {
+		var space, objects int64
+		if allocSpaceIdx >= 0 && allocSpaceIdx < len(sample.Value) {
+			space = sample.Value[allocSpaceIdx]
+		}
+		if allocObjIdx >= 0 && allocObjIdx < len(sample.Value) {
+			objects = sample.Value[allocObjIdx]
+		}
+		metrics.AllocSize += space
+		metrics.AllocObjects += objects
+
+		for _, loc := range sample.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil {
+					spaceValues[line.Function.Name] += space
+					objectValues[line.Function.Name] += objects
+				}
+			}
+		}
+	}
+
+	metrics.TopAllocators = topFunctions(spaceValues, metrics.AllocSize, 10)
+	metrics.TopObjectAllocators = topFunctions(objectValues, metrics.AllocObjects, 10)
+
+	return metrics
+}
+
+// The Go runtime already scales mutex and block values by the sampling
+// rate. The profile's period is kept anyway so that, for producers which
+// record their rate there, a rate change is flagged in compares rather than
+// read as a contention change.
 func extractMutexMetrics(p *profile.Profile) *models.MutexMetrics {
-	metrics := &models.MutexMetrics{}
+	metrics := &models.MutexMetrics{
+		SamplingPeriod:     p.Period,
+		SamplingPeriodType: periodType(p),
+	}
 	funcValues := make(map[string]int64)
 
 	for _, sample := range p.Sample {
@@ -193,7 +449,10 @@ func extractMutexMetrics(p *profile.Profile) *models.MutexMetrics {
 }
 
 func extractBlockMetrics(p *profile.Profile) *models.BlockMetrics {
-	metrics := &models.BlockMetrics{}
+	metrics := &models.BlockMetrics{
+		SamplingPeriod:     p.Period,
+		SamplingPeriodType: periodType(p),
+	}
 	funcValues := make(map[string]int64)
 
 	for _, sample := range p.Sample {
@@ -216,31 +475,46 @@ func extractBlockMetrics(p *profile.Profile) *models.BlockMetrics {
 	return metrics
 }
 
-func extractGoroutineMetrics(p *profile.Profile) *models.GoroutineMetrics {
-	metrics := &models.GoroutineMetrics{
-		GoroutineCount: int64(len(p.Sample)),
+// periodType renders a profile's period type as type/unit
+func periodType(p *profile.Profile) string {
+	if p.PeriodType == nil {
+		return ""
 	}
+	return p.PeriodType.Type + "/" + p.PeriodType.Unit
+}
 
+func extractGoroutineMetrics(p *profile.Profile) *models.GoroutineMetrics {
+	metrics := &models.GoroutineMetrics{}
 	stackCounts := make(map[string]int64)
 
 	for _, sample := range p.Sample {
-		var stack []string
+		// Each sample aggregates every goroutine with the same PCs
+		count := int64(1)
+		if len(sample.Value) > 0 {
+			count = sample.Value[0]
+		}
+		metrics.GoroutineCount += count
+
+		// Key by function name so stacks that differ only in call-site
+		// addresses group together
+		key := ""
 		for _, loc := range sample.Location {
 			for _, line := range loc.Line {
 				if line.Function != nil {
-					stack = append(stack, line.Function.Name)
+					key += line.Function.Name + "\n"
 				}
 			}
 		}
-
-		key := ""
-		for _, s := range stack {
-			key += s + "\n"
-		}
-		stackCounts[key]++
+		stackCounts[key] += count
 	}
 
-	// Get top stacks
+	metrics.TopStacks = topStacks(stackCounts, 10)
+
+	return metrics
+}
+
+// topStacks returns the n most common stacks, keyed by newline-joined frames
+func topStacks(stackCounts map[string]int64, n int) []models.StackSample {
 	type kv struct {
 		stack string
 		count int64
@@ -250,17 +524,20 @@ func extractGoroutineMetrics(p *profile.Profile) *models.GoroutineMetrics {
 		sorted = append(sorted, kv{k, v})
 	}
 	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].count > sorted[j].count
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].stack < sorted[j].stack
 	})
 
-	for i := 0; i < 10 && i < len(sorted); i++ {
-		metrics.TopStacks = append(metrics.TopStacks, models.StackSample{
+	var stacks []models.StackSample
+	for i := 0; i < n && i < len(sorted); i++ {
+		stacks = append(stacks, models.StackSample{
 			Count: sorted[i].count,
 			Stack: splitStack(sorted[i].stack),
 		})
 	}
-
-	return metrics
+	return stacks
 }
 
 func topFunctions(funcValues map[string]int64, total int64, n int) []models.FunctionSample {
@@ -272,8 +549,12 @@ func topFunctions(funcValues map[string]int64, total int64, n int) []models.Func
 	for k, v := range funcValues {
 		sorted = append(sorted, kv{k, v})
 	}
+	// Break ties by name so the same profile always yields the same metrics
 	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].value > sorted[j].value
+		if sorted[i].value != sorted[j].value {
+			return sorted[i].value > sorted[j].value
+		}
+		return sorted[i].name < sorted[j].name
 	})
 
 	var result []models.FunctionSample
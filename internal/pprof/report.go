@@ -0,0 +1,295 @@
+package pprof
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Report kinds, named after the `go tool pprof` flags they mirror
+const (
+	ReportTree   = "tree"
+	ReportPeek   = "peek"
+	ReportTraces = "traces"
+)
+
+// maxFocusLength caps focus regexes from requests. Go regexps run in
+// linear time, so this only bounds the cost of compiling one.
+const maxFocusLength = 256
+
+// ReportOptions controls how a text report is built
+type ReportOptions struct {
+	// Kind is ReportTree, ReportPeek or ReportTraces
+	Kind string
+	// ValueType and Unit select the sample type, as in TopOptions
+	ValueType string
+	Unit      string
+	// Focus is a regexp of function names. Tree and traces keep only the
+	// stacks through a matching function, like pprof's -focus; peek shows
+	// the matching functions with their callers and callees, and needs it.
+	Focus string
+	// N limits the number of functions or traces; 0 means all
+	N int
+}
+
+// Report is a text report over a profile's stacks, rendered by WriteText
+// in the layout of the matching `go tool pprof` report
+type Report struct {
+	kind       string
+	sampleType string
+	unit       string
+	// total is the whole profile's; stacks holds those left after focus
+	total  int64
+	stacks []weightedStack
+	focus  *regexp.Regexp
+	n      int
+}
+
+// NewReport builds a tree, peek or traces report for a raw profile
+func NewReport(data []byte, opts ReportOptions) (*Report, error) {
+	switch opts.Kind {
+	case ReportTree, ReportTraces:
+	case ReportPeek:
+		if opts.Focus == "" {
+			return nil, fmt.Errorf("peek needs a focus regexp of the functions to show")
+		}
+	default:
+		return nil, fmt.Errorf("unknown report type: %s", opts.Kind)
+	}
+
+	var focus *regexp.Regexp
+	if opts.Focus != "" {
+		if len(opts.Focus) > maxFocusLength {
+			return nil, fmt.Errorf("focus regexp is longer than %d characters", maxFocusLength)
+		}
+		var err error
+		if focus, err = regexp.Compile(opts.Focus); err != nil {
+			return nil, fmt.Errorf("invalid focus regexp: %w", err)
+		}
+	}
+
+	p, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := sampleIndex(p, opts.ValueType, opts.Unit)
+	if err != nil {
+		return nil, err
+	}
+	st := p.SampleType[idx]
+	view := newStackView(p, st.Type)
+
+	r := &Report{
+		kind:       opts.Kind,
+		sampleType: st.Type,
+		unit:       st.Unit,
+		total:      view.total,
+		focus:      focus,
+		n:          opts.N,
+	}
+	for _, s := range view.stacks {
+		if focus == nil || opts.Kind == ReportPeek || matchesAny(focus, s.frames) {
+			r.stacks = append(r.stacks, s)
+		}
+	}
+	return r, nil
+}
+
+func matchesAny(re *regexp.Regexp, frames []string) bool {
+	for _, name := range frames {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText renders the report
+func (r *Report) WriteText(w io.Writer) error {
+	if r.kind == ReportTraces {
+		return r.writeTraces(w)
+	}
+	return r.writeTree(w)
+}
+
+const traceSeparator = "-----------+-------------------------------------------------------"
+
+// writeTraces lists each distinct stack with its value, largest first, as
+// `go tool pprof -traces` does
+func (r *Report) writeTraces(w io.Writer) error {
+	values := make(map[string]int64)
+	var order []string
+	for _, s := range r.stacks {
+		key := strings.Join(s.frames, "\n")
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] += s.value
+	}
+	sort.SliceStable(order, func(i, j int) bool { return values[order[i]] > values[order[j]] })
+	if r.n > 0 && len(order) > r.n {
+		order = order[:r.n]
+	}
+
+	var shown int64
+	for _, key := range order {
+		shown += values[key]
+	}
+	if _, err := fmt.Fprintf(w, "Type: %s\nShowing %d traces accounting for %s, %.2f%% of %s total\n",
+		r.sampleType, len(order), FormatValue(shown, r.unit), percent(shown, r.total), FormatValue(r.total, r.unit)); err != nil {
+		return err
+	}
+
+	for _, key := range order {
+		if _, err := fmt.Fprintln(w, traceSeparator); err != nil {
+			return err
+		}
+		value := FormatValue(values[key], r.unit)
+		for _, frame := range strings.Split(key, "\n") {
+			if _, err := fmt.Fprintf(w, "%10s   %s\n", value, frame); err != nil {
+				return err
+			}
+			value = ""
+		}
+	}
+	_, err := fmt.Fprintln(w, traceSeparator)
+	return err
+}
+
+// callGraph holds each function's flat and cumulative value and the value
+// of every caller/callee edge
+type callGraph struct {
+	flat, cum map[string]int64
+	// callers[f][g] is the value of stacks where g calls f; callees is
+	// the reverse
+	callers, callees map[string]map[string]int64
+}
+
+func newCallGraph(stacks []weightedStack) *callGraph {
+	g := &callGraph{
+		flat:    make(map[string]int64),
+		cum:     make(map[string]int64),
+		callers: make(map[string]map[string]int64),
+		callees: make(map[string]map[string]int64),
+	}
+	addEdge := func(edges map[string]map[string]int64, from, to string, value int64) {
+		if edges[from] == nil {
+			edges[from] = make(map[string]int64)
+		}
+		edges[from][to] += value
+	}
+
+	for _, s := range stacks {
+		if len(s.frames) == 0 {
+			continue
+		}
+		g.flat[s.frames[0]] += s.value
+		// Recursion counts a function or edge once per stack
+		seen := make(map[string]bool)
+		seenEdge := make(map[[2]string]bool)
+		for i, name := range s.frames {
+			if !seen[name] {
+				seen[name] = true
+				g.cum[name] += s.value
+			}
+			if i+1 == len(s.frames) {
+				continue
+			}
+			caller := s.frames[i+1]
+			if edge := [2]string{caller, name}; !seenEdge[edge] {
+				seenEdge[edge] = true
+				addEdge(g.callers, name, caller, s.value)
+				addEdge(g.callees, caller, name, s.value)
+			}
+		}
+	}
+	return g
+}
+
+const treeSeparator = "----------------------------------------------------------+-------------"
+
+// writeTree lists functions by flat value, each with its callers above and
+// callees below, as `go tool pprof -tree` and -peek do
+func (r *Report) writeTree(w io.Writer) error {
+	g := newCallGraph(r.stacks)
+
+	var nodes []string
+	for name := range g.cum {
+		if r.kind != ReportPeek || r.focus.MatchString(name) {
+			nodes = append(nodes, name)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if g.flat[a] != g.flat[b] {
+			return g.flat[a] > g.flat[b]
+		}
+		if g.cum[a] != g.cum[b] {
+			return g.cum[a] > g.cum[b]
+		}
+		return a < b
+	})
+	if r.n > 0 && len(nodes) > r.n {
+		nodes = nodes[:r.n]
+	}
+
+	var shown int64
+	for _, name := range nodes {
+		shown += g.flat[name]
+	}
+	if _, err := fmt.Fprintf(w, "Showing nodes accounting for %s, %.2f%% of %s total\n",
+		FormatValue(shown, r.unit), percent(shown, r.total), FormatValue(r.total, r.unit)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%10s %6s %6s %10s %6s   calls calls%% + context\n", "flat", "flat%", "sum%", "cum", "cum%"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, treeSeparator); err != nil {
+		return err
+	}
+
+	var sum int64
+	for _, name := range nodes {
+		flat, cum := g.flat[name], g.cum[name]
+		sum += flat
+		if err := r.writeEdges(w, g.callers[name], cum); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%10s %5.2f%% %5.2f%% %10s %5.2f%%                | %s\n",
+			FormatValue(flat, r.unit), percent(flat, r.total), percent(sum, r.total),
+			FormatValue(cum, r.unit), percent(cum, r.total), name); err != nil {
+			return err
+		}
+		if err := r.writeEdges(w, g.callees[name], cum); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, treeSeparator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEdges lists a function's callers or callees, largest first, each
+// with its share of the function's cumulative value
+func (r *Report) writeEdges(w io.Writer, edges map[string]int64, cum int64) error {
+	names := make([]string, 0, len(edges))
+	for name := range edges {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if edges[names[i]] != edges[names[j]] {
+			return edges[names[i]] > edges[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%50s %6.2f%% |   %s\n",
+			FormatValue(edges[name], r.unit), percent(edges[name], cum), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
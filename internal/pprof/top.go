@@ -0,0 +1,266 @@
+package pprof
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// unitAliases maps the short unit names accepted by the API to pprof units
+var unitAliases = map[string]string{
+	"samples": "count",
+	"count":   "count",
+	"ns":      "nanoseconds",
+	"bytes":   "bytes",
+}
+
+// TopOptions controls how a top report is built
+type TopOptions struct {
+	// ValueType selects the sample type by name (e.g. inuse_space,
+	// alloc_objects), like pprof's -sample_index. Takes precedence over Unit.
+	ValueType string
+	// Unit selects the sample type by unit (samples, ns, bytes).
+	// Empty uses the profile's default sample type.
+	Unit string
+	// Cum sorts by cumulative instead of flat value
+	Cum bool
+	// N limits the number of rows; 0 means all
+	N int
+}
+
+// TopRow is one function in a top report
+type TopRow struct {
+	Function   string  `json:"function"`
+	Flat       int64   `json:"flat"`
+	FlatPct    float64 `json:"flat_pct"`
+	SumPct     float64 `json:"sum_pct"`
+	Cum        int64   `json:"cum"`
+	CumPct     float64 `json:"cum_pct"`
+	FlatString string  `json:"flat_string"`
+	CumString  string  `json:"cum_string"`
+}
+
+// TopReport mirrors the table printed by `go tool pprof -top`
+type TopReport struct {
+	SampleType string   `json:"sample_type"`
+	Unit       string   `json:"unit"`
+	Total      int64    `json:"total"`
+	Shown      int64    `json:"shown"`
+	Rows       []TopRow `json:"rows"`
+}
+
+// Top builds a flat/cum table for a raw profile
+func Top(data []byte, opts TopOptions) (*TopReport, error) {
+	p, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := sampleIndex(p, opts.ValueType, opts.Unit)
+	if err != nil {
+		return nil, err
+	}
+	st := p.SampleType[idx]
+
+	flat := make(map[string]int64)
+	cum := make(map[string]int64)
+	var total int64
+
+	for _, sample := range p.Sample {
+		if idx >= len(sample.Value) {
+			continue
+		}
+		value := sample.Value[idx]
+		total += value
+
+		seen := make(map[string]bool)
+		for i, loc := range sample.Location {
+			for j, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				// The leaf frame is the innermost inlined line of the first location
+				if i == 0 && j == 0 {
+					flat[name] += value
+				}
+				if !seen[name] {
+					seen[name] = true
+					cum[name] += value
+				}
+			}
+		}
+	}
+
+	rows := make([]TopRow, 0, len(cum))
+	for name, c := range cum {
+		rows = append(rows, TopRow{Function: name, Flat: flat[name], Cum: c})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if opts.Cum {
+			if a.Cum != b.Cum {
+				return a.Cum > b.Cum
+			}
+		} else if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		}
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Function < b.Function
+	})
+
+	if opts.N > 0 && len(rows) > opts.N {
+		rows = rows[:opts.N]
+	}
+
+	report := &TopReport{
+		SampleType: st.Type,
+		Unit:       st.Unit,
+		Total:      total,
+	}
+
+	var sum int64
+	for i := range rows {
+		row := &rows[i]
+		sum += row.Flat
+		row.FlatPct = percent(row.Flat, total)
+		row.SumPct = percent(sum, total)
+		row.CumPct = percent(row.Cum, total)
+		row.FlatString = FormatValue(row.Flat, st.Unit)
+		row.CumString = FormatValue(row.Cum, st.Unit)
+	}
+	report.Shown = sum
+	report.Rows = rows
+
+	return report, nil
+}
+
+// WriteText renders the report in the same layout as `go tool pprof -top`
+func (r *TopReport) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Showing nodes accounting for %s, %.2f%% of %s total\n",
+		FormatValue(r.Shown, r.Unit), percent(r.Shown, r.Total), FormatValue(r.Total, r.Unit)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%10s %6s %6s %10s %6s\n", "flat", "flat%", "sum%", "cum", "cum%"); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		if _, err := fmt.Fprintf(w, "%10s %5.2f%% %5.2f%% %10s %5.2f%%  %s\n",
+			row.FlatString, row.FlatPct, row.SumPct, row.CumString, row.CumPct, row.Function); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FormatValue renders a sample value in its unit the way pprof does
+func FormatValue(v int64, unit string) string {
+	switch unit {
+	case "nanoseconds":
+		return time.Duration(v).String()
+	case "bytes":
+		return formatBytes(v)
+	default:
+		return fmt.Sprintf("%d", v)
+	}
+}
+
+func formatBytes(v int64) string {
+	const unit = 1024
+	abs := v
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < unit {
+		return fmt.Sprintf("%dB", v)
+	}
+	div, exp := int64(unit), 0
+	for n := abs / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%cB", float64(v)/float64(div), "KMGTPE"[exp])
+}
+
+// sampleIndex finds the sample type to report on, by name first, then by
+// unit. With neither it follows pprof's default: the declared default type,
+// else the last one.
+func sampleIndex(p *profile.Profile, valueType, unit string) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+
+	if valueType != "" {
+		for i, st := range p.SampleType {
+			if st.Type == valueType {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown value type %q (available: %s)", valueType, strings.Join(ValueTypes(p), ", "))
+	}
+
+	if unit == "" {
+		for i, st := range p.SampleType {
+			if st.Type == p.DefaultSampleType {
+				return i, nil
+			}
+		}
+		return len(p.SampleType) - 1, nil
+	}
+
+	want, ok := unitAliases[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit: %s", unit)
+	}
+	// Prefer the last match, which is the pprof default for Go profiles
+	// (e.g. cpu/nanoseconds over samples/count, inuse over alloc).
+	for i := len(p.SampleType) - 1; i >= 0; i-- {
+		if p.SampleType[i].Unit == want {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("profile has no sample type with unit %s", unit)
+}
+
+// ValueTypes lists the sample type names of a profile
+func ValueTypes(p *profile.Profile) []string {
+	names := make([]string, len(p.SampleType))
+	for i, st := range p.SampleType {
+		names[i] = st.Type
+	}
+	return names
+}
+
+// CommonValueTypes decodes each raw profile and returns the sample type
+// names all of them have, in the first profile's order
+func CommonValueTypes(raws ...[]byte) ([]string, error) {
+	var common []string
+	for i, raw := range raws {
+		p, err := decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			common = ValueTypes(p)
+			continue
+		}
+		common = slices.DeleteFunc(common, func(typ string) bool {
+			return sampleTypeIndex(p, typ) < 0
+		})
+	}
+	return common, nil
+}
+
+func percent(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) / float64(total) * 100
+}
@@ -0,0 +1,77 @@
+package runtimestats
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// memStats is the subset of runtime.MemStats perfkit reads, as encoded by
+// json.Marshal or published by expvar under "memstats"
+type memStats struct {
+	HeapAlloc    int64      `json:"HeapAlloc"`
+	HeapInuse    int64      `json:"HeapInuse"`
+	HeapSys      int64      `json:"HeapSys"`
+	NextGC       int64      `json:"NextGC"`
+	NumGC        int64      `json:"NumGC"`
+	PauseTotalNs int64      `json:"PauseTotalNs"`
+	PauseNs      [256]int64 `json:"PauseNs"`
+}
+
+// Parse parses a runtime metrics snapshot. Three shapes are accepted:
+//
+//   - the documented perfkit shape (see models.RuntimeMetrics)
+//   - a JSON-encoded runtime.MemStats
+//   - the expvar /debug/vars output, which nests MemStats under "memstats"
+//     and may publish a top-level "goroutines" count
+func Parse(data []byte) (*models.RuntimeMetrics, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("parse runtime json: %w", err)
+	}
+
+	if raw, ok := fields["memstats"]; ok {
+		metrics, err := parseMemStats(raw)
+		if err != nil {
+			return nil, err
+		}
+		if raw, ok := fields["goroutines"]; ok {
+			if err := json.Unmarshal(raw, &metrics.Goroutines); err != nil {
+				return nil, fmt.Errorf("parse goroutines: %w", err)
+			}
+		}
+		return metrics, nil
+	}
+
+	if _, ok := fields["HeapAlloc"]; ok {
+		return parseMemStats(data)
+	}
+
+	var metrics models.RuntimeMetrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("parse runtime json: %w", err)
+	}
+	return &metrics, nil
+}
+
+func parseMemStats(data []byte) (*models.RuntimeMetrics, error) {
+	var ms memStats
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("parse memstats: %w", err)
+	}
+
+	metrics := &models.RuntimeMetrics{
+		HeapAlloc:      ms.HeapAlloc,
+		HeapInuse:      ms.HeapInuse,
+		HeapSys:        ms.HeapSys,
+		NextGC:         ms.NextGC,
+		NumGC:          ms.NumGC,
+		GCPauseTotalNS: ms.PauseTotalNs,
+	}
+	// PauseNs is a circular buffer; the latest pause sits at (NumGC+255)%256
+	if ms.NumGC > 0 {
+		metrics.GCLastPauseNS = ms.PauseNs[(ms.NumGC+255)%256]
+	}
+	return metrics, nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// minAnomalyHistory is how many earlier profiles a session needs before
+// outliers are flagged; fewer make the deviation meaningless
+const minAnomalyHistory = 5
+
+// anomalyWindow is how many of the latest earlier profiles the mean and
+// deviation are taken over
+const anomalyWindow = 100
+
+// minAnomalySpread is the least deviation assumed, as a fraction of the
+// mean, so a flat history doesn't flag every tiny increase
+const minAnomalySpread = 0.05
+
+// flagAnomaly tags p as an anomaly when its headline metric lies more than
+// AnomalySigma standard deviations above the mean of the latest earlier
+// profiles of its type in the session, and returns a warning saying so. It must run
+// before p is saved. Errors are logged rather than failing the ingest.
+func (s *Server) flagAnomaly(ctx context.Context, p *models.Profile) string {
+	sigma := s.cfg.Server.AnomalySigma
+	key, ok := models.HeadlineMetrics[p.ProfileType]
+	if sigma <= 0 || p.Session == "" || !ok {
+		return ""
+	}
+	value, ok := p.MetricValue(key)
+	if !ok {
+		return ""
+	}
+
+	history, err := s.store.MetricHistory(ctx, p.Session, p.ProfileType, key, anomalyWindow)
+	if err != nil {
+		log.Printf("Failed to load metric history for anomaly check: %v", err)
+		return ""
+	}
+	if len(history) < minAnomalyHistory {
+		return ""
+	}
+
+	var sum float64
+	for _, v := range history {
+		sum += v
+	}
+	mean := sum / float64(len(history))
+	var variance float64
+	for _, v := range history {
+		variance += (v - mean) * (v - mean)
+	}
+	stddev := max(math.Sqrt(variance/float64(len(history))), minAnomalySpread*math.Abs(mean))
+
+	limit := mean + sigma*stddev
+	if stddev == 0 || value <= limit {
+		return ""
+	}
+
+	p.Tags = append(p.Tags, models.TagAnomaly)
+	return fmt.Sprintf("%s %g is above the session's mean %.1f + %gσ (%.1f)", key, value, mean, sigma, limit)
+}
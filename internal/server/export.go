@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/ui"
+)
+
+// exportTmpl is the standalone comparison page, with the UI's assets and
+// the profiles inlined so it opens without a perfkit server
+var exportTmpl = template.Must(template.ParseFS(ui.TemplatesFS(), "export.html"))
+
+// exportData fills export.html
+type exportData struct {
+	Title       string
+	Theme       string
+	ProfileType models.ProfileType
+	Exported    string
+	Warnings    []string
+	Profiles    []*models.Profile
+	CSS         template.CSS
+	JS          template.JS
+}
+
+// fontURL matches the stylesheet's references to the served fonts
+var fontURL = regexp.MustCompile(`url\('/fonts/([^']+)'\)`)
+
+// exportAssets reads the UI's stylesheet, with its fonts as data URLs, and
+// script once for all exports
+var exportAssets = sync.OnceValues(func() ([2]string, error) {
+	css, err := fs.ReadFile(ui.StaticFS(), "style.css")
+	if err != nil {
+		return [2]string{}, err
+	}
+	js, err := fs.ReadFile(ui.StaticFS(), "app.js")
+	if err != nil {
+		return [2]string{}, err
+	}
+
+	var fontErr error
+	css = fontURL.ReplaceAllFunc(css, func(m []byte) []byte {
+		name := string(fontURL.FindSubmatch(m)[1])
+		font, err := fs.ReadFile(ui.FontsFS(), name)
+		if err != nil {
+			fontErr = err
+			return m
+		}
+		return fmt.Appendf(nil, "url('data:font/woff2;base64,%s')", base64.StdEncoding.EncodeToString(font))
+	})
+	if fontErr != nil {
+		return [2]string{}, fontErr
+	}
+	return [2]string{string(css), string(js)}, nil
+})
+
+// handleCompareExport renders a comparison as a self-contained HTML file,
+// for attaching to tickets and post-mortems
+func (s *Server) handleCompareExport(w http.ResponseWriter, r *http.Request) {
+	profiles, ok := s.compareProfiles(w, r)
+	if !ok {
+		return
+	}
+
+	assets, err := exportAssets()
+	if err != nil {
+		log.Printf("Failed to load export assets: %v", err)
+		http.Error(w, "Failed to export comparison", http.StatusInternalServerError)
+		return
+	}
+
+	// The page has no response headers to carry warnings, so show them
+	// above the comparison
+	warnings := w.Header().Values("X-Perfkit-Warning")
+	now := time.Now().UTC()
+	data := exportData{
+		Title:       s.cfg.UI.Title,
+		Theme:       s.cfg.UI.Theme,
+		ProfileType: profiles[0].ProfileType,
+		Exported:    now.Format(time.RFC3339),
+		Warnings:    warnings,
+		Profiles:    profiles,
+		CSS:         template.CSS(assets[0]),
+		JS:          template.JS(assets[1]),
+	}
+
+	var buf bytes.Buffer
+	if err := exportTmpl.Execute(&buf, data); err != nil {
+		log.Printf("Failed to render export: %v", err)
+		http.Error(w, "Failed to export comparison", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("perfkit-compare-%s-%s.html", data.ProfileType, now.Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Write(buf.Bytes())
+}
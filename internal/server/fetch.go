@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/capture"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// fetchRequest is the body of POST /api/pprof/ingest/url
+type fetchRequest struct {
+	// URL is the target's base URL, as given to perfkit capture
+	URL     string   `json:"url"`
+	Type    string   `json:"type"`
+	Session string   `json:"session"`
+	Project string   `json:"project"`
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags"`
+	// Seconds is the CPU profile or trace duration (default 30)
+	Seconds int `json:"seconds"`
+	// Window is the monitoring window a snapshot stands for, e.g. "5m"
+	Window string `json:"window"`
+}
+
+// handleIngestURL pulls a profile from a target's pprof endpoint and
+// ingests it, for producers the server can reach but that can't push
+func (s *Server) handleIngestURL(w http.ResponseWriter, r *http.Request) {
+	var req fetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	pt := models.ProfileType(req.Type)
+	if _, ok := capture.ProfileEndpoint[pt]; !ok {
+		http.Error(w, "Invalid profile type: "+req.Type, http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "Invalid url: must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if len(s.cfg.Server.FetchAllowlist) == 0 {
+		http.Error(w, "Fetching profiles by URL is disabled: set server.fetch_allowlist", http.StatusForbidden)
+		return
+	}
+	if !s.fetchAllowed(target) {
+		http.Error(w, "Fetching from "+target.Host+" is not allowed by server.fetch_allowlist", http.StatusForbidden)
+		return
+	}
+
+	c := capture.New(strings.TrimRight(req.URL, "/"), "")
+	c.MaxSize = s.cfg.Server.MaxIngestSize
+	c.SetHTTPClient(&http.Client{
+		Timeout: 5 * time.Minute,
+		// No proxy: the dialer must see the target's own address
+		Transport: &http.Transport{
+			DialContext:         s.fetchDial,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		// A redirect must not lead somewhere the allowlist doesn't cover
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !s.fetchAllowed(next.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", next.URL.Host)
+			}
+			return nil
+		},
+	})
+	if req.Seconds > 0 {
+		c.CPUDuration = time.Duration(req.Seconds) * time.Second
+	}
+
+	result := c.CaptureProfile(pt)
+	if errors.Is(result.Error, capture.ErrTooLarge) {
+		http.Error(w, fmt.Sprintf("Fetched %s profile is over server.max_ingest_size (%d bytes)", pt, c.MaxSize),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if result.Error != nil {
+		log.Printf("Failed to fetch %s profile from %s: %v", pt, target.Host, result.Error)
+		http.Error(w, "Failed to fetch profile: "+result.Error.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// Hand the fetched profile to the ingest handler as if it was uploaded
+	q := url.Values{}
+	q.Set("type", req.Type)
+	q.Set("source", "url")
+	q.Set("host", target.Hostname())
+	q.Set("profile_time", result.CapturedAt.Format(time.RFC3339Nano))
+	if req.Session != "" {
+		q.Set("session", req.Session)
+	}
+	// withProject's path project wins over the body's
+	if project := r.URL.Query().Get("project"); project != "" {
+		q.Set("project", project)
+	} else if req.Project != "" {
+		q.Set("project", req.Project)
+	}
+	if req.Name != "" {
+		q.Set("name", req.Name)
+	}
+	for _, tag := range req.Tags {
+		q.Add("tag", tag)
+	}
+	if pt.IsCumulative() {
+		q.Set("cumulative", "true")
+	}
+	if req.Window != "" {
+		q.Set("window", req.Window)
+	}
+	r.URL.RawQuery = q.Encode()
+	r.Header.Del("Content-Encoding")
+	r.Body = io.NopCloser(bytes.NewReader(result.Data))
+
+	switch pt {
+	case models.ProfileTypeRuntime:
+		s.handleRuntimeIngest(w, r)
+	case models.ProfileTypeTrace:
+		s.handleTraceIngest(w, r)
+	default:
+		s.handlePprofIngest(w, r)
+	}
+}
+
+// fetchAllowed reports whether u's host is on the fetch allowlist
+func (s *Server) fetchAllowed(u *url.URL) bool {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	hostPort := strings.ToLower(u.Host)
+	ip := net.ParseIP(host)
+	for _, entry := range s.cfg.Server.FetchAllowlist {
+		entry = strings.ToLower(entry)
+		switch {
+		case strings.Contains(entry, "/"):
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return true
+			}
+		case entry == hostPort, entry == host:
+			return true
+		}
+	}
+	return false
+}
+
+// fetchDial connects to a fetch target by one of the addresses its host
+// resolves to that fetchAllowedIP accepts. Checking the address actually
+// dialed keeps a name on the allowlist from being pointed, by DNS, at
+// places it doesn't cover.
+func (s *Server) fetchDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	err = fmt.Errorf("%s resolves to no address allowed by server.fetch_allowlist", host)
+	for _, ip := range ips {
+		if !s.fetchAllowedIP(host, ip.IP) {
+			continue
+		}
+		conn, dialErr := d.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+	}
+	return nil, err
+}
+
+// fetchAllowedIP reports whether ip, which host resolved to, may be
+// fetched from. IP and CIDR entries allow their addresses whatever the
+// host. A name, which fetchAllowed has already matched, reaches any other
+// address but link-local ones, such as a cloud metadata service, and
+// loopback ones unless it is localhost.
+func (s *Server) fetchAllowedIP(host string, ip net.IP) bool {
+	for _, entry := range s.cfg.Server.FetchAllowlist {
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	switch {
+	case net.ParseIP(host) != nil:
+		// An IP literal must be on the list itself
+		return false
+	case ip.IsLoopback():
+		return host == "localhost"
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast(), ip.IsUnspecified():
+		return false
+	}
+	return true
+}
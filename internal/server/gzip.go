@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest API response worth compressing; below it the
+// gzip header and CPU cost outweigh the savings
+const gzipMinSize = 1024
+
+// withGzip compresses /api/ responses for clients that accept gzip.
+// Responses are buffered so small ones can go out as is and compressed ones
+// get a Content-Length; streaming handlers that flush switch to chunked
+// gzip. Raw downloads and anything already encoded pass through untouched.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers a response until it knows whether to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+
+	// Set once the response is committed: passthrough writes straight to
+	// the client, gz streams compressed output
+	passthrough bool
+	gz          *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	if !w.compressible() {
+		w.commitPassthrough()
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.passthrough:
+		return w.ResponseWriter.Write(p)
+	case w.gz != nil:
+		return w.gz.Write(p)
+	default:
+		return w.buf.Write(p)
+	}
+}
+
+// Flush commits a buffered response, compressed if it's big enough, and
+// streams the rest; used by the NDJSON stream
+func (w *gzipResponseWriter) Flush() {
+	if !w.passthrough && w.gz == nil {
+		if w.buf.Len() >= gzipMinSize {
+			w.setGzipHeaders()
+			w.ResponseWriter.WriteHeader(w.status)
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+			w.gz.Write(w.buf.Bytes())
+		} else {
+			w.commitPassthrough()
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends whatever is still buffered once the handler returns
+func (w *gzipResponseWriter) finish() {
+	switch {
+	case w.passthrough:
+		return
+	case w.gz != nil:
+		w.gz.Close()
+		return
+	case !w.wroteHeader:
+		// The handler wrote nothing at all
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	case w.buf.Len() < gzipMinSize:
+		w.commitPassthrough()
+		return
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(w.buf.Bytes())
+	gz.Close()
+
+	w.setGzipHeaders()
+	w.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(compressed.Bytes())
+}
+
+// compressible reports whether the response headers allow compression:
+// not already encoded, not a raw profile download, and a status with a body
+func (w *gzipResponseWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Type") == "application/octet-stream" {
+		return false
+	}
+	return w.status != http.StatusNoContent && w.status != http.StatusNotModified
+}
+
+func (w *gzipResponseWriter) setGzipHeaders() {
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+}
+
+// commitPassthrough sends the headers and any buffered bytes uncompressed
+// and stops buffering
+func (w *gzipResponseWriter) commitPassthrough() {
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
@@ -1,30 +1,41 @@
 package server
 
 import (
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/flaticols/perfkit/internal/config"
+	"github.com/flaticols/perfkit/internal/format"
 	"github.com/flaticols/perfkit/internal/k6"
 	"github.com/flaticols/perfkit/internal/models"
+	"github.com/flaticols/perfkit/internal/msgpack"
 	"github.com/flaticols/perfkit/internal/pprof"
+	"github.com/flaticols/perfkit/internal/runtimestats"
+	"github.com/flaticols/perfkit/internal/storage"
+	"github.com/flaticols/perfkit/internal/trace"
 	"github.com/google/uuid"
 )
 
 func (s *Server) handlePprofIngest(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+	defer r.Body.Close()
+
+	body, ok := s.readBody(w, r)
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
 
-	// Parse pprof profile
-	parsed, err := pprof.Parse(body)
+	// Parse pprof profile; the type param tells allocs apart from heap
+	parsed, err := pprof.ParseAs(body, models.ProfileType(r.URL.Query().Get("type")))
 	if err != nil {
 		http.Error(w, "Failed to parse pprof: "+err.Error(), http.StatusBadRequest)
 		return
@@ -40,35 +51,41 @@ func (s *Server) handlePprofIngest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	project := r.URL.Query().Get("project")
-	if project == "" {
-		project = s.cfg.Project
+	// Catch a mislabeled upload before it breaks comparisons; heap and
+	// allocs look alike, so that mix-up can be forced through
+	mismatch, ambiguous := parsed.TypeMismatch(models.ProfileType(profileType))
+	if mismatch && !(ambiguous && r.URL.Query().Get("force") == "true") {
+		msg := fmt.Sprintf("Profile type mismatch: declared %s, but the profile looks like %s", profileType, parsed.Detected)
+		if ambiguous {
+			msg += "; pass force=true to store it as " + profileType
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+		return
 	}
 
-	session := r.URL.Query().Get("session")
-	source := r.URL.Query().Get("source")
-	name := r.URL.Query().Get("name")
-	if name == "" {
-		name = profileType + "-" + time.Now().Format("20060102-150405")
+	profile := s.newIngest(w, r, body, models.ProfileType(profileType))
+	if profile == nil {
+		return
 	}
 
-	// Build profile record
-	now := time.Now()
-	profile := &models.Profile{
-		ID:          uuid.New().String(),
-		CreatedAt:   now,
-		UpdatedAt:   now,
-		Name:        name,
-		ProfileType: models.ProfileType(profileType),
-		Project:     project,
-		Session:     session,
-		Source:      source,
-		RawData:     body,
-		RawSize:     len(body),
-		ProfileTime: &now,
-		DurationNS:  parsed.DurationNS,
+	// Metrics come from the full profile; only the stored copy is decimated
+	if pct := s.cfg.Server.Decimate[profileType]; pct > 0 && !parsed.Empty {
+		// A text goroutine dump has fewer samples than goroutines once
+		// decoded, so only Decimate knows whether it dropped any
+		data, kept, dropped, err := pprof.Decimate(body, pct)
+		if err != nil {
+			log.Printf("Failed to decimate %s profile, storing it whole: %v", profileType, err)
+		} else if dropped {
+			profile.RawData = data
+			profile.RawSize = len(data)
+			n := int64(kept)
+			profile.StoredSamples = &n
+		}
 	}
 
+	profile.DurationNS = parsed.DurationNS
+	profile.SampleTypes = parsed.SampleTypes
+
 	// Set quick-access fields
 	if parsed.TotalSamples > 0 {
 		profile.TotalSamples = &parsed.TotalSamples
@@ -77,7 +94,8 @@ func (s *Server) handlePprofIngest(w http.ResponseWriter, r *http.Request) {
 		profile.TotalValue = &parsed.TotalValue
 	}
 
-	// Marshal metrics
+	// Marshal metrics, with cumulative counters' rate since the last capture
+	s.setEventRate(r.Context(), profile, parsed.Metrics)
 	if parsed.Metrics != nil {
 		metricsJSON, err := json.Marshal(parsed.Metrics)
 		if err == nil {
@@ -85,26 +103,160 @@ func (s *Server) handlePprofIngest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Handle tags
-	tags := r.URL.Query()["tag"]
-	profile.Tags = append(s.cfg.DefaultTags, tags...)
-
 	// Handle cumulative flag
 	if r.URL.Query().Get("cumulative") == "true" {
 		profile.IsCumulative = true
 	}
 
-	if err := s.store.SaveProfile(r.Context(), profile); err != nil {
-		log.Printf("Failed to save profile: %v", err)
+	// Flag empty profiles so an all-zero result isn't mistaken for a failed capture
+	var warning string
+	if parsed.Empty {
+		profile.Tags = append(profile.Tags, models.TagEmpty)
+		warning = emptyProfileWarning(profile.ProfileType)
+	}
+	if mismatch {
+		if warning != "" {
+			warning += "; "
+		}
+		warning += fmt.Sprintf("Stored as %s, though the profile looks like %s", profileType, parsed.Detected)
+	}
+
+	resp := map[string]any{}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	if mismatch {
+		resp["type_mismatch"] = true
+	}
+	s.saveIngest(w, r, profile, "Profile ingested successfully", resp)
+}
+
+// newIngest starts the stored profile for an upload of type pt with what
+// every ingest route shares: provenance, window, session and project, ID,
+// name, times, host, source and tags. It writes the response and returns
+// nil when the ingest is refused, or is a content_id re-import of a stored
+// profile.
+func (s *Server) newIngest(w http.ResponseWriter, r *http.Request, body []byte, pt models.ProfileType) *models.Profile {
+	provenance, err := provenanceParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	// k6 runs and traces have a duration of their own
+	var window int64
+	if pt != models.ProfileTypeK6 && pt != models.ProfileTypeTrace {
+		if window, err = windowParam(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	session := s.sessionFor(r)
+	project, err := s.resolveProject(r, session)
+	if err != nil {
+		if errors.Is(err, storage.ErrProjectMismatch) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return nil
+		}
+		log.Printf("Failed to resolve project: %v", err)
+		http.Error(w, "Failed to resolve project", http.StatusInternalServerError)
+		return nil
+	}
+
+	// Re-importing the same data is a no-op with content_id=true
+	id := profileID(r, body, pt, project, session)
+	if s.skipDuplicate(w, r, id) {
+		return nil
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = string(pt) + "-" + time.Now().Format("20060102-150405")
+	}
+	name, err = s.uniqueName(r, session, name)
+	if err != nil {
+		if errors.Is(err, errNameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return nil
+		}
+		log.Printf("Failed to check profile name: %v", err)
+		http.Error(w, "Failed to check profile name", http.StatusInternalServerError)
+		return nil
+	}
+
+	now := time.Now()
+	profileTime := timeParam(r, "profile_time", now)
+	return &models.Profile{
+		ID:          id,
+		CreatedAt:   timeParam(r, "created_at", now),
+		UpdatedAt:   now,
+		Name:        name,
+		ProfileType: pt,
+		Project:     project,
+		Session:     session,
+		Host:        s.hostFor(r),
+		Source:      r.URL.Query().Get("source"),
+		Tags:        s.ingestTags(r),
+		RawData:     body,
+		RawSize:     len(body),
+		ProfileTime: &profileTime,
+		WindowNS:    window,
+		Provenance:  provenance,
+	}
+}
+
+// saveIngest flags an ingested profile if it's an anomaly, stores it and
+// runs the ingest hooks, then answers with its ID, message and the
+// route's extra response fields
+func (s *Server) saveIngest(w http.ResponseWriter, r *http.Request, profile *models.Profile, message string, resp map[string]any) {
+	anomaly := s.flagAnomaly(r.Context(), profile)
+
+	if err := s.store.SaveProfileCapped(r.Context(), profile, s.sessionCap()); err != nil {
+		// Not a rate limit: retrying won't help until profiles are deleted
+		if errors.Is(err, storage.ErrSessionFull) {
+			w.Header().Set("X-Perfkit-Session-Full", "true")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		// A concurrent ingest started the session under another project
+		if errors.Is(err, storage.ErrProjectMismatch) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		// A concurrent content_id re-import stored it after skipDuplicate
+		if errors.Is(err, storage.ErrExists) {
+			writeDuplicate(w, profile.ID)
+			return
+		}
+		log.Printf("Failed to save %s profile: %v", profile.ProfileType, err)
 		http.Error(w, "Failed to save profile", http.StatusInternalServerError)
 		return
 	}
+	s.runIngestHooks(profile)
+
+	resp["id"] = profile.ID
+	resp["message"] = message
+	if anomaly != "" {
+		resp["anomaly"] = true
+		resp["anomaly_reason"] = anomaly
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"id":      profile.ID,
-		"message": "Profile ingested successfully",
-	})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// emptyProfileWarning explains the usual cause of a profile without samples
+func emptyProfileWarning(pt models.ProfileType) string {
+	switch pt {
+	case models.ProfileTypeBlock:
+		return "Profile has no samples: block profiling may be disabled in the target (runtime.SetBlockProfileRate)"
+	case models.ProfileTypeMutex:
+		return "Profile has no samples: mutex profiling may be disabled in the target (runtime.SetMutexProfileFraction)"
+	case models.ProfileTypeCPU:
+		return "Profile has no samples: the target may have been idle during the capture"
+	default:
+		return "Profile has no samples"
+	}
 }
 
 func (s *Server) handleListProfiles(w http.ResponseWriter, r *http.Request) {
@@ -127,17 +279,107 @@ func (s *Server) handleListProfiles(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid profile type: "+profileType, http.StatusBadRequest)
 		return
 	}
-	project := r.URL.Query().Get("project")
+	filter := storage.ProfileFilter{
+		Session:     r.URL.Query().Get("session"),
+		ProfileType: profileType,
+		Project:     r.URL.Query().Get("project"),
+		Host:        r.URL.Query().Get("host"),
+		Starred:     r.URL.Query().Get("starred") == "true",
+	}
+	labels, err := labelFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Labels = labels
 
-	profiles, err := s.store.ListProfiles(r.Context(), limit, offset, profileType, project)
+	profiles, err := s.store.ListProfiles(r.Context(), limit, offset, filter)
 	if err != nil {
 		log.Printf("Failed to list profiles: %v", err)
 		http.Error(w, "Failed to list profiles", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(profiles)
+	if r.URL.Query().Get("include_raw") == "true" {
+		if err := s.store.InlineRawData(r.Context(), profiles, s.cfg.Server.MaxInlineSize); err != nil {
+			log.Printf("Failed to load raw data: %v", err)
+			http.Error(w, "Failed to list profiles", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeResponse(w, r, profiles)
+}
+
+// streamFlushEvery is how many NDJSON lines are written between flushes
+const streamFlushEvery = 100
+
+func (s *Server) handleStreamProfiles(w http.ResponseWriter, r *http.Request) {
+	profileType := r.URL.Query().Get("type")
+	if profileType != "" && !models.ProfileType(profileType).IsValid() {
+		http.Error(w, "Invalid profile type: "+profileType, http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	filter := storage.ProfileFilter{
+		Session:     r.URL.Query().Get("session"),
+		ProfileType: profileType,
+		Project:     r.URL.Query().Get("project"),
+		Host:        r.URL.Query().Get("host"),
+		Starred:     r.URL.Query().Get("starred") == "true",
+	}
+	labels, err := labelFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Labels = labels
+
+	// http.Error replaces this if the stream fails before the first row
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var written int
+
+	err = s.store.StreamProfiles(r.Context(), filter, r.URL.Query().Get("after"), limit, func(p *models.Profile, cursor string) error {
+		line := struct {
+			*models.Profile
+			Cursor string `json:"cursor"`
+		}{p, cursor}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+
+		written++
+		if written%streamFlushEvery == 0 {
+			// Keep the write deadline rolling so long scans aren't cut off
+			if timeout := s.cfg.Server.WriteTimeout; timeout > 0 {
+				rc.SetWriteDeadline(time.Now().Add(timeout))
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if written > 0 {
+			// Headers are sent; all we can do is cut the stream short
+			log.Printf("Profile stream aborted after %d rows: %v", written, err)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to stream profiles: %v", err)
+		http.Error(w, "Failed to stream profiles", http.StatusInternalServerError)
+	}
 }
 
 func (s *Server) handleGetProfile(w http.ResponseWriter, r *http.Request) {
@@ -147,7 +389,7 @@ func (s *Server) handleGetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	profile, err := s.store.GetProfile(r.Context(), id)
+	profile, err := s.getProfile(r, id)
 	if err != nil {
 		log.Printf("Failed to get profile: %v", err)
 		http.Error(w, "Profile not found", http.StatusNotFound)
@@ -162,138 +404,1504 @@ func (s *Server) handleGetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(profile)
+	writeResponse(w, r, withUnits(r, profile))
 }
 
-func (s *Server) handleCompareProfiles(w http.ResponseWriter, r *http.Request) {
-	idsParam := r.URL.Query().Get("ids")
-	if idsParam == "" {
-		http.Error(w, "Missing ids parameter", http.StatusBadRequest)
+// handleCreateDiff stores target minus base as a new profile of the same
+// type, so a comparison can be bookmarked and opened like any profile.
+func (s *Server) handleCreateDiff(w http.ResponseWriter, r *http.Request) {
+	baseID := r.URL.Query().Get("base")
+	targetID := r.URL.Query().Get("target")
+	if baseID == "" || targetID == "" {
+		http.Error(w, "Missing base or target parameter", http.StatusBadRequest)
 		return
 	}
 
-	ids := strings.Split(idsParam, ",")
-	if len(ids) < 2 {
-		http.Error(w, "At least 2 profile IDs required for comparison", http.StatusBadRequest)
+	found, err := s.store.GetProfilesByIDs(r.Context(), []string{baseID, targetID})
+	if err != nil {
+		log.Printf("Failed to get profiles: %v", err)
+		http.Error(w, "Failed to get profiles", http.StatusInternalServerError)
 		return
 	}
 
-	profiles := make([]*models.Profile, 0, len(ids))
-	var expectedType models.ProfileType
-
-	for i, id := range ids {
-		id = strings.TrimSpace(id)
-		if id == "" {
-			continue
-		}
-
-		profile, err := s.store.GetProfile(r.Context(), id)
-		if err != nil {
-			log.Printf("Failed to get profile %s: %v", id, err)
+	project := r.URL.Query().Get("project")
+	for _, id := range []string{baseID, targetID} {
+		if p, ok := found[id]; !ok || (project != "" && p.Project != project) {
 			http.Error(w, "Profile not found: "+id, http.StatusNotFound)
 			return
 		}
-
-		// Validate same type
-		if i == 0 {
-			expectedType = profile.ProfileType
-		} else if profile.ProfileType != expectedType {
-			http.Error(w, "All profiles must be of the same type", http.StatusBadRequest)
-			return
-		}
-
-		// Don't include raw data in comparison response
-		profile.RawData = nil
-		profiles = append(profiles, profile)
 	}
+	base, target := found[baseID], found[targetID]
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(profiles)
-}
-
-func (s *Server) handleK6Ingest(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+	if base.ProfileType != target.ProfileType {
+		http.Error(w, "All profiles must be of the same type", http.StatusBadRequest)
 		return
 	}
-
-	// Parse k6 summary JSON
-	parsed, err := k6.Parse(body)
-	if err != nil {
-		http.Error(w, "Failed to parse k6 summary: "+err.Error(), http.StatusBadRequest)
+	if !base.ProfileType.IsPprof() {
+		http.Error(w, "Diff profiles are only available for pprof profiles", http.StatusBadRequest)
 		return
 	}
 
-	// Extract metadata from query params
-	project := r.URL.Query().Get("project")
-	if project == "" {
-		project = s.cfg.Project
+	data, err := pprof.DiffProfile(base.RawData, target.RawData, r.URL.Query().Get("normalize") == "true")
+	if err != nil {
+		http.Error(w, "Failed to diff profiles: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	session := r.URL.Query().Get("session")
-	source := r.URL.Query().Get("source")
-	name := r.URL.Query().Get("name")
-	if name == "" {
-		name = "k6-" + time.Now().Format("20060102-150405")
+	parsed, err := pprof.ParseAs(data, base.ProfileType)
+	if err != nil {
+		log.Printf("Failed to parse diff profile: %v", err)
+		http.Error(w, "Failed to parse diff profile", http.StatusInternalServerError)
+		return
 	}
 
-	// Build profile record
+	// No session: a diff isn't a capture, and would otherwise count as the
+	// session's latest profile of its type
 	now := time.Now()
 	profile := &models.Profile{
 		ID:          uuid.New().String(),
 		CreatedAt:   now,
 		UpdatedAt:   now,
-		Name:        name,
-		ProfileType: models.ProfileTypeK6,
-		Project:     project,
-		Session:     session,
-		Source:      source,
-		RawData:     body,
-		RawSize:     len(body),
+		Name:        "diff-" + base.Name + "-" + target.Name,
+		ProfileType: base.ProfileType,
+		Project:     target.Project,
+		Source:      "diff",
+		Tags:        []string{models.TagDiff, "diff_base=" + base.ID, "diff_target=" + target.ID},
+		ParentIDs:   models.IDList{base.ID, target.ID},
+		RawData:     data,
+		RawSize:     len(data),
 		ProfileTime: &now,
-		DurationNS:  parsed.DurationMS * 1_000_000, // Convert ms to ns
+		DurationNS:  parsed.DurationNS,
+		SampleTypes: parsed.SampleTypes,
+	}
+	if parsed.TotalSamples > 0 {
+		profile.TotalSamples = &parsed.TotalSamples
+	}
+	// Diff totals are negative when the target improved
+	if parsed.TotalValue != 0 {
+		profile.TotalValue = &parsed.TotalValue
 	}
-
-	// Set k6 quick-access fields
 	if parsed.Metrics != nil {
-		if parsed.Metrics.P95 > 0 {
-			profile.K6P95 = &parsed.Metrics.P95
-		}
-		if parsed.Metrics.P99 > 0 {
-			profile.K6P99 = &parsed.Metrics.P99
-		}
-		if parsed.Metrics.RPS > 0 {
-			profile.K6RPS = &parsed.Metrics.RPS
-		}
-		profile.K6ErrorRate = &parsed.Metrics.ErrorRate
-		if parsed.DurationMS > 0 {
-			profile.K6DurationMS = &parsed.DurationMS
-		}
-
-		// Marshal metrics
-		metricsJSON, err := json.Marshal(parsed.Metrics)
-		if err == nil {
+		if metricsJSON, err := json.Marshal(parsed.Metrics); err == nil {
 			profile.Metrics = models.NullableJSON(metricsJSON)
 		}
 	}
 
-	// Handle tags
-	tags := r.URL.Query()["tag"]
-	profile.Tags = append(s.cfg.DefaultTags, tags...)
-
 	if err := s.store.SaveProfile(r.Context(), profile); err != nil {
-		log.Printf("Failed to save k6 profile: %v", err)
-		http.Error(w, "Failed to save profile", http.StatusInternalServerError)
+		log.Printf("Failed to save diff profile: %v", err)
+		http.Error(w, "Failed to save diff profile", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"id":      profile.ID,
-		"message": "K6 profile ingested successfully",
+		"message": "Diff profile created",
+	})
+}
+
+// handleDerivedProfiles lists the profiles computed from a profile, such as
+// diffs it's the base or target of, oldest first
+func (s *Server) handleDerivedProfiles(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing profile ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.getProfile(r, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get profile: %v", err)
+		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		return
+	}
+
+	derived, err := s.store.FindProfiles(r.Context(), storage.ProfileFilter{
+		ParentID: id,
+		Project:  r.URL.Query().Get("project"),
 	})
+	if err != nil {
+		log.Printf("Failed to find derived profiles: %v", err)
+		http.Error(w, "Failed to find derived profiles", http.StatusInternalServerError)
+		return
+	}
+	if derived == nil {
+		derived = []*models.Profile{}
+	}
+
+	writeResponse(w, r, derived)
+}
+
+func (s *Server) handleDeleteProfile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	// Look the profile up first so project scoping applies
+	if _, err := s.getProfile(r, id); err != nil {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.store.DeleteProfile(r.Context(), id); err != nil {
+		log.Printf("Failed to delete profile %s: %v", id, err)
+		http.Error(w, "Failed to delete profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStarProfile returns a handler that stars or unstars a profile, a
+// bookmark to find it again with starred=true
+func (s *Server) handleStarProfile(starred bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		// Look the profile up first so project scoping applies
+		if _, err := s.getProfile(r, id); err != nil {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+
+		if err := s.store.SetStarred(r.Context(), id, starred); err != nil {
+			log.Printf("Failed to star profile %s: %v", id, err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDeleteProfiles deletes every profile matching the query's filters,
+// e.g. ?session=x&type=heap&before=2026-01-01T00:00:00Z. Without
+// confirm=true nothing is deleted and the response only counts the matches.
+func (s *Server) handleDeleteProfiles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	profileType := q.Get("type")
+	if profileType != "" && !models.ProfileType(profileType).IsValid() {
+		http.Error(w, "Invalid profile type: "+profileType, http.StatusBadRequest)
+		return
+	}
+	filter := storage.ProfileFilter{
+		Session:     q.Get("session"),
+		ProfileType: profileType,
+		Project:     q.Get("project"),
+		Host:        q.Get("host"),
+		Tag:         q.Get("tag"),
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "Invalid before time, want RFC3339: "+v, http.StatusBadRequest)
+			return
+		}
+		filter.Before = before
+	}
+	labels, err := labelFilters(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Labels = labels
+
+	if filter.IsEmpty() {
+		http.Error(w, "Refusing to delete every profile: pass at least one of session, type, project, host, tag, label.<key> or before", http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]any{}
+	if q.Get("confirm") != "true" {
+		matched, err := s.store.FindProfiles(r.Context(), filter)
+		if err != nil {
+			log.Printf("Failed to find profiles: %v", err)
+			http.Error(w, "Failed to find profiles", http.StatusInternalServerError)
+			return
+		}
+		resp["matched"] = len(matched)
+		resp["deleted"] = 0
+		resp["message"] = "Nothing deleted; pass confirm=true to delete these profiles"
+	} else {
+		deleted, err := s.store.DeleteProfilesWhere(r.Context(), filter)
+		if err != nil {
+			log.Printf("Failed to delete profiles: %v", err)
+			http.Error(w, "Failed to delete profiles", http.StatusInternalServerError)
+			return
+		}
+		resp["matched"] = deleted
+		resp["deleted"] = deleted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleProfileTop(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing profile ID", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := s.getProfile(r, id)
+	if err != nil {
+		log.Printf("Failed to get profile: %v", err)
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if !profile.ProfileType.IsPprof() {
+		http.Error(w, "Top is only available for pprof profiles", http.StatusBadRequest)
+		return
+	}
+
+	opts := pprof.TopOptions{
+		ValueType: r.URL.Query().Get("valueType"),
+		Unit:      r.URL.Query().Get("unit"),
+		Cum:       r.URL.Query().Get("cum") == "true",
+	}
+	if n := r.URL.Query().Get("n"); n != "" {
+		if v, err := strconv.Atoi(n); err == nil && v > 0 {
+			opts.N = v
+		}
+	}
+
+	report, err := pprof.Top(profile.RawData, opts)
+	if err != nil {
+		http.Error(w, "Failed to build top report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	report.WriteText(w)
+}
+
+// handleProfileReport streams a pprof-style text report of a profile:
+// tree, peek or traces, the report modes beyond top
+func (s *Server) handleProfileReport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing profile ID", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := s.getProfile(r, id)
+	if err != nil {
+		log.Printf("Failed to get profile: %v", err)
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if !profile.ProfileType.IsPprof() {
+		http.Error(w, "Reports are only available for pprof profiles", http.StatusBadRequest)
+		return
+	}
+
+	opts := pprof.ReportOptions{
+		Kind:      r.URL.Query().Get("type"),
+		ValueType: r.URL.Query().Get("valueType"),
+		Unit:      r.URL.Query().Get("unit"),
+		Focus:     r.URL.Query().Get("focus"),
+	}
+	if opts.Kind == "" {
+		opts.Kind = pprof.ReportTree
+	}
+	if n := r.URL.Query().Get("n"); n != "" {
+		if v, err := strconv.Atoi(n); err == nil && v > 0 {
+			opts.N = v
+		}
+	}
+
+	report, err := pprof.NewReport(profile.RawData, opts)
+	if err != nil {
+		http.Error(w, "Failed to build report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	report.WriteText(w)
+}
+
+// handleProfileInsights runs the pprof rule set over a profile and returns
+// its findings in plain language, with the functions behind each
+func (s *Server) handleProfileInsights(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing profile ID", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := s.getProfile(r, id)
+	if err != nil {
+		log.Printf("Failed to get profile: %v", err)
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if !profile.ProfileType.IsPprof() {
+		http.Error(w, "Insights are only available for pprof profiles", http.StatusBadRequest)
+		return
+	}
+
+	insights, err := pprof.Insights(profile.RawData, profile.ProfileType)
+	if err != nil {
+		http.Error(w, "Failed to analyze profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":           profile.ID,
+		"profile_type": profile.ProfileType,
+		"insights":     insights,
+	})
+}
+
+func (s *Server) handleCompareProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, ok := s.compareProfiles(w, r)
+	if !ok {
+		return
+	}
+	writeResponse(w, r, withUnits(r, profiles))
+}
+
+// compareProfiles loads and checks the profiles named by the ids param for
+// a comparison, in request order and without raw data. Warnings go into
+// X-Perfkit-Warning headers; on failure it writes the error and returns
+// false.
+func (s *Server) compareProfiles(w http.ResponseWriter, r *http.Request) ([]*models.Profile, bool) {
+	idsParam := r.URL.Query().Get("ids")
+	if idsParam == "" {
+		http.Error(w, "Missing ids parameter", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ids := strings.Split(idsParam, ",")
+	if len(ids) < 2 {
+		http.Error(w, "At least 2 profile IDs required for comparison", http.StatusBadRequest)
+		return nil, false
+	}
+
+	for i := range ids {
+		ids[i] = strings.TrimSpace(ids[i])
+	}
+
+	found, err := s.store.GetProfilesByIDs(r.Context(), ids)
+	if err != nil {
+		log.Printf("Failed to get profiles: %v", err)
+		http.Error(w, "Failed to get profiles", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	project := r.URL.Query().Get("project")
+	allowCrossType := r.URL.Query().Get("allow_cross_type") == "true"
+	profiles := make([]*models.Profile, 0, len(ids))
+	var crossType bool
+
+	// Walk the IDs in request order so the response preserves it
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+
+		profile, ok := found[id]
+		if !ok || (project != "" && profile.Project != project) {
+			http.Error(w, "Profile not found: "+id, http.StatusNotFound)
+			return nil, false
+		}
+
+		// Validate same type
+		if len(profiles) > 0 && profile.ProfileType != profiles[0].ProfileType {
+			if !allowCrossType {
+				http.Error(w, "All profiles must be of the same type (pass allow_cross_type=true to compare their common sample types)", http.StatusBadRequest)
+				return nil, false
+			}
+			crossType = true
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	if crossType {
+		if err := checkCrossType(profiles); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		var types []string
+		for _, p := range profiles {
+			if !slices.Contains(types, string(p.ProfileType)) {
+				types = append(types, string(p.ProfileType))
+			}
+		}
+		kept := models.KeepCommonMetrics(profiles)
+		w.Header().Set("X-Perfkit-Cross-Type", strings.Join(types, ","))
+		w.Header().Add("X-Perfkit-Warning", fmt.Sprintf("Cross-type comparison of %s profiles: only their common metrics are compared (%s)",
+			strings.Join(types, " and "), strings.Join(kept, ", ")))
+	}
+
+	// Don't include raw data in comparison response
+	for _, p := range profiles {
+		p.RawData = nil
+	}
+
+	// Cumulative profiles are compared pairwise in request order. Totals of
+	// different types measure different things, so cross-type pairs are
+	// skipped.
+	for i := 1; i < len(profiles); i++ {
+		if profiles[i-1].ProfileType != profiles[i].ProfileType {
+			continue
+		}
+		warning, err := models.CheckSameRun(profiles[i-1], profiles[i])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return nil, false
+		}
+		if warning != "" {
+			w.Header().Add("X-Perfkit-Warning", warning)
+		}
+	}
+	for i := 1; i < len(profiles); i++ {
+		if _, warning := models.CheckAge(profiles[i-1], profiles[i], s.cfg.Compare.MaxGap); warning != "" {
+			w.Header().Add("X-Perfkit-Warning", warning)
+		}
+	}
+
+	return profiles, true
+}
+
+// checkCrossType checks profiles of different types can be compared: all
+// pprof, with at least one sample type (e.g. alloc_space) in common
+func checkCrossType(profiles []*models.Profile) error {
+	raws := make([][]byte, len(profiles))
+	for i, p := range profiles {
+		if !p.ProfileType.IsPprof() {
+			return fmt.Errorf("Cross-type comparison is only available for pprof profiles, not %s", p.ProfileType)
+		}
+		raws[i] = p.RawData
+	}
+
+	common, err := pprof.CommonValueTypes(raws...)
+	if err != nil {
+		return fmt.Errorf("Failed to parse profiles: %w", err)
+	}
+	if len(common) == 0 {
+		return fmt.Errorf("Profiles have no sample type in common")
+	}
+	return nil
+}
+
+// comparePair loads the base and target pprof profiles named by a
+// comparison's query, with a warning from the restart check. It writes the
+// error response and returns false when they can't be compared.
+func (s *Server) comparePair(w http.ResponseWriter, r *http.Request) (base, target *models.Profile, runWarning string, ok bool) {
+	baseID := r.URL.Query().Get("base")
+	targetID := r.URL.Query().Get("target")
+	if baseID == "" || targetID == "" {
+		http.Error(w, "Missing base or target parameter", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	// Read both sides together so they come from the same snapshot
+	found, err := s.store.GetProfilesByIDs(r.Context(), []string{baseID, targetID})
+	if err != nil {
+		log.Printf("Failed to get profiles: %v", err)
+		http.Error(w, "Failed to get profiles", http.StatusInternalServerError)
+		return nil, nil, "", false
+	}
+
+	project := r.URL.Query().Get("project")
+	for _, id := range []string{baseID, targetID} {
+		if p, ok := found[id]; !ok || (project != "" && p.Project != project) {
+			http.Error(w, "Profile not found: "+id, http.StatusNotFound)
+			return nil, nil, "", false
+		}
+	}
+	base, target = found[baseID], found[targetID]
+
+	if base.ProfileType != target.ProfileType {
+		http.Error(w, "All profiles must be of the same type", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+	if !base.ProfileType.IsPprof() {
+		http.Error(w, "Function comparison is only available for pprof profiles", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	runWarning, err = models.CheckSameRun(base, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, nil, "", false
+	}
+	return base, target, runWarning, true
+}
+
+func (s *Server) handleCompareFunctions(w http.ResponseWriter, r *http.Request) {
+	base, target, runWarning, ok := s.comparePair(w, r)
+	if !ok {
+		return
+	}
+
+	opts, tolerance, err := s.diffOptions(r, base.ProfileType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := pprof.DiffProfiles(base.RawData, target.RawData, opts)
+	if err != nil {
+		http.Error(w, "Failed to compare profiles: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if runWarning != "" {
+		diff.Warnings = append(diff.Warnings, runWarning)
+	}
+	var ageWarning string
+	if diff.Gap, ageWarning = models.CheckAge(base, target, s.cfg.Compare.MaxGap); ageWarning != "" {
+		diff.Warnings = append(diff.Warnings, ageWarning)
+	}
+	diff.Verdict = models.Judge(base.ProfileType, base, target, tolerance)
+
+	writeDiff(w, r, diff, "compare-"+base.ID+"-"+target.ID+".csv")
+}
+
+// defaultFlameMinPercent is the share of both totals below which a merged
+// flame graph drops frames without a min_percent param, much as the
+// function comparison only returns a page of the largest changes
+const defaultFlameMinPercent = 0.1
+
+// handleCompareFlameGraph returns the call trees of two profiles merged
+// into one, for rendering a differential flame graph
+func (s *Server) handleCompareFlameGraph(w http.ResponseWriter, r *http.Request) {
+	base, target, runWarning, ok := s.comparePair(w, r)
+	if !ok {
+		return
+	}
+
+	opts := pprof.FlameOptions{
+		ValueType:  r.URL.Query().Get("valueType"),
+		Unit:       r.URL.Query().Get("unit"),
+		Normalize:  r.URL.Query().Get("normalize") == "true",
+		MinPercent: defaultFlameMinPercent,
+	}
+	if v := r.URL.Query().Get("min_percent"); v != "" {
+		var err error
+		if opts.MinPercent, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, "Invalid min_percent: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+
+	flame, err := pprof.DiffFlameGraph(base.RawData, target.RawData, opts)
+	if err != nil {
+		http.Error(w, "Failed to compare profiles: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if runWarning != "" {
+		flame.Warnings = append(flame.Warnings, runWarning)
+	}
+	if _, ageWarning := models.CheckAge(base, target, s.cfg.Compare.MaxGap); ageWarning != "" {
+		flame.Warnings = append(flame.Warnings, ageWarning)
+	}
+	for _, warning := range flame.Warnings {
+		w.Header().Add("X-Perfkit-Warning", warning)
+	}
+
+	ext := ".json"
+	if wantsMsgpack(r) {
+		ext = ".msgpack"
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=flamegraph-"+base.ID+"-"+target.ID+ext)
+	writeResponse(w, r, flame)
+}
+
+// handleCompareUpload compares a stored profile, as the base, with a
+// profile in the request body, such as a fresh local capture, without
+// storing the upload
+func (s *Server) handleCompareUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	base, err := s.getProfile(r, id)
+	if err != nil {
+		http.Error(w, "Profile not found: "+id, http.StatusNotFound)
+		return
+	}
+	if !base.ProfileType.IsPprof() {
+		http.Error(w, "Function comparison is only available for pprof profiles", http.StatusBadRequest)
+		return
+	}
+
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	defer r.Body.Close()
+
+	parsed, err := pprof.ParseAs(body, base.ProfileType)
+	if err != nil {
+		http.Error(w, "Failed to parse pprof: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	mismatch, ambiguous := parsed.TypeMismatch(base.ProfileType)
+	if mismatch && !(ambiguous && r.URL.Query().Get("force") == "true") {
+		msg := fmt.Sprintf("Profile type mismatch: %s is a %s profile, but the upload looks like %s", base.ID, base.ProfileType, parsed.Detected)
+		if ambiguous {
+			msg += "; pass force=true to compare it as " + string(base.ProfileType)
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// The upload only lives for this request; tag params let it carry a
+	// run_id for the restart check
+	upload := &models.Profile{
+		ID:          "upload",
+		CreatedAt:   time.Now(),
+		ProfileType: base.ProfileType,
+		Tags:        r.URL.Query()["tag"],
+		RawData:     body,
+	}
+	if parsed.TotalValue != 0 {
+		upload.TotalValue = &parsed.TotalValue
+	}
+	if parsed.Metrics != nil {
+		if metricsJSON, err := json.Marshal(parsed.Metrics); err == nil {
+			upload.Metrics = models.NullableJSON(metricsJSON)
+		}
+	}
+
+	runWarning, err := models.CheckSameRun(base, upload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	opts, tolerance, err := s.diffOptions(r, base.ProfileType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := pprof.DiffProfiles(base.RawData, body, opts)
+	if err != nil {
+		http.Error(w, "Failed to compare profiles: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mismatch {
+		diff.Warnings = append(diff.Warnings, fmt.Sprintf("Compared as %s, though the upload looks like %s", base.ProfileType, parsed.Detected))
+	}
+	if runWarning != "" {
+		diff.Warnings = append(diff.Warnings, runWarning)
+	}
+	var ageWarning string
+	if diff.Gap, ageWarning = models.CheckAge(base, upload, s.cfg.Compare.MaxGap); ageWarning != "" {
+		diff.Warnings = append(diff.Warnings, ageWarning)
+	}
+	diff.Verdict = models.Judge(base.ProfileType, base, upload, tolerance)
+
+	writeDiff(w, r, diff, "compare-"+base.ID+"-upload.csv")
+}
+
+// diffOptions reads a function comparison's options from the query, with
+// the noise tolerance for its verdict
+func (s *Server) diffOptions(r *http.Request, pt models.ProfileType) (pprof.DiffOptions, float64, error) {
+	opts := pprof.DiffOptions{
+		ValueType: r.URL.Query().Get("valueType"),
+		Unit:      r.URL.Query().Get("unit"),
+		GroupBy:   r.URL.Query().Get("groupBy"),
+	}
+	var err error
+	if v := r.URL.Query().Get("min_percent"); v != "" {
+		if opts.MinPercent, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, 0, fmt.Errorf("Invalid min_percent: %s", v)
+		}
+	}
+	if v := r.URL.Query().Get("min_delta"); v != "" {
+		if opts.MinDelta, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return opts, 0, fmt.Errorf("Invalid min_delta: %s", v)
+		}
+	}
+	tolerance := s.cfg.Compare.Tolerance(string(pt))
+	if v := r.URL.Query().Get("tolerance"); v != "" {
+		if tolerance, err = strconv.ParseFloat(v, 64); err != nil || tolerance < 0 {
+			return opts, 0, fmt.Errorf("Invalid tolerance: %s", v)
+		}
+	}
+	return opts, tolerance, nil
+}
+
+// defaultDiffLimit is how many functions a JSON function comparison
+// returns without a limit param
+const defaultDiffLimit = 50
+
+// writeDiff writes a function comparison as JSON, a page of its functions
+// at a time, or as CSV with format=csv, which has every function
+func writeDiff(w http.ResponseWriter, r *http.Request, diff *pprof.Diff, filename string) {
+	// Headers carry the warnings for CSV downloads too
+	for _, warning := range diff.Warnings {
+		w.Header().Add("X-Perfkit-Warning", warning)
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+		if err := diff.WriteCSV(w); err != nil {
+			log.Printf("Failed to write CSV: %v", err)
+		}
+		return
+	}
+
+	limit := defaultDiffLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	offset := 0
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if n, err := strconv.Atoi(o); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+	diff.Page(offset, limit)
+
+	writeResponse(w, r, diff)
+}
+
+func (s *Server) handleWorstProfiles(w http.ResponseWriter, r *http.Request) {
+	metric := r.URL.Query().Get("metric")
+	if !slices.Contains(storage.WorstMetrics, metric) {
+		http.Error(w, "Invalid metric: must be one of "+strings.Join(storage.WorstMetrics, ", "), http.StatusBadRequest)
+		return
+	}
+
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	var groupBy string
+	switch {
+	case r.URL.Query().Get("perProject") == "true":
+		groupBy = storage.GroupByProject
+	case r.URL.Query().Get("perHost") == "true":
+		groupBy = storage.GroupByHost
+	}
+
+	ranked, err := s.store.WorstProfiles(r.Context(), metric, r.URL.Query().Get("project"), limit, groupBy)
+	if err != nil {
+		log.Printf("Failed to rank profiles: %v", err)
+		http.Error(w, "Failed to rank profiles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ranked)
+}
+
+// handleActivity returns the newest ingest and delete events, newest first
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 {
+			limit = min(n, storage.MaxActivityEvents)
+		}
+	}
+
+	events, err := s.store.RecentActivity(r.Context(), r.URL.Query().Get("project"), limit)
+	if err != nil {
+		log.Printf("Failed to get activity: %v", err)
+		http.Error(w, "Failed to get activity", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// handleRun returns every profile from a load test, its k6 summary and the
+// server-side profiles captured during it, oldest first
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	runID := r.PathValue("run_id")
+	profiles, err := s.store.FindProfiles(r.Context(), storage.ProfileFilter{
+		Project: r.URL.Query().Get("project"),
+		Tag:     models.LabelLoadRun + "=" + runID,
+	})
+	if err != nil {
+		log.Printf("Failed to list run profiles: %v", err)
+		http.Error(w, "Failed to list run profiles", http.StatusInternalServerError)
+		return
+	}
+	if len(profiles) == 0 {
+		http.Error(w, "Run not found: "+runID, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"run_id":   runID,
+		"profiles": profiles,
+	})
+}
+
+func (s *Server) handleSessionHealth(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "Missing session name", http.StatusBadRequest)
+		return
+	}
+
+	health, err := s.store.SessionHealth(r.Context(), name)
+	if err != nil {
+		log.Printf("Failed to get session health: %v", err)
+		http.Error(w, "Failed to get session health", http.StatusInternalServerError)
+		return
+	}
+	if health.ProfileCount == 0 {
+		http.Error(w, "Session not found: "+name, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
+
+// handleSessionSummary rolls up a session's metrics per profile type
+func (s *Server) handleSessionSummary(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "Missing session name", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := s.store.SessionSummary(r.Context(), name)
+	if err != nil {
+		log.Printf("Failed to summarize session: %v", err)
+		http.Error(w, "Failed to summarize session", http.StatusInternalServerError)
+		return
+	}
+	if summary.ProfileCount == 0 {
+		http.Error(w, "Session not found: "+name, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// handleSessionLatest returns the newest profile of each type in a session,
+// the current state a session overview shows
+func (s *Server) handleSessionLatest(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "Missing session name", http.StatusBadRequest)
+		return
+	}
+
+	latest, err := s.store.LatestProfiles(r.Context(), name)
+	if err != nil {
+		log.Printf("Failed to get latest profiles: %v", err)
+		http.Error(w, "Failed to get latest profiles", http.StatusInternalServerError)
+		return
+	}
+	if len(latest) == 0 {
+		http.Error(w, "Session not found: "+name, http.StatusNotFound)
+		return
+	}
+
+	resp := make(map[models.ProfileType]any, len(latest))
+	for pt, p := range latest {
+		resp[pt] = withUnits(r, p)
+	}
+	writeResponse(w, r, resp)
+}
+
+// handleSessionHeatmap buckets a session's headline metric by day and time
+// of day, e.g. ?metric=cpu_time&bucket=1h&tz=Europe/Berlin
+func (s *Server) handleSessionHeatmap(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "Missing session name", http.StatusBadRequest)
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "cpu_time"
+	}
+	if !slices.Contains(storage.WorstMetrics, metric) {
+		http.Error(w, "Invalid metric: must be one of "+strings.Join(storage.WorstMetrics, ", "), http.StatusBadRequest)
+		return
+	}
+
+	bucket := time.Hour
+	if b := r.URL.Query().Get("bucket"); b != "" {
+		d, err := time.ParseDuration(b)
+		if err != nil || d < time.Minute || d > 24*time.Hour || (24*time.Hour)%d != 0 {
+			http.Error(w, "Invalid bucket: must be a duration from 1m to 24h that divides a day, e.g. 15m or 1h", http.StatusBadRequest)
+			return
+		}
+		bucket = d
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, "Invalid tz: "+tz, http.StatusBadRequest)
+			return
+		}
+		loc = l
+	}
+
+	points, err := s.store.MetricSeries(r.Context(), name, metric)
+	if err != nil {
+		log.Printf("Failed to load session metrics: %v", err)
+		http.Error(w, "Failed to load session metrics", http.StatusInternalServerError)
+		return
+	}
+	if len(points) == 0 {
+		http.Error(w, "No "+metric+" values in session: "+name, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NewHeatmap(name, metric, points, bucket, loc))
+}
+
+// handleSessionPercentiles reports the distribution of a quick-access
+// metric across a session's profiles of one type, e.g.
+// ?type=cpu&metric=total_value
+func (s *Server) handleSessionPercentiles(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "Missing session name", http.StatusBadRequest)
+		return
+	}
+
+	pt := models.ProfileTypeCPU
+	if t := r.URL.Query().Get("type"); t != "" {
+		pt = models.ProfileType(t)
+		if !pt.IsValid() {
+			http.Error(w, "Invalid profile type: "+t, http.StatusBadRequest)
+			return
+		}
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "total_value"
+	}
+	if !slices.Contains(storage.QuickMetrics, metric) {
+		http.Error(w, "Invalid metric: must be one of "+strings.Join(storage.QuickMetrics, ", "), http.StatusBadRequest)
+		return
+	}
+
+	values, err := s.store.SessionValues(r.Context(), name, pt, metric)
+	if err != nil {
+		log.Printf("Failed to load session metrics: %v", err)
+		http.Error(w, "Failed to load session metrics", http.StatusInternalServerError)
+		return
+	}
+	if len(values) == 0 {
+		http.Error(w, "No "+string(pt)+" "+metric+" values in session: "+name, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NewPercentiles(name, pt, metric, values))
+}
+
+// handleConfig exposes the runtime settings the frontend needs
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	ui := struct {
+		config.UIConfig
+		LogoURL string `json:"logo_url,omitempty"`
+	}{UIConfig: s.cfg.UI}
+	if s.cfg.UI.Logo != "" {
+		ui.LogoURL = "/branding/logo"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"project": s.cfg.Project,
+		"ui":      ui,
+	})
+}
+
+// handleOptimize refreshes the database's query statistics, for after
+// large imports or prunes. REINDEX is left to the CLI, see Store.Reindex.
+func (s *Server) handleOptimize(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if err := s.store.Optimize(r.Context()); err != nil {
+		log.Printf("Failed to optimize database: %v", err)
+		http.Error(w, "Failed to optimize database", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "Database optimized",
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+func (s *Server) handleK6Ingest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse k6 summary JSON
+	parsed, err := k6.Parse(body)
+	if err != nil {
+		http.Error(w, "Failed to parse k6 summary: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile := s.newIngest(w, r, body, models.ProfileTypeK6)
+	if profile == nil {
+		return
+	}
+	profile.DurationNS = parsed.DurationMS * 1_000_000 // Convert ms to ns
+
+	// Set k6 quick-access fields
+	if parsed.Metrics != nil {
+		if parsed.Metrics.P95 > 0 {
+			profile.K6P95 = &parsed.Metrics.P95
+		}
+		if parsed.Metrics.P99 > 0 {
+			profile.K6P99 = &parsed.Metrics.P99
+		}
+		if parsed.Metrics.RPS > 0 {
+			profile.K6RPS = &parsed.Metrics.RPS
+		}
+		profile.K6ErrorRate = &parsed.Metrics.ErrorRate
+		if parsed.DurationMS > 0 {
+			profile.K6DurationMS = &parsed.DurationMS
+		}
+
+		// Marshal metrics
+		metricsJSON, err := json.Marshal(parsed.Metrics)
+		if err == nil {
+			profile.Metrics = models.NullableJSON(metricsJSON)
+		}
+	}
+
+	resp := map[string]any{}
+	if len(parsed.Dropped) > 0 {
+		resp["warning"] = droppedMetricsWarning(parsed.Dropped)
+	}
+	s.saveIngest(w, r, profile, "K6 profile ingested successfully", resp)
+}
+
+// droppedMetricsWarning lists the k6 summary metrics and stats an ingest
+// didn't keep, the first few by name
+func droppedMetricsWarning(dropped []string) string {
+	const shown = 10
+	msg := fmt.Sprintf("%d summary metrics or stats not kept (over the %d metric limit, or not a k6 aggregation): ", len(dropped), k6.MaxSummaryMetrics)
+	if len(dropped) > shown {
+		return msg + strings.Join(dropped[:shown], ", ") + fmt.Sprintf(", and %d more", len(dropped)-shown)
+	}
+	return msg + strings.Join(dropped, ", ")
+}
+
+// timeParam returns an RFC3339 timestamp query param such as created_at or
+// profile_time, so replayed and late-uploaded profiles keep their real
+// times. Falls back to now when absent or unparseable.
+func timeParam(r *http.Request, name string, now time.Time) time.Time {
+	if v := r.URL.Query().Get(name); v != "" {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return now
+}
+
+// windowParam reads an ingest's window param, the monitoring window a
+// snapshot profile stands for, as a Go duration like "5m"; 0 when absent
+func windowParam(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("window must be a positive duration like 5m, got %q", v)
+	}
+	return int64(d), nil
+}
+
+// writeResponse encodes v as JSON, or as MessagePack when the client's
+// Accept header asks for it
+func writeResponse(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Add("Vary", "Accept")
+	if wantsMsgpack(r) {
+		data, err := msgpack.Marshal(v)
+		if err != nil {
+			log.Printf("Failed to encode msgpack response: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", msgpack.ContentType)
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// wantsMsgpack reports whether writeResponse answers r in MessagePack
+func wantsMsgpack(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), msgpack.ContentType)
+}
+
+// withUnits applies the units query param to a response value. With
+// units=human every duration and byte field, including those in metrics,
+// gets a _display string alongside the raw number; the default is raw.
+func withUnits(r *http.Request, v any) any {
+	if r.URL.Query().Get("units") != "human" {
+		return v
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return v
+	}
+	humanize(decoded)
+	return decoded
+}
+
+// humanize adds display fields to decoded profiles and their metrics
+func humanize(v any) {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			humanize(item)
+		}
+	case map[string]any:
+		format.Humanize(v)
+		if metrics, ok := v["metrics"].(map[string]any); ok {
+			format.Humanize(metrics)
+		}
+	}
+}
+
+// getProfile loads a profile, treating profiles outside the request's
+// project scope as not found
+func (s *Server) getProfile(r *http.Request, id string) (*models.Profile, error) {
+	profile, err := s.store.GetProfile(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if project := r.URL.Query().Get("project"); project != "" && profile.Project != project {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, id)
+	}
+	return profile, nil
+}
+
+// profileID returns the ID for an ingested profile: random, or with
+// content_id=true derived from its content (see models.ContentID)
+func profileID(r *http.Request, body []byte, pt models.ProfileType, project, session string) string {
+	if r.URL.Query().Get("content_id") != "true" {
+		return uuid.New().String()
+	}
+	return models.ContentID(body, pt, project, session)
+}
+
+// skipDuplicate answers a content_id ingest whose profile is already
+// stored, leaving it as is, and reports whether it did
+func (s *Server) skipDuplicate(w http.ResponseWriter, r *http.Request, id string) bool {
+	if r.URL.Query().Get("content_id") != "true" {
+		return false
+	}
+	exists, err := s.store.ProfileExists(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to check for duplicate profile: %v", err)
+		http.Error(w, "Failed to check for duplicate profile", http.StatusInternalServerError)
+		return true
+	}
+	if !exists {
+		return false
+	}
+	writeDuplicate(w, id)
+	return true
+}
+
+// writeDuplicate answers an ingest of a profile that's already stored
+func writeDuplicate(w http.ResponseWriter, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":        id,
+		"message":   "Profile already ingested",
+		"duplicate": true,
+	})
+}
+
+// sessionFor returns the ingest's session: the session param if given, else
+// the value of the first configured session label found in its tags.
+func (s *Server) sessionFor(r *http.Request) string {
+	if session := r.URL.Query().Get("session"); session != "" {
+		return session
+	}
+
+	tags := r.URL.Query()["tag"]
+	for _, label := range s.cfg.Server.SessionLabels {
+		if value := models.LabelValue(tags, label); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ingestTags returns an ingest's tags: the configured default tags, the tag
+// params, and a load_run label when a run_id is given to tie the profile to
+// a load test.
+func (s *Server) ingestTags(r *http.Request) []string {
+	tags := append(slices.Clone(s.cfg.DefaultTags), r.URL.Query()["tag"]...)
+	if runID := r.URL.Query().Get("run_id"); runID != "" && models.LabelValue(tags, models.LabelLoadRun) == "" {
+		tags = append(tags, models.LabelLoadRun+"="+runID)
+	}
+	return tags
+}
+
+// maxProvenanceSize caps the provenance param; it describes how a capture
+// was taken, not the data
+const maxProvenanceSize = 2048
+
+// provenanceParam reads an ingest's provenance param: a JSON object, such as
+// the settings perfkit capture sends with the first profile of a run
+func provenanceParam(r *http.Request) (models.NullableJSON, error) {
+	v := r.URL.Query().Get("provenance")
+	if v == "" {
+		return nil, nil
+	}
+	if len(v) > maxProvenanceSize {
+		return nil, fmt.Errorf("provenance is larger than %d bytes", maxProvenanceSize)
+	}
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(v), &obj); err != nil {
+		return nil, fmt.Errorf("provenance must be a JSON object: %w", err)
+	}
+	return models.NullableJSON(v), nil
+}
+
+// labelFilters reads label.<key> query params as label matches:
+// label.k=v and label.k!=v compare values, label.k= and label.k!= test
+// whether the label is present at all
+func labelFilters(q url.Values) ([]storage.LabelMatch, error) {
+	var matches []storage.LabelMatch
+	for param, values := range q {
+		key, ok := strings.CutPrefix(param, "label.")
+		if !ok {
+			continue
+		}
+		// label.k!=v arrives as the param "label.k!" with value v
+		key, negate := strings.CutSuffix(key, "!")
+		if err := storage.ValidateLabelKey(key); err != nil {
+			return nil, err
+		}
+
+		for _, value := range values {
+			m := storage.LabelMatch{Key: key, Value: value}
+			switch {
+			case value == "" && negate:
+				m.Op = storage.LabelNotExists
+			case value == "":
+				m.Op = storage.LabelExists
+			case negate:
+				m.Op = storage.LabelNotEquals
+			default:
+				m.Op = storage.LabelEquals
+			}
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// hostFor returns the instance an ingest came from: the host param if
+// given, else the value of a host= label in its tags.
+func (s *Server) hostFor(r *http.Request) string {
+	if host := r.URL.Query().Get("host"); host != "" {
+		return host
+	}
+	return models.LabelValue(r.URL.Query()["tag"], models.LabelHost)
+}
+
+// sessionCap is the per-session profile cap ingests are saved under. In
+// evict mode the oldest non-baseline profiles make room; otherwise a full
+// session refuses the ingest.
+func (s *Server) sessionCap() storage.SessionCap {
+	return storage.SessionCap{
+		Max:   s.cfg.Server.MaxProfilesPerSession,
+		Evict: s.cfg.Server.SessionOverflow == config.SessionOverflowEvict,
+	}
+}
+
+var errNameTaken = errors.New("profile name already exists in session")
+
+// uniqueName applies the session name policy to an ingest's profile name.
+// In suffix mode a taken name gets the first free -2, -3, ... suffix; in
+// reject mode it returns errNameTaken.
+func (s *Server) uniqueName(r *http.Request, session, name string) (string, error) {
+	mode := s.cfg.Server.SessionNames
+	if session == "" || (mode != config.SessionNamesSuffix && mode != config.SessionNamesReject) {
+		return name, nil
+	}
+
+	count, err := s.store.CountNamedInSession(r.Context(), session, name)
+	if err != nil || count == 0 {
+		return name, err
+	}
+	if mode == config.SessionNamesReject {
+		return "", fmt.Errorf("%w: %s in %s", errNameTaken, name, session)
+	}
+
+	for n := count + 1; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", name, n)
+		taken, err := s.store.CountNamedInSession(r.Context(), session, candidate)
+		if err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// resolveProject picks the project for an ingested profile. Profiles in a
+// session must share a project: an explicit project that differs from the
+// session's is rejected, and a missing one is inherited from the session
+// before falling back to the configured default. SaveProfileCapped checks
+// again as it saves, for sessions another ingest starts meanwhile.
+func (s *Server) resolveProject(r *http.Request, session string) (string, error) {
+	project := r.URL.Query().Get("project")
+
+	if session != "" {
+		existing, err := s.store.SessionProject(r.Context(), session)
+		if err != nil {
+			return "", err
+		}
+		if existing != "" {
+			if project != "" && project != existing {
+				return "", fmt.Errorf("%w: session %q belongs to project %q, not %q", storage.ErrProjectMismatch, session, existing, project)
+			}
+			return existing, nil
+		}
+	}
+
+	if project == "" {
+		project = s.cfg.Project
+	}
+	return project, nil
+}
+
+func (s *Server) handleRuntimeIngest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	metrics, err := runtimestats.Parse(body)
+	if err != nil {
+		http.Error(w, "Failed to parse runtime metrics: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile := s.newIngest(w, r, body, models.ProfileTypeRuntime)
+	if profile == nil {
+		return
+	}
+
+	metricsJSON, err := json.Marshal(metrics)
+	if err == nil {
+		profile.Metrics = models.NullableJSON(metricsJSON)
+	}
+
+	s.saveIngest(w, r, profile, "Runtime metrics ingested successfully", map[string]any{})
+}
+
+// handleTraceIngest stores a Go execution trace with its summary metrics
+func (s *Server) handleTraceIngest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	metrics, err := trace.Parse(body)
+	if err != nil {
+		http.Error(w, "Failed to parse trace: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile := s.newIngest(w, r, body, models.ProfileTypeTrace)
+	if profile == nil {
+		return
+	}
+	profile.DurationNS = metrics.DurationNS
+
+	metricsJSON, err := json.Marshal(metrics)
+	if err == nil {
+		profile.Metrics = models.NullableJSON(metricsJSON)
+	}
+
+	s.saveIngest(w, r, profile, "Trace ingested successfully", map[string]any{})
+}
+
+// readBody reads the request body, undoing a gzip Content-Encoding so the
+// stored data and size reflect the original payload. Both the body and
+// what it decompresses to are capped at server.max_ingest_size. On failure
+// it writes the error, 413 for a body over the cap, and returns false.
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	limit := s.cfg.Server.MaxIngestSize
+	body := io.Reader(r.Body)
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	data, err := func() ([]byte, error) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			return io.ReadAll(body)
+		}
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		if limit > 0 {
+			// One byte over tells a payload at the limit from a larger one
+			return io.ReadAll(io.LimitReader(gr, limit+1))
+		}
+		return io.ReadAll(gr)
+	}()
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) || (limit > 0 && int64(len(data)) > limit) {
+		http.Error(w, fmt.Sprintf("Body is over server.max_ingest_size (%d bytes)", limit), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
 }
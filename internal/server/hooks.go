@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// runIngestHooks starts each configured ingest hook for a newly saved
+// profile. Hooks run in the background, so a slow hook never delays the
+// ingest response; Shutdown waits for running hooks to finish, and none
+// start once it has begun to.
+func (s *Server) runIngestHooks(p *models.Profile) {
+	if len(s.cfg.IngestHooks) == 0 {
+		return
+	}
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	if s.hooksClosed {
+		log.Printf("Skipping ingest hooks for %s: shutting down", p.ID)
+		return
+	}
+	for _, hook := range s.cfg.IngestHooks {
+		s.hooks.Add(1)
+		go func() {
+			defer s.hooks.Done()
+			s.runHook(hook, p)
+		}()
+	}
+}
+
+// runHook runs one hook through the shell with the profile's metadata in
+// PERFKIT_* environment variables, logging its output
+func (s *Server) runHook(hook string, p *models.Profile) {
+	ctx := context.Background()
+	if timeout := s.cfg.IngestHookTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Metadata goes in the environment rather than being spliced into the
+	// command, so names and tags can't inject shell syntax
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+	cmd.Env = append(os.Environ(),
+		"PERFKIT_PROFILE_ID="+p.ID,
+		"PERFKIT_PROFILE_NAME="+p.Name,
+		"PERFKIT_PROFILE_TYPE="+string(p.ProfileType),
+		"PERFKIT_PROJECT="+p.Project,
+		"PERFKIT_SESSION="+p.Session,
+		"PERFKIT_SOURCE="+p.Source,
+		"PERFKIT_TAGS="+strings.Join(p.Tags, ","),
+		"PERFKIT_RAW_SIZE="+strconv.Itoa(p.RawSize),
+	)
+	killProcessGroup(cmd)
+	// Don't wait forever on output pipes held open by a hook's children
+	cmd.WaitDelay = 5 * time.Second
+
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Printf("Ingest hook %q for %s: %s", hook, p.ID, strings.TrimSpace(string(out)))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Ingest hook %q for %s timed out after %s", hook, p.ID, s.cfg.IngestHookTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Ingest hook %q for %s failed: %v", hook, p.ID, err)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/flaticols/perfkit/internal/k6"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// k6Run is a stored k6 profile with its metrics decoded
+type k6Run struct {
+	*models.Profile
+	K6 models.K6Metrics
+}
+
+// defaultK6Metrics are compared when a k6 comparison names none
+var defaultK6Metrics = []string{"p50", "p95", "p99", "rps", "error_rate"}
+
+// loadK6Runs loads two k6 profiles for a comparison, warning in an
+// X-Perfkit-Warning header when they were captured far apart. On failure
+// it writes the error and returns false.
+func (s *Server) loadK6Runs(w http.ResponseWriter, r *http.Request, baseID, targetID string) (base, target *k6Run, ok bool) {
+	found, err := s.store.GetProfilesByIDs(r.Context(), []string{baseID, targetID})
+	if err != nil {
+		log.Printf("Failed to get profiles: %v", err)
+		http.Error(w, "Failed to get profiles", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	project := r.URL.Query().Get("project")
+	runs := make([]*k6Run, 0, 2)
+	for _, id := range []string{baseID, targetID} {
+		p, ok := found[id]
+		if !ok || (project != "" && p.Project != project) {
+			http.Error(w, "Profile not found: "+id, http.StatusNotFound)
+			return nil, nil, false
+		}
+		if p.ProfileType != models.ProfileTypeK6 {
+			http.Error(w, "Only k6 profiles can be compared here, not "+string(p.ProfileType), http.StatusBadRequest)
+			return nil, nil, false
+		}
+
+		run := &k6Run{Profile: p}
+		if err := json.Unmarshal(p.Metrics, &run.K6); err != nil {
+			log.Printf("Failed to decode k6 metrics of %s: %v", p.ID, err)
+			http.Error(w, "Failed to read k6 metrics", http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		runs = append(runs, run)
+	}
+
+	if _, warning := models.CheckAge(runs[0].Profile, runs[1].Profile, s.cfg.Compare.MaxGap); warning != "" {
+		w.Header().Add("X-Perfkit-Warning", warning)
+	}
+	return runs[0], runs[1], true
+}
+
+// handleCompareK6 diffs any named k6 metrics between two runs, e.g.
+// ?ids=a,b&metrics=p95,data_received,iterations
+func (s *Server) handleCompareK6(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) != 2 || strings.TrimSpace(ids[0]) == "" || strings.TrimSpace(ids[1]) == "" {
+		http.Error(w, "ids must name two k6 profiles: base,target", http.StatusBadRequest)
+		return
+	}
+
+	names := defaultK6Metrics
+	if v := r.URL.Query().Get("metrics"); v != "" {
+		names = nil
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	base, target, ok := s.loadK6Runs(w, r, strings.TrimSpace(ids[0]), strings.TrimSpace(ids[1]))
+	if !ok {
+		return
+	}
+
+	deltas, err := k6.CompareMetrics(&base.K6, &target.K6, names)
+	if err != nil {
+		http.Error(w, "Invalid metrics: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResponse(w, r, deltas)
+}
+
+// handleCompareStatusCodes compares how two k6 runs' requests split across
+// status codes, to show e.g. 5xx responses taking a bigger share
+func (s *Server) handleCompareStatusCodes(w http.ResponseWriter, r *http.Request) {
+	baseID := r.URL.Query().Get("base")
+	targetID := r.URL.Query().Get("target")
+	if baseID == "" || targetID == "" {
+		http.Error(w, "Missing base or target parameter", http.StatusBadRequest)
+		return
+	}
+
+	base, target, ok := s.loadK6Runs(w, r, baseID, targetID)
+	if !ok {
+		return
+	}
+	if len(base.K6.StatusCodes) == 0 || len(target.K6.StatusCodes) == 0 {
+		http.Error(w, "Both runs need a status code breakdown: add a threshold on http_reqs{status:...} so k6 reports one", http.StatusUnprocessableEntity)
+		return
+	}
+
+	writeResponse(w, r, k6.DiffStatusCodes(base.K6.StatusCodes, target.K6.StatusCodes))
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// setEventRate fills in the rate of a cumulative profile's metrics: how
+// fast its event count grew since the previous profile of its type from the
+// same host in the session. It's left unset without an earlier capture, or
+// unless both carry the same run_id label: a restart resets the count, and
+// without the label there's no telling whether one happened.
+func (s *Server) setEventRate(ctx context.Context, p *models.Profile, metrics any) {
+	m, ok := metrics.(models.CounterMetrics)
+	if !ok || p.Session == "" {
+		return
+	}
+
+	prev, err := s.store.PreviousProfile(ctx, p)
+	if err != nil {
+		log.Printf("Failed to load previous profile for rate: %v", err)
+		return
+	}
+	if prev == nil {
+		return
+	}
+	if baseRun, run := prev.Label(models.LabelRunID), p.Label(models.LabelRunID); run == "" || baseRun != run {
+		return
+	}
+
+	prevCount, ok := prev.MetricValue(m.CountKey())
+	if !ok {
+		return
+	}
+	delta := float64(m.EventCount()) - prevCount
+	elapsed := p.CapturedAt().Sub(prev.CapturedAt()).Seconds()
+	if delta < 0 || elapsed <= 0 {
+		return
+	}
+	m.SetRate(delta / elapsed)
+}
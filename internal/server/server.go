@@ -3,10 +3,14 @@ package server
 import (
 	"context"
 	"fmt"
-	"io"
+	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/flaticols/perfkit/internal/config"
@@ -16,30 +20,122 @@ import (
 
 type Server struct {
 	cfg     *config.Config
-	store   *storage.Store
+	store   storage.Storage
 	httpSrv *http.Server
+	// hooks tracks running ingest hooks; once hooksClosed is set, under
+	// hooksMu, no more are started, so Shutdown's Wait can't miss one
+	hooks       sync.WaitGroup
+	hooksMu     sync.Mutex
+	hooksClosed bool
 }
 
-func New(cfg *config.Config, store *storage.Store) *Server {
-	return &Server{
+func New(cfg *config.Config, store storage.Storage) *Server {
+	s := &Server{
 		cfg:   cfg,
 		store: store,
 	}
+	// Built up front, so Shutdown never races Serve to it
+	s.httpSrv = &http.Server{
+		Handler:           withGzip(s.routes()),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	return s
 }
 
+// Start listens on the configured address and serves until Shutdown
 func (s *Server) Start() error {
+	addr := fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting server on %s", addr)
+	return s.Serve(l)
+}
+
+// Serve handles requests on l until Shutdown, for callers that pick the
+// listener themselves, such as an in-process server on a random port
+func (s *Server) Serve(l net.Listener) error {
+	return s.httpSrv.Serve(l)
+}
+
+// routes registers the API, UI and self-profiling handlers
+func (s *Server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.HandleFunc("POST /api/pprof/ingest", s.handlePprofIngest)
-	mux.HandleFunc("POST /api/k6/ingest", s.handleK6Ingest)
+	mux.HandleFunc("POST /api/pprof/ingest", s.withIngestTimeout(s.handlePprofIngest))
+	mux.HandleFunc("POST /api/pprof/ingest/url", s.withIngestTimeout(s.handleIngestURL))
+	mux.HandleFunc("POST /api/k6/ingest", s.withIngestTimeout(s.handleK6Ingest))
+	mux.HandleFunc("POST /api/runtime/ingest", s.withIngestTimeout(s.handleRuntimeIngest))
+	mux.HandleFunc("POST /api/trace/ingest", s.withIngestTimeout(s.handleTraceIngest))
 	mux.HandleFunc("GET /api/profiles", s.handleListProfiles)
+	mux.HandleFunc("DELETE /api/profiles", s.handleDeleteProfiles)
+	mux.HandleFunc("GET /api/profiles/stream", s.handleStreamProfiles)
 	mux.HandleFunc("GET /api/profiles/compare", s.handleCompareProfiles)
+	mux.HandleFunc("GET /api/profiles/compare/functions", s.handleCompareFunctions)
+	mux.HandleFunc("GET /api/profiles/compare/flamegraph", s.handleCompareFlameGraph)
+	mux.HandleFunc("GET /api/profiles/compare/status-codes", s.handleCompareStatusCodes)
+	mux.HandleFunc("GET /api/profiles/compare/k6", s.handleCompareK6)
+	mux.HandleFunc("GET /api/profiles/compare/export.html", s.handleCompareExport)
+	mux.HandleFunc("POST /api/profiles/diff", s.handleCreateDiff)
 	mux.HandleFunc("GET /api/profiles/{id}", s.handleGetProfile)
+	mux.HandleFunc("DELETE /api/profiles/{id}", s.handleDeleteProfile)
+	mux.HandleFunc("POST /api/profiles/{id}/star", s.handleStarProfile(true))
+	mux.HandleFunc("POST /api/profiles/{id}/unstar", s.handleStarProfile(false))
+	mux.HandleFunc("GET /api/profiles/{id}/top", s.handleProfileTop)
+	mux.HandleFunc("GET /api/profiles/{id}/report", s.handleProfileReport)
+	mux.HandleFunc("POST /api/profiles/{id}/compare-upload", s.withIngestTimeout(s.handleCompareUpload))
+	mux.HandleFunc("GET /api/profiles/{id}/derived", s.handleDerivedProfiles)
+	mux.HandleFunc("GET /api/profiles/{id}/insights", s.handleProfileInsights)
+	mux.HandleFunc("GET /api/sessions/{name}/health", s.handleSessionHealth)
+	mux.HandleFunc("GET /api/sessions/{name}/summary", s.handleSessionSummary)
+	mux.HandleFunc("GET /api/sessions/{name}/latest", s.handleSessionLatest)
+	mux.HandleFunc("GET /api/sessions/{name}/heatmap", s.handleSessionHeatmap)
+	mux.HandleFunc("GET /api/sessions/{name}/percentiles", s.handleSessionPercentiles)
+	mux.HandleFunc("GET /api/runs/{run_id}", s.handleRun)
+	mux.HandleFunc("GET /api/stats/worst", s.handleWorstProfiles)
+	mux.HandleFunc("GET /api/activity", s.handleActivity)
+	mux.HandleFunc("GET /api/config", s.handleConfig)
+	mux.HandleFunc("POST /api/db/optimize", s.handleOptimize)
+
+	// Project-scoped API routes for shared instances
+	mux.HandleFunc("POST /api/projects/{project}/pprof/ingest", withProject(s.withIngestTimeout(s.handlePprofIngest)))
+	mux.HandleFunc("POST /api/projects/{project}/pprof/ingest/url", withProject(s.withIngestTimeout(s.handleIngestURL)))
+	mux.HandleFunc("POST /api/projects/{project}/k6/ingest", withProject(s.withIngestTimeout(s.handleK6Ingest)))
+	mux.HandleFunc("POST /api/projects/{project}/runtime/ingest", withProject(s.withIngestTimeout(s.handleRuntimeIngest)))
+	mux.HandleFunc("POST /api/projects/{project}/trace/ingest", withProject(s.withIngestTimeout(s.handleTraceIngest)))
+	mux.HandleFunc("GET /api/projects/{project}/profiles", withProject(s.handleListProfiles))
+	mux.HandleFunc("DELETE /api/projects/{project}/profiles", withProject(s.handleDeleteProfiles))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/stream", withProject(s.handleStreamProfiles))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/compare", withProject(s.handleCompareProfiles))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/compare/functions", withProject(s.handleCompareFunctions))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/compare/flamegraph", withProject(s.handleCompareFlameGraph))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/compare/status-codes", withProject(s.handleCompareStatusCodes))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/compare/k6", withProject(s.handleCompareK6))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/compare/export.html", withProject(s.handleCompareExport))
+	mux.HandleFunc("POST /api/projects/{project}/profiles/diff", withProject(s.handleCreateDiff))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/{id}", withProject(s.handleGetProfile))
+	mux.HandleFunc("DELETE /api/projects/{project}/profiles/{id}", withProject(s.handleDeleteProfile))
+	mux.HandleFunc("POST /api/projects/{project}/profiles/{id}/star", withProject(s.handleStarProfile(true)))
+	mux.HandleFunc("POST /api/projects/{project}/profiles/{id}/unstar", withProject(s.handleStarProfile(false)))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/{id}/top", withProject(s.handleProfileTop))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/{id}/report", withProject(s.handleProfileReport))
+	mux.HandleFunc("POST /api/projects/{project}/profiles/{id}/compare-upload", withProject(s.withIngestTimeout(s.handleCompareUpload)))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/{id}/derived", withProject(s.handleDerivedProfiles))
+	mux.HandleFunc("GET /api/projects/{project}/profiles/{id}/insights", withProject(s.handleProfileInsights))
+	mux.HandleFunc("GET /api/projects/{project}/stats/worst", withProject(s.handleWorstProfiles))
+	mux.HandleFunc("GET /api/projects/{project}/activity", withProject(s.handleActivity))
+	mux.HandleFunc("GET /api/projects/{project}/runs/{run_id}", withProject(s.handleRun))
 
 	// Static files and UI
-	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(ui.StaticFS()))))
-	mux.Handle("GET /fonts/", http.StripPrefix("/fonts/", http.FileServer(http.FS(ui.FontsFS()))))
+	mux.Handle("GET /static/", http.StripPrefix("/static/", filesOnly(http.FileServer(http.FS(ui.StaticFS())))))
+	mux.Handle("GET /fonts/", http.StripPrefix("/fonts/", filesOnly(http.FileServer(http.FS(ui.FontsFS())))))
+	mux.HandleFunc("GET /branding/logo", s.handleLogo)
 	mux.HandleFunc("GET /{$}", s.handleIndex)
 	mux.HandleFunc("GET /profile/{id}", s.handleIndex)
 	mux.HandleFunc("GET /compare/{ids}", s.handleIndex)
@@ -60,30 +156,115 @@ func (s *Server) Start() error {
 		mux.Handle("GET /debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 	}
 
-	addr := fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port)
-	s.httpSrv = &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	return mux
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpSrv.Shutdown(ctx)
+
+	// Let running ingest hooks finish, within the shutdown deadline
+	s.hooksMu.Lock()
+	s.hooksClosed = true
+	s.hooksMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.hooks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Shutdown: ingest hooks still running")
 	}
+	return err
+}
 
-	log.Printf("Starting server on %s", addr)
-	return s.httpSrv.ListenAndServe()
+// withIngestTimeout extends the connection deadlines for upload routes, so
+// the server-wide timeouts can stay short for the UI.
+func (s *Server) withIngestTimeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if timeout := s.cfg.Server.IngestTimeout; timeout > 0 {
+			rc := http.NewResponseController(w)
+			deadline := time.Now().Add(timeout)
+			if err := rc.SetReadDeadline(deadline); err != nil {
+				log.Printf("Failed to extend read deadline: %v", err)
+			}
+			if err := rc.SetWriteDeadline(deadline); err != nil {
+				log.Printf("Failed to extend write deadline: %v", err)
+			}
+		}
+		next(w, r)
+	}
 }
 
-func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpSrv.Shutdown(ctx)
+// withProject scopes a handler to the {project} path segment by forcing the
+// project query param, which the handlers already filter and validate on
+func withProject(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		q.Set("project", r.PathValue("project"))
+		r.URL.RawQuery = q.Encode()
+		next(w, r)
+	}
+}
+
+// filesOnly keeps a file server to files: directory listings, and the
+// redirect it answers index.html with, are 404s
+func filesOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := r.URL.Path; p == "" || strings.HasSuffix(p, "/") || path.Base(p) == "index.html" {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// indexTmpl is the embedded index page with UI branding placeholders
+var indexTmpl = template.Must(template.ParseFS(ui.TemplatesFS(), "index.html"))
+
+// indexData fills the branding placeholders in index.html
+type indexData struct {
+	Title   string
+	Theme   string
+	LogoURL string
+	// Filter is the profile list filter the app starts with
+	Filter config.UIFilter
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	f, err := ui.StaticFS().Open("index.html")
-	if err != nil {
-		http.Error(w, "Not found", http.StatusNotFound)
-		return
+	data := indexData{
+		Title: s.cfg.UI.Title,
+		Theme: s.cfg.UI.Theme,
+	}
+	if s.cfg.UI.Logo != "" {
+		data.LogoURL = "/branding/logo"
+	}
+
+	// Deep links like /?project=x&type=heap override the configured
+	// default filter; an empty param clears it
+	data.Filter = s.cfg.UI.DefaultFilter
+	q := r.URL.Query()
+	if q.Has("project") {
+		data.Filter.Project = q.Get("project")
+	}
+	if q.Has("type") {
+		data.Filter.Type = q.Get("type")
+	}
+	if q.Has("session") {
+		data.Filter.Session = q.Get("session")
 	}
-	defer f.Close()
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	io.Copy(w, f)
+	if err := indexTmpl.Execute(w, data); err != nil {
+		log.Printf("Failed to render index: %v", err)
+	}
+}
+
+func (s *Server) handleLogo(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.UI.Logo == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, r, s.cfg.UI.Logo)
 }
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/flaticols/perfkit/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// MaxActivityEvents caps the activity log; recording an event drops the
+// oldest beyond it, so the table never grows past this
+const MaxActivityEvents = 1000
+
+const activitySchema = `
+CREATE TABLE IF NOT EXISTS activity (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at DATETIME NOT NULL,
+	action TEXT NOT NULL,
+	profile_id TEXT NOT NULL,
+	profile_type TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	project TEXT NOT NULL DEFAULT '',
+	session TEXT NOT NULL DEFAULT '',
+	host TEXT NOT NULL DEFAULT ''
+);`
+
+// writeTx runs fn in a transaction, committed if fn succeeds
+func (s *Store) writeTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordIngest logs a profile being stored
+func recordIngest(ctx context.Context, tx *sqlx.Tx, p *models.Profile) error {
+	query := `
+	INSERT INTO activity (created_at, action, profile_id, profile_type, name, project, session, host)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.ExecContext(ctx, query, time.Now(), models.ActivityIngest,
+		p.ID, p.ProfileType, p.Name, p.Project, p.Session, p.Host)
+	if err != nil {
+		return fmt.Errorf("record activity: %w", err)
+	}
+	return pruneActivity(ctx, tx)
+}
+
+// recordDeletes logs the profiles matching where as deleted. Call it in the
+// same transaction, just before deleting them.
+func recordDeletes(ctx context.Context, tx *sqlx.Tx, where string, args ...any) error {
+	query := `
+	INSERT INTO activity (created_at, action, profile_id, profile_type, name, project, session, host)
+	SELECT ?, ?, id, profile_type, name, COALESCE(project, ''), COALESCE(session, ''), COALESCE(host, '')
+	FROM profiles WHERE ` + where
+	_, err := tx.ExecContext(ctx, query, append([]any{time.Now(), models.ActivityDelete}, args...)...)
+	if err != nil {
+		return fmt.Errorf("record activity: %w", err)
+	}
+	return pruneActivity(ctx, tx)
+}
+
+// pruneActivity drops all but the newest MaxActivityEvents events
+func pruneActivity(ctx context.Context, tx *sqlx.Tx) error {
+	query := `DELETE FROM activity WHERE id <= (
+		SELECT id FROM activity ORDER BY id DESC LIMIT 1 OFFSET ?
+	)`
+	if _, err := tx.ExecContext(ctx, query, MaxActivityEvents); err != nil {
+		return fmt.Errorf("prune activity: %w", err)
+	}
+	return nil
+}
+
+// RecentActivity returns up to limit of the newest activity events, newest
+// first, optionally only those of one project.
+func (s *Store) RecentActivity(ctx context.Context, project string, limit int) ([]*models.ActivityEvent, error) {
+	ds := s.goqu.From("activity").
+		Order(goqu.I("id").Desc()).
+		Limit(uint(limit))
+	if project != "" {
+		ds = ds.Where(goqu.I("project").Eq(project))
+	}
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*models.ActivityEvent{}
+	if err := s.db.SelectContext(ctx, &events, query, args...); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// LabelOp is how a LabelMatch compares a profile's key=value tags
+type LabelOp int
+
+const (
+	// LabelEquals matches profiles with a key=value tag
+	LabelEquals LabelOp = iota
+	// LabelNotEquals matches profiles without a key=value tag, including
+	// those without the label at all
+	LabelNotEquals
+	// LabelExists matches profiles with any key=... tag
+	LabelExists
+	// LabelNotExists matches profiles without any key=... tag
+	LabelNotExists
+)
+
+// LabelMatch filters profiles by one label. Several matches on a profile
+// filter must all hold.
+type LabelMatch struct {
+	Key   string
+	Op    LabelOp
+	Value string
+}
+
+// ValidateLabelKey rejects label keys that couldn't come from a tag. Values
+// are bound as query arguments, but keys are checked too so malformed
+// filters fail loudly.
+func ValidateLabelKey(key string) error {
+	if !models.IsLabelKey(key) {
+		return fmt.Errorf("invalid label key %q: use letters, digits, '_', '.' and '-'", key)
+	}
+	return nil
+}
+
+// expression matches rows whose tags JSON array satisfies m
+func (m LabelMatch) expression() exp.Expression {
+	prefix := m.Key + "="
+	switch m.Op {
+	case LabelNotEquals:
+		return goqu.L("NOT EXISTS (SELECT 1 FROM json_each(tags) WHERE json_each.value = ?)", prefix+m.Value)
+	case LabelExists:
+		return goqu.L("EXISTS (SELECT 1 FROM json_each(tags) WHERE substr(json_each.value, 1, ?) = ?)", len(prefix), prefix)
+	case LabelNotExists:
+		return goqu.L("NOT EXISTS (SELECT 1 FROM json_each(tags) WHERE substr(json_each.value, 1, ?) = ?)", len(prefix), prefix)
+	default:
+		return hasTag(prefix + m.Value)
+	}
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// worstMetric is a headline metric profiles can be ranked by
+type worstMetric struct {
+	profileType models.ProfileType
+	value       exp.Expression
+}
+
+// worstMetrics maps the metric names accepted by WorstProfiles to the
+// profile type they apply to and the column holding the value. The JSON
+// expressions match the indexes created in migrate.
+var worstMetrics = map[string]worstMetric{
+	"cpu_time": {models.ProfileTypeCPU, goqu.L("json_extract(metrics, '$.total_cpu_time_ns')")},
+	"inuse":    {models.ProfileTypeHeap, goqu.L("json_extract(metrics, '$.inuse_size')")},
+	"p95":      {models.ProfileTypeK6, goqu.I("k6_p95")},
+}
+
+// WorstMetrics lists the metric names WorstProfiles accepts
+var WorstMetrics = []string{"cpu_time", "inuse", "p95"}
+
+// Groupings WorstProfiles can keep a single worst profile per
+const (
+	GroupByProject = "project"
+	GroupByHost    = "host"
+)
+
+// WorstProfiles returns up to limit profiles with the highest value of
+// metric, highest first. With a groupBy of GroupByProject or GroupByHost
+// only each project's or host's single worst profile is kept.
+func (s *Store) WorstProfiles(ctx context.Context, metric, project string, limit int, groupBy string) ([]*models.RankedProfile, error) {
+	m, ok := worstMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric: %s", metric)
+	}
+
+	ds := s.goqu.From("profiles").
+		Select(append(listColumns, goqu.L("?", m.value).As("value"))...).
+		Where(
+			goqu.I("profile_type").Eq(m.profileType),
+			goqu.L("?", m.value).IsNotNull(),
+		).
+		Order(goqu.L("?", m.value).Desc())
+
+	if project != "" {
+		ds = ds.Where(goqu.I("project").Eq(project))
+	}
+	// Grouped ranking dedupes in Go, so it can't stop early in SQL
+	if groupBy == "" {
+		ds = ds.Limit(uint(limit))
+	}
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var ranked []*models.RankedProfile
+	if err := s.db.SelectContext(ctx, &ranked, query, args...); err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.RankedProfile, 0, min(len(ranked), limit))
+	seen := make(map[string]bool)
+	for _, r := range ranked {
+		if groupBy != "" {
+			key := r.Project
+			if groupBy == GroupByHost {
+				key = r.Host
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		_ = r.UnmarshalTags()
+		r.URL = "/profile/" + r.ID
+		result = append(result, r)
+		if len(result) == limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// MetricHistory returns the values of a metrics key across the latest
+// limit of a session's profiles of one type, newest first, skipping
+// profiles without it
+func (s *Store) MetricHistory(ctx context.Context, session string, pt models.ProfileType, key string, limit int) ([]float64, error) {
+	value := goqu.L("json_extract(metrics, ?)", "$."+key)
+	ds := s.goqu.From("profiles").
+		Select(value).
+		Where(
+			goqu.I("session").Eq(session),
+			goqu.I("profile_type").Eq(pt),
+			value.IsNotNull(),
+		).
+		Order(goqu.L(sortableTime("created_at")).Desc()).
+		Limit(uint(limit))
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []float64
+	if err := s.db.SelectContext(ctx, &values, query, args...); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// QuickMetrics lists the quick-access columns SessionValues reads
+var QuickMetrics = []string{"total_value", "total_samples", "duration_ns", "raw_size", "k6_p95", "k6_p99", "k6_rps", "k6_error_rate", "k6_duration_ms"}
+
+// SessionValues returns one of QuickMetrics across a session's profiles of
+// one type, lowest first, skipping profiles without it
+func (s *Store) SessionValues(ctx context.Context, session string, pt models.ProfileType, metric string) ([]float64, error) {
+	if !slices.Contains(QuickMetrics, metric) {
+		return nil, fmt.Errorf("unknown metric: %s", metric)
+	}
+
+	ds := s.goqu.From("profiles").
+		Select(goqu.I(metric)).
+		Where(
+			goqu.I("session").Eq(session),
+			goqu.I("profile_type").Eq(pt),
+			goqu.I(metric).IsNotNull(),
+		).
+		Order(goqu.I(metric).Asc())
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []float64
+	if err := s.db.SelectContext(ctx, &values, query, args...); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// MetricSeries returns the capture time and value of one of WorstMetrics
+// for each of a session's profiles that has it, read from the same indexed
+// columns WorstProfiles ranks by
+func (s *Store) MetricSeries(ctx context.Context, session, metric string) ([]models.MetricPoint, error) {
+	m, ok := worstMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric: %s", metric)
+	}
+
+	ds := s.goqu.From("profiles").
+		Select(goqu.I("created_at"), goqu.L("?", m.value).As("value")).
+		Where(
+			goqu.I("session").Eq(session),
+			goqu.I("profile_type").Eq(m.profileType),
+			goqu.L("?", m.value).IsNotNull(),
+		)
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []models.MetricPoint
+	if err := s.db.SelectContext(ctx, &points, query, args...); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// SessionSummary aggregates a session's headline metrics per profile type
+// in one query. The session has no profiles when ProfileCount is 0.
+func (s *Store) SessionSummary(ctx context.Context, session string) (*models.SessionSummary, error) {
+	types := make([]models.ProfileType, 0, len(models.HeadlineMetrics))
+	for pt := range models.HeadlineMetrics {
+		types = append(types, pt)
+	}
+	slices.Sort(types)
+
+	// Each type's headline metric lives under a different metrics key
+	headline := goqu.Case().Value(goqu.I("profile_type"))
+	for _, pt := range types {
+		headline = headline.When(pt, goqu.L("json_extract(metrics, ?)", "$."+models.HeadlineMetrics[pt]))
+	}
+
+	ds := s.goqu.From("profiles").
+		Select(
+			goqu.I("profile_type"),
+			goqu.COUNT("*").As("count"),
+			goqu.MIN(headline).As("min"),
+			goqu.MAX(headline).As("max"),
+			goqu.AVG(headline).As("avg"),
+		).
+		Where(goqu.I("session").Eq(session)).
+		GroupBy("profile_type").
+		Order(goqu.I("profile_type").Asc())
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &models.SessionSummary{Session: session}
+	if err := s.db.SelectContext(ctx, &summary.Types, query, args...); err != nil {
+		return nil, err
+	}
+	for i := range summary.Types {
+		t := &summary.Types[i]
+		summary.ProfileCount += t.Count
+		if t.Max != nil {
+			t.Metric = models.HeadlineMetrics[t.ProfileType]
+		}
+	}
+
+	query = `SELECT id, created_at, provenance FROM profiles WHERE session = ? AND provenance IS NOT NULL`
+	if err := s.db.SelectContext(ctx, &summary.Provenance, query, session); err != nil {
+		return nil, err
+	}
+	// created_at doesn't sort correctly in SQL (see FindProfiles)
+	slices.SortFunc(summary.Provenance, func(a, b models.SessionProvenance) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return summary, nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// Storage is the profile store the HTTP server works against. *Store
+// implements it on SQLite, either in a database file (New) or purely in
+// memory (NewMemory); handler tests can substitute their own.
+//
+// The in-memory store serializes every query on one connection, so an
+// implementation must not keep a query open while it calls back into the
+// caller: StreamProfiles' fn writes to a client that may be slow, and may
+// use the store itself.
+type Storage interface {
+	SaveProfile(ctx context.Context, p *models.Profile) error
+	SaveProfileCapped(ctx context.Context, p *models.Profile, c SessionCap) error
+	GetProfile(ctx context.Context, id string) (*models.Profile, error)
+	ProfileExists(ctx context.Context, id string) (bool, error)
+	GetProfilesByIDs(ctx context.Context, ids []string) (map[string]*models.Profile, error)
+	DeleteProfile(ctx context.Context, id string) error
+	DeleteProfilesWhere(ctx context.Context, f ProfileFilter) (int64, error)
+	SetStarred(ctx context.Context, id string, starred bool) error
+
+	ListProfiles(ctx context.Context, limit, offset int, f ProfileFilter) ([]*models.Profile, error)
+	FindProfiles(ctx context.Context, f ProfileFilter) ([]*models.Profile, error)
+	StreamProfiles(ctx context.Context, f ProfileFilter, after string, limit int, fn func(p *models.Profile, cursor string) error) error
+	InlineRawData(ctx context.Context, profiles []*models.Profile, maxSize int) error
+
+	SessionProject(ctx context.Context, session string) (string, error)
+	CountSessionProfiles(ctx context.Context, session string) (int, error)
+	CountNamedInSession(ctx context.Context, session, name string) (int, error)
+	SessionHealth(ctx context.Context, session string) (*models.SessionHealth, error)
+	SessionSummary(ctx context.Context, session string) (*models.SessionSummary, error)
+	PreviousProfile(ctx context.Context, p *models.Profile) (*models.Profile, error)
+	LatestProfiles(ctx context.Context, session string) (map[models.ProfileType]*models.Profile, error)
+
+	WorstProfiles(ctx context.Context, metric, project string, limit int, groupBy string) ([]*models.RankedProfile, error)
+	MetricHistory(ctx context.Context, session string, pt models.ProfileType, key string, limit int) ([]float64, error)
+	MetricSeries(ctx context.Context, session, metric string) ([]models.MetricPoint, error)
+	SessionValues(ctx context.Context, session string, pt models.ProfileType, metric string) ([]float64, error)
+
+	RecentActivity(ctx context.Context, project string, limit int) ([]*models.ActivityEvent, error)
+
+	Optimize(ctx context.Context) error
+}
+
+var _ Storage = (*Store)(nil)
@@ -3,15 +3,122 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/doug-martin/goqu/v9"
 	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/flaticols/perfkit/internal/models"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "modernc.org/sqlite"
 )
 
+// listColumns are the profile columns returned by list queries. raw_data and
+// metrics are omitted to keep listings cheap.
+var listColumns = []any{"id", "created_at", "updated_at", "name", "profile_type", "project", "session", "host", "tags", "source", "parent_ids", "raw_size", "is_cumulative", "starred", "profile_time", "duration_ns", "window_ns", "total_samples", "total_value", "stored_samples", "k6_p95", "k6_p99", "k6_rps", "k6_error_rate", "k6_duration_ms"}
+
+// ErrNotFound is returned, wrapped, when a profile ID doesn't exist
+var ErrNotFound = errors.New("profile not found")
+
+// ErrExists is returned, wrapped, when saving a profile whose ID is already
+// stored, e.g. by a concurrent content_id re-import
+var ErrExists = errors.New("profile already exists")
+
+// ErrSessionExists is returned, wrapped, when a session that should be new
+// already has profiles
+var ErrSessionExists = errors.New("session already exists")
+
+// ErrSessionFull is returned, wrapped, when a save would take a session
+// past its SessionCap
+var ErrSessionFull = errors.New("session is full")
+
+// ErrProjectMismatch is returned, wrapped, when saving a profile into a
+// session that belongs to another project
+var ErrProjectMismatch = errors.New("project mismatch")
+
+// ErrNoFilter is returned when a bulk delete's filter would match every
+// profile
+var ErrNoFilter = errors.New("at least one filter is required")
+
+// ProfileFilter narrows a profile query. Zero-valued fields match everything.
+type ProfileFilter struct {
+	Session     string
+	ProfileType string
+	Project     string
+	Host        string
+	Tag         string // exact tag, e.g. "load_run=42"
+	Labels      []LabelMatch
+	Since       time.Time
+	Before      time.Time // created before this time
+	ParentID    string    // derived from this profile
+	Starred     bool      // only starred profiles
+}
+
+// IsEmpty reports whether the filter matches every profile
+func (f ProfileFilter) IsEmpty() bool {
+	return f.Session == "" && f.ProfileType == "" && f.Project == "" && f.Host == "" && f.Tag == "" &&
+		len(f.Labels) == 0 && f.Since.IsZero() && f.Before.IsZero() && f.ParentID == "" && !f.Starred
+}
+
+// inTime reports whether a profile created at t is within the filter's
+// Since and Before bounds
+func (f ProfileFilter) inTime(t time.Time) bool {
+	return (f.Since.IsZero() || !t.Before(f.Since)) && (f.Before.IsZero() || t.Before(f.Before))
+}
+
+// sortableTime is SQL for a time column as Unix milliseconds, so it orders
+// and compares as time. The driver stores times in time.Time's String form
+// ("2006-01-02 15:04:05.999999999 -0700 MST"), which SQLite's date
+// functions only read once the offset is moved up against the clock.
+func sortableTime(col string) string {
+	// The offset starts after the first space past the date
+	sep := fmt.Sprintf("(instr(substr(%s, 12), ' ') + 11)", col)
+	return fmt.Sprintf(`CAST(ROUND(unixepoch(CASE WHEN %[2]s > 11
+		THEN substr(%[1]s, 1, %[2]s - 1) || substr(%[1]s, %[2]s + 1, 3) || ':' || substr(%[1]s, %[2]s + 4, 2)
+		ELSE %[1]s END, 'subsec') * 1000) AS INTEGER)`, col, sep)
+}
+
+// where narrows a profiles query to the filter's columns. Since and Before
+// aren't applied: created_at doesn't compare correctly in SQL (see
+// FindProfiles).
+func (f ProfileFilter) where(ds *goqu.SelectDataset) *goqu.SelectDataset {
+	if f.Session != "" {
+		ds = ds.Where(goqu.I("session").Eq(f.Session))
+	}
+	if f.ProfileType != "" {
+		ds = ds.Where(goqu.I("profile_type").Eq(f.ProfileType))
+	}
+	if f.Project != "" {
+		ds = ds.Where(goqu.I("project").Eq(f.Project))
+	}
+	if f.Host != "" {
+		ds = ds.Where(goqu.I("host").Eq(f.Host))
+	}
+	if f.Tag != "" {
+		ds = ds.Where(hasTag(f.Tag))
+	}
+	for _, m := range f.Labels {
+		ds = ds.Where(m.expression())
+	}
+	if f.ParentID != "" {
+		ds = ds.Where(goqu.L("EXISTS (SELECT 1 FROM json_each(parent_ids) WHERE json_each.value = ?)", f.ParentID))
+	}
+	if f.Starred {
+		ds = ds.Where(goqu.I("starred").IsTrue())
+	}
+	return ds
+}
+
+// hasTag matches rows whose tags JSON array contains tag
+func hasTag(tag string) exp.Expression {
+	return goqu.L("EXISTS (SELECT 1 FROM json_each(tags) WHERE json_each.value = ?)", tag)
+}
+
 type Store struct {
 	db   *sqlx.DB
 	goqu *goqu.Database
@@ -22,7 +129,23 @@ func New(dbPath string) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
+	return open(db)
+}
 
+// NewMemory returns a store whose database lives only in memory, for
+// throwaway servers and tests. Nothing is written to disk and everything is
+// gone once it's closed. Each connection to :memory: would get its own
+// empty database, so queries share a single connection (see Storage).
+func NewMemory() (*Store, error) {
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	return open(db)
+}
+
+func open(db *sqlx.DB) (*Store, error) {
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
@@ -87,26 +210,248 @@ func (s *Store) migrate() error {
 	// Migration: add is_cumulative column if not exists
 	s.db.Exec("ALTER TABLE profiles ADD COLUMN is_cumulative INTEGER DEFAULT 0")
 
+	// Migration: add host column, indexed for filtering by instance
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN host TEXT DEFAULT ''")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_profiles_host ON profiles(host)")
+
+	// Indexes for ranking profiles by headline metric (see worstMetrics)
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_profiles_k6_p95 ON profiles(profile_type, k6_p95)")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_profiles_cpu_time ON profiles(profile_type, json_extract(metrics, '$.total_cpu_time_ns'))")
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_profiles_inuse ON profiles(profile_type, json_extract(metrics, '$.inuse_size'))")
+	// Index for reading one type's quick-access values across a session
+	s.db.Exec("CREATE INDEX IF NOT EXISTS idx_profiles_session_type ON profiles(session, profile_type)")
+
+	// Migration: add parent_ids, the source profiles of a derived one
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN parent_ids TEXT")
+
+	// Migration: add stored_samples, the sample count of decimated profiles
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN stored_samples INTEGER")
+
+	// Migration: add provenance, how a capture run was taken
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN provenance TEXT")
+
+	// Migration: add sample_types, a pprof profile's type/unit pairs
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN sample_types TEXT")
+
+	// Migration: add starred, a bookmark for profiles revisited often
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN starred INTEGER DEFAULT 0")
+
+	// Migration: add window_ns, the monitoring window a snapshot stands for
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN window_ns INTEGER DEFAULT 0")
+
+	// Migration: add content_hash, the SHA-256 of raw_data at ingest
+	s.db.Exec("ALTER TABLE profiles ADD COLUMN content_hash TEXT DEFAULT ''")
+	if err := s.backfillContentHashes(); err != nil {
+		return fmt.Errorf("backfill content hashes: %w", err)
+	}
+
+	if _, err := s.db.Exec(activitySchema); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// backfillContentHashes hashes the raw data of profiles stored before
+// content_hash was, a page at a time to keep few blobs in memory. Once
+// every row has one this is a single empty query.
+func (s *Store) backfillContentHashes() error {
+	ctx := context.Background()
+	for {
+		var rows []struct {
+			ID      string `db:"id"`
+			RawData []byte `db:"raw_data"`
+		}
+		err := s.db.SelectContext(ctx, &rows,
+			`SELECT id, raw_data FROM profiles WHERE COALESCE(content_hash, '') = '' LIMIT ?`, streamPageSize)
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+		err = s.writeTx(ctx, func(tx *sqlx.Tx) error {
+			for _, row := range rows {
+				if _, err := tx.ExecContext(ctx, "UPDATE profiles SET content_hash = ? WHERE id = ?",
+					models.ContentHash(row.RawData), row.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Optimize refreshes the statistics SQLite's query planner picks indexes
+// by. Worth running after large imports or deletions, which leave the
+// statistics describing a different table.
+func (s *Store) Optimize(ctx context.Context) error {
+	for _, stmt := range []string{"ANALYZE", "PRAGMA optimize"} {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// Reindex rebuilds every index from its table. It holds the write lock
+// for as long as that takes on a large database, so it's left to the CLI
+// rather than offered to a running server's clients.
+func (s *Store) Reindex(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "REINDEX")
+	return err
+}
+
 func (s *Store) SaveProfile(ctx context.Context, p *models.Profile) error {
+	return s.SaveProfileCapped(ctx, p, SessionCap{})
+}
+
+// SessionCap limits how many profiles a session holds; Max <= 0 is no limit
+type SessionCap struct {
+	Max int
+	// Evict makes room by deleting the session's oldest profiles, except
+	// baseline ones, instead of refusing the save
+	Evict bool
+}
+
+// SaveProfileCapped stores p, enforcing c on its session in the same
+// transaction, so concurrent ingests can't overshoot the cap and nothing
+// is evicted for a save that fails. A full session, or one whose excess
+// is all baselines, returns ErrSessionFull; a session of another project
+// than p's returns ErrProjectMismatch.
+func (s *Store) SaveProfileCapped(ctx context.Context, p *models.Profile, c SessionCap) error {
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
 	if err := p.MarshalTags(); err != nil {
 		return fmt.Errorf("marshal tags: %w", err)
 	}
+	p.ContentHash = models.ContentHash(p.RawData)
+
+	return s.writeTx(ctx, func(tx *sqlx.Tx) error {
+		// Insert first: the write lock it takes keeps the project and count
+		// below current
+		if err := insertProfile(ctx, tx, p); err != nil {
+			return err
+		}
+		if p.Session == "" {
+			return nil
+		}
 
+		project, err := sessionProject(ctx, tx, p.Session, p.ID)
+		if err != nil {
+			return err
+		}
+		if project != "" && project != p.Project {
+			return fmt.Errorf("%w: session %q belongs to project %q, not %q", ErrProjectMismatch, p.Session, project, p.Project)
+		}
+		if c.Max <= 0 {
+			return nil
+		}
+
+		var count int
+		if err := tx.GetContext(ctx, &count, `SELECT COUNT(*) FROM profiles WHERE session = ?`, p.Session); err != nil {
+			return err
+		}
+		over := count - c.Max
+		if over <= 0 {
+			return nil
+		}
+		if !c.Evict {
+			return fmt.Errorf("%w: %s has %d profiles (max %d)", ErrSessionFull, p.Session, count-1, c.Max)
+		}
+
+		where := `id IN (
+			SELECT id FROM profiles
+			WHERE session = ? AND id != ?
+				AND NOT EXISTS (SELECT 1 FROM json_each(tags) WHERE json_each.value = ?)
+			ORDER BY ` + sortableTime("created_at") + ` LIMIT ?
+		)`
+		args := []any{p.Session, p.ID, models.TagBaseline, over}
+		if err := recordDeletes(ctx, tx, where, args...); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM profiles WHERE "+where, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n < int64(over) {
+			return fmt.Errorf("%w: %s has %d profiles (max %d) and too few not baseline to evict",
+				ErrSessionFull, p.Session, count-1, c.Max)
+		}
+		return nil
+	})
+}
+
+// insertProfile stores p, whose tags must already be marshalled, and logs
+// the ingest. An ID already stored returns ErrExists.
+func insertProfile(ctx context.Context, tx *sqlx.Tx, p *models.Profile) error {
 	query := `
 	INSERT INTO profiles (
-		id, created_at, updated_at, name, profile_type, project, session, tags, source,
-		raw_data, raw_size, is_cumulative, profile_time, duration_ns, metrics,
-		total_samples, total_value, k6_p95, k6_p99, k6_rps, k6_error_rate, k6_duration_ms
+		id, created_at, updated_at, name, profile_type, project, session, host, tags, source, parent_ids,
+		raw_data, raw_size, content_hash, is_cumulative, profile_time, duration_ns, window_ns, metrics, provenance, sample_types,
+		total_samples, total_value, stored_samples, k6_p95, k6_p99, k6_rps, k6_error_rate, k6_duration_ms
 	) VALUES (
-		:id, :created_at, :updated_at, :name, :profile_type, :project, :session, :tags, :source,
-		:raw_data, :raw_size, :is_cumulative, :profile_time, :duration_ns, :metrics,
-		:total_samples, :total_value, :k6_p95, :k6_p99, :k6_rps, :k6_error_rate, :k6_duration_ms
-	)`
+		:id, :created_at, :updated_at, :name, :profile_type, :project, :session, :host, :tags, :source, :parent_ids,
+		:raw_data, :raw_size, :content_hash, :is_cumulative, :profile_time, :duration_ns, :window_ns, :metrics, :provenance, :sample_types,
+		:total_samples, :total_value, :stored_samples, :k6_p95, :k6_p99, :k6_rps, :k6_error_rate, :k6_duration_ms
+	) ON CONFLICT(id) DO NOTHING`
+	res, err := tx.NamedExecContext(ctx, query, p)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrExists, p.ID)
+	}
+	return recordIngest(ctx, tx, p)
+}
+
+// UpdateMetrics overwrites a stored profile's metrics and quick-access
+// columns with those on p, e.g. after re-parsing its raw data with an
+// improved extractor. Everything else about the profile is left as is.
+func (s *Store) UpdateMetrics(ctx context.Context, p *models.Profile) error {
+	query := `
+	UPDATE profiles SET
+		updated_at = :updated_at, duration_ns = :duration_ns, metrics = :metrics, sample_types = :sample_types,
+		total_samples = :total_samples, total_value = :total_value,
+		k6_p95 = :k6_p95, k6_p99 = :k6_p99, k6_rps = :k6_rps,
+		k6_error_rate = :k6_error_rate, k6_duration_ms = :k6_duration_ms
+	WHERE id = :id`
+
+	res, err := s.db.NamedExecContext(ctx, query, p)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, p.ID)
+	}
+	return nil
+}
+
+// SetStarred stars or unstars a profile
+func (s *Store) SetStarred(ctx context.Context, id string, starred bool) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE profiles SET starred = ? WHERE id = ?", starred, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return nil
+}
 
-	_, err := s.db.NamedExecContext(ctx, query, p)
+// AddTag tags a stored profile, unless it already has the tag
+func (s *Store) AddTag(ctx context.Context, id, tag string) error {
+	query := `
+	UPDATE profiles SET tags = json_insert(CASE WHEN json_type(tags) = 'array' THEN tags ELSE '[]' END, '$[#]', ?)
+	WHERE id = ? AND NOT EXISTS (SELECT 1 FROM json_each(profiles.tags) WHERE json_each.value = ?)`
+	if _, err := s.db.ExecContext(ctx, query, tag, id, tag); err != nil {
+		return err
+	}
+	exists, err := s.ProfileExists(ctx, id)
+	if err == nil && !exists {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
 	return err
 }
 
@@ -115,7 +460,7 @@ func (s *Store) GetProfile(ctx context.Context, id string) (*models.Profile, err
 	err := s.db.GetContext(ctx, &p, "SELECT * FROM profiles WHERE id = ?", id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("profile not found: %s", id)
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
 		}
 		return nil, err
 	}
@@ -127,19 +472,97 @@ func (s *Store) GetProfile(ctx context.Context, id string) (*models.Profile, err
 	return &p, nil
 }
 
-func (s *Store) ListProfiles(ctx context.Context, limit, offset int, profileType, project string) ([]*models.Profile, error) {
+// ProfileExists reports whether a profile ID is stored, without loading it
+func (s *Store) ProfileExists(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM profiles WHERE id = ?)", id)
+	return exists, err
+}
+
+// DeleteProfile removes a profile by ID.
+func (s *Store) DeleteProfile(ctx context.Context, id string) error {
+	return s.writeTx(ctx, func(tx *sqlx.Tx) error {
+		if err := recordDeletes(ctx, tx, "id = ?", id); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM profiles WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil
+	})
+}
+
+// idBatchSize caps the IN list per query, well under SQLite's bound
+// variable limit
+const idBatchSize = 500
+
+// GetProfilesByIDs fetches several full profiles, keyed by ID. All batches
+// are read in one transaction, so the result is a consistent snapshot even
+// while profiles are being ingested. Missing IDs are simply absent from the
+// map.
+func (s *Store) GetProfilesByIDs(ctx context.Context, ids []string) (map[string]*models.Profile, error) {
+	result := make(map[string]*models.Profile, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	err := s.readTx(ctx, func(tx *sqlx.Tx) error {
+		for start := 0; start < len(ids); start += idBatchSize {
+			batch := ids[start:min(start+idBatchSize, len(ids))]
+
+			query, args, err := s.goqu.From("profiles").
+				Where(goqu.I("id").In(batch)).
+				ToSQL()
+			if err != nil {
+				return err
+			}
+
+			var profiles []*models.Profile
+			if err := tx.SelectContext(ctx, &profiles, query, args...); err != nil {
+				return err
+			}
+
+			for _, p := range profiles {
+				if err := p.UnmarshalTags(); err != nil {
+					return fmt.Errorf("unmarshal tags: %w", err)
+				}
+				result[p.ID] = p
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readTx runs fn in a read-only transaction. In WAL mode the transaction
+// reads from a single snapshot and doesn't block concurrent writers.
+func (s *Store) readTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("begin read transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	return fn(tx)
+}
+
+// ListProfiles returns a page of profiles matching the filter, newest
+// first. The filter's Since and Before are ignored.
+func (s *Store) ListProfiles(ctx context.Context, limit, offset int, f ProfileFilter) ([]*models.Profile, error) {
 	ds := s.goqu.From("profiles").
-		Select("id", "created_at", "updated_at", "name", "profile_type", "project", "session", "tags", "source", "raw_size", "is_cumulative", "profile_time", "duration_ns", "total_samples", "total_value", "k6_p95", "k6_p99", "k6_rps", "k6_error_rate", "k6_duration_ms").
+		Select(listColumns...).
 		Order(goqu.I("created_at").Desc()).
 		Limit(uint(limit)).
 		Offset(uint(offset))
-
-	if profileType != "" {
-		ds = ds.Where(goqu.I("profile_type").Eq(profileType))
-	}
-	if project != "" {
-		ds = ds.Where(goqu.I("project").Eq(project))
-	}
+	ds = f.where(ds)
 
 	query, args, err := ds.ToSQL()
 	if err != nil {
@@ -158,6 +581,42 @@ func (s *Store) ListProfiles(ctx context.Context, limit, offset int, profileType
 	return profiles, nil
 }
 
+// InlineRawData fills InlineRaw for the listed profiles whose raw data is
+// at most maxSize bytes. Larger profiles are left without it.
+func (s *Store) InlineRawData(ctx context.Context, profiles []*models.Profile, maxSize int) error {
+	ids := make([]string, 0, len(profiles))
+	byID := make(map[string]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		if p.RawSize <= maxSize {
+			ids = append(ids, p.ID)
+			byID[p.ID] = p
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := s.goqu.From("profiles").
+		Select("id", "raw_data").
+		Where(goqu.I("id").In(ids), goqu.I("raw_size").Lte(maxSize)).
+		ToSQL()
+	if err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID      string `db:"id"`
+		RawData []byte `db:"raw_data"`
+	}
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		byID[row.ID].InlineRaw = row.RawData
+	}
+	return nil
+}
+
 func (s *Store) ListSessions(ctx context.Context) ([]string, error) {
 	var sessions []string
 	query := `SELECT DISTINCT session FROM profiles WHERE session IS NOT NULL AND session != '' ORDER BY session`
@@ -169,7 +628,7 @@ func (s *Store) ListSessions(ctx context.Context) ([]string, error) {
 
 func (s *Store) ListProfilesBySession(ctx context.Context, session string) ([]*models.Profile, error) {
 	ds := s.goqu.From("profiles").
-		Select("id", "created_at", "updated_at", "name", "profile_type", "project", "session", "tags", "source", "raw_size", "is_cumulative", "profile_time", "duration_ns", "total_samples", "total_value", "k6_p95", "k6_p99", "k6_rps", "k6_error_rate", "k6_duration_ms").
+		Select(listColumns...).
 		Where(goqu.I("session").Eq(session)).
 		Order(goqu.I("created_at").Desc())
 
@@ -189,3 +648,234 @@ func (s *Store) ListProfilesBySession(ctx context.Context, session string) ([]*m
 
 	return profiles, nil
 }
+
+// SessionProject returns the project of the earliest profile in a session,
+// or "" if the session has no profiles yet.
+func (s *Store) SessionProject(ctx context.Context, session string) (string, error) {
+	return sessionProject(ctx, s.db, session, "")
+}
+
+// sessionProject is SessionProject on q, ignoring the profile exclude
+func sessionProject(ctx context.Context, q sqlx.QueryerContext, session, exclude string) (string, error) {
+	var project sql.NullString
+	query := `SELECT project FROM profiles WHERE session = ? AND id != ? ORDER BY ` + sortableTime("created_at") + ` LIMIT 1`
+	err := sqlx.GetContext(ctx, q, &project, query, session, exclude)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return project.String, nil
+}
+
+// CountSessionProfiles returns how many profiles a session holds.
+func (s *Store) CountSessionProfiles(ctx context.Context, session string) (int, error) {
+	var count int
+	err := s.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM profiles WHERE session = ?`, session)
+	return count, err
+}
+
+// CountNamedInSession returns how many profiles in a session have name.
+func (s *Store) CountNamedInSession(ctx context.Context, session, name string) (int, error) {
+	var count int
+	err := s.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM profiles WHERE session = ? AND name = ?`, session, name)
+	return count, err
+}
+
+// CloneSession copies every profile of session src into a new session dst,
+// as a baseline to compare against while src goes on receiving captures.
+// The copies get new IDs, the baseline tag and their original as parent;
+// everything else, creation time included, is kept. dst must not have
+// profiles yet.
+func (s *Store) CloneSession(ctx context.Context, src, dst string) error {
+	return s.writeTx(ctx, func(tx *sqlx.Tx) error {
+		var existing int
+		if err := tx.GetContext(ctx, &existing, `SELECT COUNT(*) FROM profiles WHERE session = ?`, dst); err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("%w: %s", ErrSessionExists, dst)
+		}
+
+		// Copy one profile at a time, so only one's raw data is in memory
+		var ids []string
+		if err := tx.SelectContext(ctx, &ids, `SELECT id FROM profiles WHERE session = ? ORDER BY created_at`, src); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("%w: no profiles in session %s", ErrNotFound, src)
+		}
+
+		now := time.Now()
+		for _, id := range ids {
+			var p models.Profile
+			if err := tx.GetContext(ctx, &p, "SELECT * FROM profiles WHERE id = ?", id); err != nil {
+				return err
+			}
+			if err := p.UnmarshalTags(); err != nil {
+				return fmt.Errorf("unmarshal tags: %w", err)
+			}
+
+			p.ID = uuid.New().String()
+			p.UpdatedAt = now
+			p.Session = dst
+			p.ParentIDs = models.IDList{id}
+			if !slices.Contains(p.Tags, models.TagBaseline) {
+				p.Tags = append(p.Tags, models.TagBaseline)
+			}
+			if err := p.MarshalTags(); err != nil {
+				return fmt.Errorf("marshal tags: %w", err)
+			}
+			if err := insertProfile(ctx, tx, &p); err != nil {
+				return fmt.Errorf("copy profile %s: %w", id, err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteProfilesWhere removes every profile matching the filter in one
+// transaction and returns how many there were. An empty filter is refused
+// with ErrNoFilter rather than wiping the store.
+func (s *Store) DeleteProfilesWhere(ctx context.Context, f ProfileFilter) (int64, error) {
+	if f.IsEmpty() {
+		return 0, ErrNoFilter
+	}
+
+	query, args, err := f.where(s.goqu.From("profiles").Select("id", "created_at")).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = s.writeTx(ctx, func(tx *sqlx.Tx) error {
+		var rows []struct {
+			ID        string    `db:"id"`
+			CreatedAt time.Time `db:"created_at"`
+		}
+		if err := tx.SelectContext(ctx, &rows, query, args...); err != nil {
+			return err
+		}
+
+		// Time bounds are applied after scanning, see FindProfiles
+		var ids []any
+		for _, row := range rows {
+			if f.inTime(row.CreatedAt) {
+				ids = append(ids, row.ID)
+			}
+		}
+
+		for start := 0; start < len(ids); start += idBatchSize {
+			batch := ids[start:min(start+idBatchSize, len(ids))]
+			where := "id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",") + ")"
+			if err := recordDeletes(ctx, tx, where, batch...); err != nil {
+				return err
+			}
+			res, err := tx.ExecContext(ctx, "DELETE FROM profiles WHERE "+where, batch...)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// PreviousProfile returns the latest profile of the same type, session and
+// host created before p, or nil if there is none.
+func (s *Store) PreviousProfile(ctx context.Context, p *models.Profile) (*models.Profile, error) {
+	var id string
+	err := s.db.GetContext(ctx, &id, `
+		SELECT id FROM profiles
+		WHERE session = ? AND profile_type = ? AND COALESCE(host, '') = ? AND id != ? AND `+sortableTime("created_at")+` < ?
+		ORDER BY `+sortableTime("created_at")+` DESC LIMIT 1`,
+		p.Session, p.ProfileType, p.Host, p.ID, p.CreatedAt.UnixMilli())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetProfile(ctx, id)
+}
+
+// capturedAt is SQL for Profile.CapturedAt as Unix milliseconds: the
+// profile_time if one was given, otherwise created_at
+var capturedAt = `CASE WHEN profile_time IS NULL OR profile_time LIKE '0001-01-01 %'
+	THEN ` + sortableTime("created_at") + ` ELSE ` + sortableTime("profile_time") + ` END`
+
+// LatestProfiles returns the most recently captured profile of each type
+// in a session, by profile_time, keyed by type, with the quick-access
+// metrics of a listing
+func (s *Store) LatestProfiles(ctx context.Context, session string) (map[models.ProfileType]*models.Profile, error) {
+	ranked := s.goqu.From("profiles").
+		Select(append(slices.Clone(listColumns),
+			goqu.L("ROW_NUMBER() OVER (PARTITION BY profile_type ORDER BY "+capturedAt+" DESC)").As("recency"))...).
+		Where(goqu.I("session").Eq(session))
+	query, args, err := s.goqu.From(ranked.As("ranked")).
+		Select(listColumns...).
+		Where(goqu.I("recency").Eq(1)).
+		ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*models.Profile
+	if err := s.db.SelectContext(ctx, &profiles, query, args...); err != nil {
+		return nil, err
+	}
+
+	latest := make(map[models.ProfileType]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		_ = p.UnmarshalTags()
+		latest[p.ProfileType] = p
+	}
+	return latest, nil
+}
+
+// SessionHealth reports capture freshness for a session
+func (s *Store) SessionHealth(ctx context.Context, session string) (*models.SessionHealth, error) {
+	profiles, err := s.ListProfilesBySession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return models.NewSessionHealth(session, profiles, time.Now()), nil
+}
+
+// FindProfiles returns profiles matching the filter, oldest first.
+func (s *Store) FindProfiles(ctx context.Context, f ProfileFilter) ([]*models.Profile, error) {
+	ds := s.goqu.From("profiles").
+		Select(listColumns...).
+		Order(goqu.I("created_at").Asc())
+
+	ds = f.where(ds)
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*models.Profile
+	if err := s.db.SelectContext(ctx, &profiles, query, args...); err != nil {
+		return nil, err
+	}
+
+	// created_at is stored in the driver's text time format, which doesn't
+	// compare lexically, so the time bounds are applied after scanning.
+	matched := profiles[:0]
+	for _, p := range profiles {
+		if !f.inTime(p.CreatedAt) {
+			continue
+		}
+		_ = p.UnmarshalTags()
+		matched = append(matched, p)
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// TestGetProfilesByIDsSnapshot reads more profiles than fit in one batch
+// while other profiles are saved and deleted, and checks every read sees
+// the store as of a single moment: pairs of profiles deleted together, one
+// in the first batch and one in the last, are either both there or both
+// gone.
+func TestGetProfilesByIDsSnapshot(t *testing.T) {
+	stores := map[string]func(t *testing.T) *Store{
+		"file": func(t *testing.T) *Store {
+			s, err := New(filepath.Join(t.TempDir(), "perfkit.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"memory": func(t *testing.T) *Store {
+			s, err := NewMemory()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			ctx := context.Background()
+
+			// Profile i shares a session with profile n-1-i
+			n := 3*idBatchSize + 2
+			pairs := n / 2
+			const deletes = 25
+			ids := make([]string, n)
+			created := time.Now().Add(-time.Hour)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("profile-%04d", i)
+				pair := min(i, n-1-i)
+				if err := s.SaveProfile(ctx, &models.Profile{
+					ID:          ids[i],
+					CreatedAt:   created,
+					UpdatedAt:   created,
+					ProfileType: models.ProfileTypeHeap,
+					Session:     fmt.Sprintf("pair-%d", pair),
+					RawData:     []byte("data"),
+					RawSize:     4,
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				defer close(done)
+				for pair := range deletes {
+					if _, err := s.DeleteProfilesWhere(ctx, ProfileFilter{Session: fmt.Sprintf("pair-%d", pair)}); err != nil {
+						done <- fmt.Errorf("delete pair %d: %w", pair, err)
+						return
+					}
+					if err := s.SaveProfile(ctx, &models.Profile{
+						ID:          fmt.Sprintf("new-%04d", pair),
+						CreatedAt:   time.Now(),
+						ProfileType: models.ProfileTypeCPU,
+						Session:     "writes",
+					}); err != nil {
+						done <- fmt.Errorf("save: %w", err)
+						return
+					}
+					// Spread the writes over several reads
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+
+			var reads int
+			for writing := true; writing; reads++ {
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatal(err)
+					}
+					writing = false
+				default:
+				}
+
+				got, err := s.GetProfilesByIDs(ctx, ids)
+				if err != nil {
+					t.Fatalf("read %d: %v", reads, err)
+				}
+				for i := range pairs {
+					_, first := got[ids[i]]
+					_, last := got[ids[n-1-i]]
+					if first != last {
+						t.Fatalf("read %d: pair %d half deleted (%s present %v, %s present %v)",
+							reads, i, ids[i], first, ids[n-1-i], last)
+					}
+				}
+				if p := got[ids[pairs-1]]; p == nil || string(p.RawData) != "data" {
+					t.Fatalf("read %d: profile %s, never deleted, read as %v", reads, ids[pairs-1], p)
+				}
+			}
+
+			got, err := s.GetProfilesByIDs(ctx, ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != n-2*deletes {
+				t.Errorf("%d profiles left after deleting %d pairs of %d, want %d", len(got), deletes, n, n-2*deletes)
+			}
+			count, err := s.CountSessionProfiles(ctx, "writes")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != deletes {
+				t.Errorf("saved %d profiles during the reads, want %d", count, deletes)
+			}
+			t.Logf("%d reads", reads)
+		})
+	}
+}
+
+// TestPreviousProfile checks the previous profile is picked by time, not
+// by the text created_at is stored as, and only from the same host
+func TestPreviousProfile(t *testing.T) {
+	s, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// "13:30 +0200" sorts after "12:..." as text but is the earliest
+	east := time.FixedZone("EET", 2*60*60)
+	profiles := []struct {
+		id      string
+		host    string
+		created time.Time
+	}{
+		{"oldest", "a", base.Add(-30 * time.Minute).In(east)},
+		{"older", "a", base.Add(-10 * time.Minute)},
+		{"newer", "a", base.Add(-5*time.Minute + 250*time.Millisecond)},
+		{"other-host", "b", base.Add(-time.Minute)},
+		{"later", "a", base.Add(time.Minute)},
+	}
+	for _, p := range profiles {
+		if err := s.SaveProfile(ctx, &models.Profile{
+			ID:          p.id,
+			CreatedAt:   p.created,
+			UpdatedAt:   p.created,
+			ProfileType: models.ProfileTypeBlock,
+			Session:     "s",
+			Host:        p.host,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		created time.Time
+		want    string
+	}{
+		{base, "newer"},
+		{base.Add(-5 * time.Minute), "older"},
+		{base.Add(-20 * time.Minute).In(east), "oldest"},
+		{base.Add(-time.Hour), ""},
+	}
+	for _, tt := range tests {
+		prev, err := s.PreviousProfile(ctx, &models.Profile{
+			ID:          "current",
+			CreatedAt:   tt.created,
+			ProfileType: models.ProfileTypeBlock,
+			Session:     "s",
+			Host:        "a",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if prev != nil {
+			got = prev.ID
+		}
+		if got != tt.want {
+			t.Errorf("before %v: previous is %q, want %q", tt.created, got, tt.want)
+		}
+	}
+}
+
+func TestMetricHistoryLatest(t *testing.T) {
+	s, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		created := base.Add(time.Duration(i) * time.Minute)
+		if err := s.SaveProfile(ctx, &models.Profile{
+			ID:          fmt.Sprintf("p%d", i),
+			CreatedAt:   created,
+			UpdatedAt:   created,
+			ProfileType: models.ProfileTypeGoroutine,
+			Session:     "s",
+			Metrics:     []byte(fmt.Sprintf(`{"total_goroutines": %d}`, i)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.MetricHistory(ctx, "s", models.ProfileTypeGoroutine, "total_goroutines", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{9, 8, 7}; !slices.Equal(got, want) {
+		t.Errorf("history %v, want %v", got, want)
+	}
+}
+
+func TestLatestProfiles(t *testing.T) {
+	s, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	east := time.FixedZone("EET", 2*60*60)
+	at := func(d time.Duration) *time.Time {
+		t := base.Add(d)
+		return &t
+	}
+	profiles := []struct {
+		id       string
+		pt       models.ProfileType
+		created  time.Time
+		captured *time.Time
+	}{
+		// Created last, but captured first
+		{"heap-late-upload", models.ProfileTypeHeap, base.Add(time.Hour), at(-time.Hour)},
+		{"heap-latest", models.ProfileTypeHeap, base, at(-time.Minute)},
+		// Sorts last as text, but is the earliest
+		{"cpu-east", models.ProfileTypeCPU, base.Add(-30 * time.Minute).In(east), nil},
+		{"cpu-latest", models.ProfileTypeCPU, base, nil},
+	}
+	for _, p := range profiles {
+		if err := s.SaveProfile(ctx, &models.Profile{
+			ID:          p.id,
+			CreatedAt:   p.created,
+			UpdatedAt:   p.created,
+			ProfileType: p.pt,
+			Session:     "s",
+			ProfileTime: p.captured,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := s.LatestProfiles(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("%d types, want 2", len(latest))
+	}
+	for pt, want := range map[models.ProfileType]string{models.ProfileTypeHeap: "heap-latest", models.ProfileTypeCPU: "cpu-latest"} {
+		if p := latest[pt]; p == nil || p.ID != want {
+			t.Errorf("latest %s is %v, want %s", pt, p, want)
+		}
+	}
+}
+
+func TestSaveProfileProjectMismatch(t *testing.T) {
+	s, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	save := func(id, project string, created time.Time) error {
+		return s.SaveProfile(ctx, &models.Profile{
+			ID:          id,
+			CreatedAt:   created,
+			UpdatedAt:   created,
+			ProfileType: models.ProfileTypeHeap,
+			Project:     project,
+			Session:     "s",
+		})
+	}
+
+	if err := save("first", "shop", base); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("same", "shop", base.Add(time.Minute)); err != nil {
+		t.Fatalf("same project: %v", err)
+	}
+	if err := save("other", "billing", base.Add(2*time.Minute)); !errors.Is(err, ErrProjectMismatch) {
+		t.Fatalf("other project: got %v, want ErrProjectMismatch", err)
+	}
+	if _, err := s.GetProfile(ctx, "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rejected profile was stored: %v", err)
+	}
+	if project, err := s.SessionProject(ctx, "s"); err != nil || project != "shop" {
+		t.Errorf("session project %q (%v), want shop", project, err)
+	}
+}
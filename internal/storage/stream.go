@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// streamRow is a listed profile plus its raw created_at text, which is what
+// the rows are ordered by and so what a cursor has to resume from
+type streamRow struct {
+	models.Profile
+	SortKey string `db:"sort_key"`
+}
+
+// ErrInvalidCursor is returned when a stream cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor builds an opaque keyset cursor from a row's sort key and ID
+func encodeCursor(sortKey, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortKey + "\x00" + id))
+}
+
+func decodeCursor(cursor string) (sortKey, id string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	sortKey, id, ok := strings.Cut(string(raw), "\x00")
+	if !ok {
+		return "", "", ErrInvalidCursor
+	}
+	return sortKey, id, nil
+}
+
+// streamPageSize is how many rows StreamProfiles reads per query
+const streamPageSize = 500
+
+// StreamProfiles walks profiles matching the filter newest first, calling fn
+// with each one and the cursor that resumes after it. Pagination is keyset
+// based on (created_at, id), so resuming deep into the table costs the same
+// as starting at the top. An empty after starts from the newest profile and
+// a limit of 0 walks every match.
+//
+// Rows are read a page at a time and fn only runs between queries, so a
+// slow consumer doesn't hold a connection, the in-memory store's only one
+// included, and fn may call back into the store.
+func (s *Store) StreamProfiles(ctx context.Context, f ProfileFilter, after string, limit int, fn func(p *models.Profile, cursor string) error) error {
+	for {
+		size := streamPageSize
+		if limit > 0 {
+			size = min(size, limit)
+		}
+		page, err := s.streamPage(ctx, f, after, size)
+		if err != nil {
+			return err
+		}
+
+		for i := range page {
+			row := &page[i]
+			after = encodeCursor(row.SortKey, row.ID)
+			// created_at text doesn't compare as time, see FindProfiles
+			if !f.inTime(row.CreatedAt) {
+				continue
+			}
+			_ = row.UnmarshalTags()
+			if err := fn(&row.Profile, after); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < size {
+			return nil
+		}
+		if limit > 0 {
+			if limit -= len(page); limit == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// streamPage reads up to size matching rows after the cursor
+func (s *Store) streamPage(ctx context.Context, f ProfileFilter, after string, size int) ([]streamRow, error) {
+	ds := s.goqu.From("profiles").
+		Select(append(listColumns, goqu.L("CAST(created_at AS TEXT)").As("sort_key"))...).
+		Order(goqu.I("created_at").Desc(), goqu.I("id").Desc()).
+		Limit(uint(size))
+
+	if after != "" {
+		sortKey, id, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		ds = ds.Where(goqu.L("(created_at, id) < (?, ?)", sortKey, id))
+	}
+	ds = f.where(ds)
+
+	query, args, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []streamRow
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
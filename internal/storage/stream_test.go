@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/flaticols/perfkit/internal/models"
+)
+
+// The in-memory store has a single connection, so a callback that uses the
+// store would deadlock if StreamProfiles kept its query open
+func TestStreamProfilesCallbackUsesStore(t *testing.T) {
+	s, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	n := streamPageSize + 10
+	start := time.Now().Add(-time.Hour)
+	for i := range n {
+		if err := s.SaveProfile(ctx, &models.Profile{
+			ID:          fmt.Sprintf("profile-%04d", i),
+			CreatedAt:   start.Add(time.Duration(i) * time.Second),
+			ProfileType: models.ProfileTypeCPU,
+			Session:     "stream",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	var seen []string
+	go func() {
+		done <- s.StreamProfiles(ctx, ProfileFilter{Session: "stream"}, "", 0, func(p *models.Profile, cursor string) error {
+			seen = append(seen, p.ID)
+			_, err := s.GetProfile(ctx, p.ID)
+			return err
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("StreamProfiles deadlocked on a callback using the store")
+	}
+
+	if len(seen) != n {
+		t.Fatalf("streamed %d profiles, want %d", len(seen), n)
+	}
+	// Newest first, across the page boundary
+	for i, id := range seen {
+		if want := fmt.Sprintf("profile-%04d", n-1-i); id != want {
+			t.Fatalf("profile %d is %s, want %s", i, id, want)
+		}
+	}
+}
+
+func TestStreamProfilesLimitAcrossPages(t *testing.T) {
+	s, err := NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	for i := range streamPageSize + 5 {
+		if err := s.SaveProfile(ctx, &models.Profile{
+			ID:          fmt.Sprintf("profile-%04d", i),
+			CreatedAt:   time.Now().Add(time.Duration(i) * time.Second),
+			ProfileType: models.ProfileTypeCPU,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A first page, then resume from its last cursor for the rest
+	var count int
+	var last string
+	err = s.StreamProfiles(ctx, ProfileFilter{}, "", streamPageSize+2, func(p *models.Profile, cursor string) error {
+		count++
+		last = cursor
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != streamPageSize+2 {
+		t.Fatalf("limit %d streamed %d", streamPageSize+2, count)
+	}
+
+	count = 0
+	err = s.StreamProfiles(ctx, ProfileFilter{}, last, 0, func(p *models.Profile, cursor string) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("resumed stream had %d profiles, want 3", count)
+	}
+}
@@ -0,0 +1,173 @@
+// Package trace extracts summary metrics from Go execution traces, so
+// stored traces can be compared and searched like other profiles.
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/flaticols/perfkit/internal/models"
+	xtrace "golang.org/x/exp/trace"
+)
+
+// gcRange is the range event covering a GC cycle's concurrent mark phase
+const gcRange = "GC concurrent mark phase"
+
+// goroutineStats accumulates one goroutine's state while reading a trace
+type goroutineStats struct {
+	function      string
+	runs          int64
+	runnableSince xtrace.Time
+	syscallSince  xtrace.Time
+}
+
+// Parse reads a Go execution trace, gzipped or plain, and sums scheduling
+// latency (time goroutines spent runnable before running), GC and syscall
+// time, and per-goroutine run counts.
+func Parse(data []byte) (*models.TraceMetrics, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	reader, err := xtrace.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read trace: %w", err)
+	}
+
+	metrics := &models.TraceMetrics{}
+	goroutines := make(map[xtrace.GoID]*goroutineStats)
+	var start, end, gcSince xtrace.Time
+	for {
+		ev, err := reader.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read trace: %w", err)
+		}
+
+		t := ev.Time()
+		if start == 0 {
+			start = t
+		}
+		end = t
+
+		switch ev.Kind() {
+		case xtrace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != xtrace.ResourceGoroutine {
+				continue
+			}
+			id := st.Resource.Goroutine()
+			g, ok := goroutines[id]
+			if !ok {
+				g = &goroutineStats{}
+				goroutines[id] = g
+			}
+			from, to := st.Goroutine()
+
+			// The outermost frame of any of the goroutine's stacks is the
+			// function it was started with
+			if g.function == "" {
+				for frame := range st.Stack.Frames() {
+					g.function = frame.Func
+				}
+			}
+
+			switch {
+			case from == xtrace.GoRunnable && to == xtrace.GoRunning:
+				if g.runnableSince != 0 {
+					metrics.SchedLatencyNS += int64(t.Sub(g.runnableSince))
+				}
+				g.runnableSince = 0
+			case from == xtrace.GoSyscall && g.syscallSince != 0:
+				metrics.SyscallTimeNS += int64(t.Sub(g.syscallSince))
+				g.syscallSince = 0
+			}
+
+			switch to {
+			case xtrace.GoRunnable:
+				g.runnableSince = t
+			case xtrace.GoRunning:
+				g.runs++
+			case xtrace.GoSyscall:
+				g.syscallSince = t
+			}
+
+		case xtrace.EventRangeBegin, xtrace.EventRangeActive:
+			if ev.Range().Name == gcRange {
+				gcSince = t
+				// Active ranges were already running when the trace began,
+				// so only cycles starting within it are counted
+				if ev.Kind() == xtrace.EventRangeBegin {
+					metrics.GCCount++
+				}
+			}
+
+		case xtrace.EventRangeEnd:
+			if ev.Range().Name == gcRange && gcSince != 0 {
+				metrics.GCTimeNS += int64(t.Sub(gcSince))
+				gcSince = 0
+			}
+		}
+	}
+
+	// Close out whatever was still in progress when the trace ended
+	for _, g := range goroutines {
+		if g.syscallSince != 0 {
+			metrics.SyscallTimeNS += int64(end.Sub(g.syscallSince))
+		}
+	}
+	if gcSince != 0 {
+		metrics.GCTimeNS += int64(end.Sub(gcSince))
+	}
+
+	metrics.DurationNS = int64(end.Sub(start))
+	metrics.GoroutineCount = int64(len(goroutines))
+	metrics.TopGoroutines = topGoroutines(goroutines, 10)
+	return metrics, nil
+}
+
+// topGoroutines groups run counts by the goroutines' start functions, so
+// the same worker pool lines up across traces, most runs first
+func topGoroutines(goroutines map[xtrace.GoID]*goroutineStats, n int) []models.GoroutineRuns {
+	byFunction := make(map[string]*models.GoroutineRuns)
+	for _, g := range goroutines {
+		name := g.function
+		if name == "" {
+			name = "unknown"
+		}
+		gr, ok := byFunction[name]
+		if !ok {
+			gr = &models.GoroutineRuns{Function: name}
+			byFunction[name] = gr
+		}
+		gr.Goroutines++
+		gr.Runs += g.runs
+	}
+
+	sorted := make([]models.GoroutineRuns, 0, len(byFunction))
+	for _, gr := range byFunction {
+		sorted = append(sorted, *gr)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Runs != sorted[j].Runs {
+			return sorted[i].Runs > sorted[j].Runs
+		}
+		return sorted[i].Function < sorted[j].Function
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
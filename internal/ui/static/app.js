@@ -72,7 +72,7 @@ const router = {
     },
 
     navigate(path) {
-        if (path === location.pathname) return;
+        if (path === location.pathname + location.search) return;
         history.pushState(null, '', path);
         this.route();
     },
@@ -108,6 +108,8 @@ const router = {
         const template = document.getElementById('dashboard-template');
         main.innerHTML = '';
         main.appendChild(template.content.cloneNode(true));
+        applyFilterParams(new URLSearchParams(location.search));
+        renderListFilter();
         loadProfiles();
     },
 
@@ -128,16 +130,50 @@ const router = {
 
 // Profile list
 let refreshInterval;
-let currentProject = '';
 
-async function loadProfiles(project = currentProject) {
+// The list filter starts from the config's ui.default_filter, or a deep
+// link like /?project=x&type=heap, as resolved by the server
+const appData = document.getElementById('app').dataset;
+const listFilter = {
+    project: appData.project || '',
+    type: appData.type || '',
+    session: appData.session || '',
+    starred: '',
+};
+
+// applyFilterParams lets in-app links override the filter the same way
+// the server does: a present param replaces it, an empty one clears it
+function applyFilterParams(params) {
+    for (const key of Object.keys(listFilter)) {
+        if (params.has(key)) listFilter[key] = params.get(key);
+    }
+}
+
+// renderListFilter shows the type and session the list is narrowed to,
+// which have no dropdown of their own
+function renderListFilter() {
+    const el = document.getElementById('list-filter');
+    if (!el) return;
+
+    const parts = [listFilter.type, listFilter.session && `session ${listFilter.session}`, listFilter.starred && 'starred'].filter(Boolean);
+    el.hidden = parts.length === 0;
+    el.textContent = parts.join(' · ') + ' ';
+    const all = document.createElement('a');
+    all.href = '/?project=&type=&session=&starred=';
+    all.textContent = 'Show all';
+    el.appendChild(all);
+}
+
+async function loadProfiles(project = listFilter.project) {
     clearInterval(refreshInterval);
-    currentProject = project;
+    listFilter.project = project;
 
     try {
         const url = new URL('/api/profiles', location.origin);
         url.searchParams.set('limit', '50');
-        if (project) url.searchParams.set('project', project);
+        for (const [key, value] of Object.entries(listFilter)) {
+            if (value) url.searchParams.set(key, value);
+        }
 
         const response = await fetch(url);
         if (!response.ok) throw new Error('Failed to fetch');
@@ -147,7 +183,7 @@ async function loadProfiles(project = currentProject) {
         console.error('Failed to load profiles:', err);
     }
 
-    refreshInterval = setInterval(() => loadProfiles(currentProject), 5000);
+    refreshInterval = setInterval(() => loadProfiles(listFilter.project), 5000);
 }
 
 function setupProjectFilter(profiles) {
@@ -158,7 +194,7 @@ function setupProjectFilter(profiles) {
     const projects = [...new Set(profiles.map(p => p.project).filter(Boolean))].sort();
 
     // Preserve selection
-    const current = filter.value || currentProject;
+    const current = filter.value || listFilter.project;
 
     // Populate options
     filter.innerHTML = '<option value="">All projects</option>';
@@ -240,6 +276,8 @@ function renderProfiles(profiles) {
                 checkbox.disabled = true;
             }
 
+            setStarButton(row.querySelector('.star-btn'), p.starred);
+
             const nameLink = row.querySelector('.profile-name');
             nameLink.href = `/profile/${p.id}`;
             nameLink.textContent = p.name;
@@ -271,6 +309,12 @@ function renderProfiles(profiles) {
         }
     });
 
+    container.onclick = async e => {
+        const btn = e.target.closest('.star-btn');
+        if (!btn) return;
+        await toggleStar(btn, btn.closest('.profile-row').dataset.id);
+    };
+
     // Set up compare bar handlers
     const compareBtn = document.getElementById('compare-btn');
     const clearBtn = document.getElementById('clear-selection-btn');
@@ -291,6 +335,44 @@ function renderProfiles(profiles) {
     selection.updateUI();
 }
 
+// Starring bookmarks a profile, to find it again under the Starred link
+function setStarButton(btn, starred) {
+    btn.classList.toggle('starred', !!starred);
+    btn.textContent = starred ? '★' : '☆';
+    btn.title = starred ? 'Unstar' : 'Star';
+}
+
+async function toggleStar(btn, id) {
+    const starred = !btn.classList.contains('starred');
+    try {
+        const response = await fetch(`/api/profiles/${id}/${starred ? 'star' : 'unstar'}`, { method: 'POST' });
+        if (!response.ok) throw new Error('Failed to update profile');
+        setStarButton(btn, starred);
+    } catch (err) {
+        console.error('Failed to star profile:', err);
+    }
+}
+
+function emptyProfileNotice(type) {
+    switch (type) {
+        case 'block':
+            return 'This profile has no samples. Block profiling may be disabled in the target (runtime.SetBlockProfileRate).';
+        case 'mutex':
+            return 'This profile has no samples. Mutex profiling may be disabled in the target (runtime.SetMutexProfileFraction).';
+        case 'cpu':
+            return 'This profile has no samples. The target may have been idle during the capture.';
+        default:
+            return 'This profile has no samples.';
+    }
+}
+
+// Profile types stored as JSON or execution traces rather than pprof protobufs
+const nonPprofProfileTypes = ['k6', 'runtime', 'trace'];
+
+function isPprofType(type) {
+    return !nonPprofProfileTypes.includes(type);
+}
+
 // Profile detail
 async function loadProfile(id) {
     try {
@@ -313,12 +395,20 @@ function renderProfile(profile) {
     document.getElementById('header-profile-project').textContent = profile.project || '';
     document.getElementById('header-profile-time').textContent = formatTime(profile.created_at);
 
+    const starBtn = document.getElementById('star-btn');
+    setStarButton(starBtn, profile.starred);
+    starBtn.onclick = () => toggleStar(starBtn, profile.id);
+
     // Download link at bottom
     const downloadLink = document.getElementById('download-link');
     downloadLink.href = `/api/profiles/${profile.id}?raw=true`;
     // Update download link text based on profile type
     if (profile.profile_type === 'k6') {
         downloadLink.textContent = 'Download raw data (summary.json)';
+    } else if (profile.profile_type === 'runtime') {
+        downloadLink.textContent = 'Download raw data (runtime.json)';
+    } else if (profile.profile_type === 'trace') {
+        downloadLink.textContent = 'Download raw trace (open with go tool trace)';
     } else {
         downloadLink.textContent = 'Download raw profile (.pb.gz)';
     }
@@ -329,14 +419,34 @@ function renderProfile(profile) {
         document.getElementById('session-item').hidden = false;
         document.getElementById('profile-session').textContent = profile.session;
     }
+    if (profile.window_ns) {
+        document.getElementById('window-item').hidden = false;
+        document.getElementById('profile-window').textContent = formatDuration(profile.window_ns);
+    }
+
+    // Target's runtime context at capture time, from ctx.* labels
+    const context = (profile.tags || [])
+        .filter(t => t.startsWith('ctx.'))
+        .map(t => t.slice('ctx.'.length).replace('=', ' '));
+    if (context.length > 0) {
+        document.getElementById('context-item').hidden = false;
+        document.getElementById('profile-context').textContent = context.join(' · ');
+    }
+
+    // Explain all-zero metrics for profiles ingested without samples
+    if (profile.tags?.includes('empty')) {
+        const notice = document.getElementById('profile-notice');
+        notice.textContent = emptyProfileNotice(profile.profile_type);
+        notice.hidden = false;
+    }
 
     // Type-specific metrics
     renderTypeMetrics(profile);
 
-    // pprof commands (only for pprof profiles, not k6)
+    // pprof commands (only for pprof profiles, not JSON or traces)
     const pprofCommandSection = document.querySelector('.pprof-command');
-    if (profile.profile_type === 'k6') {
-        // Hide pprof commands for k6 profiles
+    if (!isPprofType(profile.profile_type)) {
+        // Hide pprof commands for JSON profiles
         pprofCommandSection.hidden = true;
     } else {
         // Show pprof commands for pprof profiles
@@ -373,6 +483,8 @@ function renderTypeMetrics(profile) {
         case 'cpu':
             cards = [
                 { label: 'CPU Time', value: formatDuration(m.total_cpu_time_ns) },
+                { label: 'Wall Time', value: formatDuration(m.wall_duration_ns) },
+                { label: 'Parallelism', value: m.parallelism ? `${m.parallelism.toFixed(2)}×` : '—' },
                 { label: 'Samples', value: formatNumber(m.sample_count) },
                 { label: 'Size', value: formatSize(profile.raw_size) },
             ];
@@ -380,6 +492,18 @@ function renderTypeMetrics(profile) {
             topTitle = 'Top Functions by CPU';
             break;
 
+        case 'wall':
+            cards = [
+                { label: 'Wall Time', value: formatDuration(m.total_wall_time_ns) },
+                { label: 'Duration', value: formatDuration(m.wall_duration_ns) },
+                { label: 'Concurrency', value: m.concurrency ? `${m.concurrency.toFixed(2)}×` : '—' },
+                { label: 'Samples', value: formatNumber(m.sample_count) },
+                { label: 'Size', value: formatSize(profile.raw_size) },
+            ];
+            topItems = m.top_functions || [];
+            topTitle = 'Top Functions by Wall Time';
+            break;
+
         case 'heap':
             cards = [
                 { label: 'Alloc Size', value: formatBytes(m.alloc_size) },
@@ -391,6 +515,17 @@ function renderTypeMetrics(profile) {
             topTitle = 'Top Allocators';
             break;
 
+        case 'allocs':
+            cards = [
+                { label: 'Alloc Size', value: formatBytes(m.alloc_size) },
+                { label: 'Alloc Objects', value: formatNumber(m.alloc_objects) },
+                { label: 'Avg Object', value: m.alloc_objects ? formatBytes(Math.round(m.alloc_size / m.alloc_objects)) : '—' },
+                { label: 'Size', value: formatSize(profile.raw_size) },
+            ];
+            topItems = m.top_allocators || [];
+            topTitle = 'Top Allocators';
+            break;
+
         case 'mutex':
             cards = [
                 { label: 'Contention Time', value: formatDuration(m.contention_time_ns) },
@@ -433,6 +568,33 @@ function renderTypeMetrics(profile) {
             ];
             break;
 
+        case 'runtime':
+            cards = [
+                { label: 'Heap Inuse', value: formatBytes(m.heap_inuse) },
+                { label: 'Heap Alloc', value: formatBytes(m.heap_alloc) },
+                { label: 'Next GC', value: formatBytes(m.next_gc) },
+                { label: 'GC Cycles', value: formatNumber(m.num_gc) },
+                { label: 'Last GC Pause', value: formatDuration(m.gc_last_pause_ns) },
+                { label: 'Goroutines', value: formatNumber(m.goroutines) },
+            ];
+            break;
+
+        case 'trace':
+            cards = [
+                { label: 'Sched Latency', value: formatDuration(m.sched_latency_ns) },
+                { label: 'GC Time', value: formatDuration(m.gc_time_ns) },
+                { label: 'Syscall Time', value: formatDuration(m.syscall_time_ns) },
+                { label: 'Goroutines', value: formatNumber(m.goroutine_count) },
+                { label: 'Duration', value: formatDuration(m.duration_ns) },
+            ];
+            topItems = (m.top_goroutines || []).map(g => ({
+                name: g.function,
+                value: g.runs,
+                percent: 0
+            }));
+            topTitle = 'Most Scheduled Goroutines';
+            break;
+
         case 'k6':
             cards = [
                 { label: 'P50', value: `${m.p50_ms?.toFixed(1) || '—'}ms` },
@@ -445,11 +607,14 @@ function renderTypeMetrics(profile) {
             break;
 
         default:
+            // Threadcreate and custom pprof types carry generic metrics
             cards = [
                 { label: 'Samples', value: formatNumber(profile.total_samples) },
-                { label: 'Total Value', value: formatNumber(profile.total_value) },
+                { label: m.sample_type ? `Total ${m.sample_type}` : 'Total Value', value: formatNumber(m.total ?? profile.total_value) },
                 { label: 'Size', value: formatSize(profile.raw_size) },
             ];
+            topItems = m.top_functions || [];
+            topTitle = m.sample_type ? `Top Functions by ${m.sample_type}` : 'Top Functions';
     }
 
     // Render metric cards
@@ -594,6 +759,13 @@ function renderCompare(profiles) {
     const profileType = profiles[0].profile_type;
     document.getElementById('compare-type-label').textContent = profileType;
 
+    const notice = document.getElementById('compare-notice');
+    const samplingWarning = samplingPeriodNotice(profiles);
+    if (notice && samplingWarning) {
+        notice.textContent = samplingWarning;
+        notice.hidden = false;
+    }
+
     // Set up view toggle
     const viewToggle = document.querySelector('.compare-view-toggle');
     viewToggle?.addEventListener('click', e => {
@@ -609,10 +781,88 @@ function renderCompare(profiles) {
         }
     });
 
+    renderCompareChart(profiles);
+    renderStatusMix(profiles);
+
     // Default to table view
     renderTableView(profiles);
 }
 
+// renderCompareChart draws each profile's headline metric as a bar scaled
+// to the largest, where the view has room for a chart
+function renderCompareChart(profiles) {
+    const container = document.getElementById('compare-chart');
+    const metric = getMetricsForType(profiles[0].profile_type)[0];
+    if (!container || !metric) return;
+
+    const values = profiles.map(p => metric.getValue(p.metrics || {}) || 0);
+    const max = Math.max(...values);
+    if (max <= 0) return;
+
+    let html = `<h3>${metric.label}</h3>`;
+    profiles.forEach((p, i) => {
+        html += `<div class="chart-row">
+            <span class="chart-label">${p.name}</span>
+            <span class="chart-bar" style="inline-size: ${(values[i] / max * 100).toFixed(1)}%"></span>
+            <span class="chart-value">${metric.format(values[i])}</span>
+        </div>`;
+    });
+    container.innerHTML = html;
+    container.hidden = false;
+}
+
+// renderStatusMix shows how k6 runs' requests split across status code
+// classes, with each run's shift from the one before in percentage points
+function renderStatusMix(profiles) {
+    const container = document.getElementById('compare-status');
+    const codes = profiles.map(p => p.metrics?.status_codes || {});
+    if (!container || !codes.some(c => Object.keys(c).length)) return;
+
+    const mixes = codes.map(c => {
+        const total = Object.values(c).reduce((a, b) => a + b, 0);
+        const mix = {};
+        for (const [code, n] of Object.entries(c)) {
+            const cls = `${code[0]}xx`;
+            mix[cls] = (mix[cls] || 0) + (total ? n / total : 0);
+        }
+        return mix;
+    });
+    const classes = [...new Set(mixes.flatMap(Object.keys))].sort();
+
+    let html = '<h3>Status Codes</h3>';
+    profiles.forEach((p, i) => {
+        const cells = classes.map(cls => {
+            const share = mixes[i][cls] || 0;
+            let delta = '';
+            if (i > 0) {
+                const pp = (share - (mixes[i - 1][cls] || 0)) * 100;
+                // A growing share of non-2xx responses is a regression
+                const worse = cls === '2xx' ? pp < 0 : pp > 0;
+                if (Math.abs(pp) >= 0.01) {
+                    delta = ` <span class="cell-delta ${worse ? 'delta-regressed' : 'delta-improved'}">${pp > 0 ? '+' : ''}${pp.toFixed(2)}pp</span>`;
+                }
+            }
+            return `${cls} ${(share * 100).toFixed(2)}%${delta}`;
+        });
+        html += `<div class="chart-row">
+            <span class="chart-label">${p.name}</span>
+            <span class="chart-value">${cells.join(' · ')}</span>
+        </div>`;
+    });
+    container.innerHTML = html;
+    container.hidden = false;
+}
+
+// Mutex and block values depend on the runtime sampling rate, so a rate
+// change between captures can look like a contention change
+function samplingPeriodNotice(profiles) {
+    const periods = new Set(profiles
+        .map(p => p.metrics?.sampling_period)
+        .filter(v => v != null));
+    if (periods.size < 2) return null;
+    return `These profiles were captured with different sampling rates (${[...periods].join(', ')}). Differences may reflect the rate change rather than the workload.`;
+}
+
 function renderTableView(profiles) {
     const container = document.getElementById('compare-content');
     const metrics = getMetricsForType(profiles[0].profile_type);
@@ -701,6 +951,12 @@ function getMetricsForType(profileType) {
     const metricsConfig = {
         cpu: [
             { label: 'CPU Time', key: 'total_cpu_time_ns', format: formatDuration, lowerIsBetter: true },
+            { label: 'Parallelism', key: 'parallelism', format: v => v ? `${v.toFixed(2)}×` : '—', lowerIsBetter: false },
+            { label: 'Samples', key: 'sample_count', format: formatNumber, lowerIsBetter: false },
+        ],
+        wall: [
+            { label: 'Wall Time', key: 'total_wall_time_ns', format: formatDuration, lowerIsBetter: true },
+            { label: 'Concurrency', key: 'concurrency', format: v => v ? `${v.toFixed(2)}×` : '—', lowerIsBetter: false },
             { label: 'Samples', key: 'sample_count', format: formatNumber, lowerIsBetter: false },
         ],
         heap: [
@@ -709,6 +965,10 @@ function getMetricsForType(profileType) {
             { label: 'Inuse Size', key: 'inuse_size', format: formatBytes, lowerIsBetter: true },
             { label: 'Inuse Objects', key: 'inuse_objects', format: formatNumber, lowerIsBetter: true },
         ],
+        allocs: [
+            { label: 'Alloc Size', key: 'alloc_size', format: formatBytes, lowerIsBetter: true },
+            { label: 'Alloc Objects', key: 'alloc_objects', format: formatNumber, lowerIsBetter: true },
+        ],
         mutex: [
             { label: 'Contention Time', key: 'contention_time_ns', format: formatDuration, lowerIsBetter: true },
             { label: 'Contentions', key: 'contention_count', format: formatNumber, lowerIsBetter: true },
@@ -725,12 +985,27 @@ function getMetricsForType(profileType) {
             { label: 'Pause Count', key: 'pause_count', format: formatNumber, lowerIsBetter: false },
             { label: 'Heap Goal', key: 'heap_goal', format: formatBytes, lowerIsBetter: false },
         ],
+        runtime: [
+            { label: 'Heap Inuse', key: 'heap_inuse', format: formatBytes, lowerIsBetter: true },
+            { label: 'Heap Alloc', key: 'heap_alloc', format: formatBytes, lowerIsBetter: true },
+            { label: 'GC Cycles', key: 'num_gc', format: formatNumber, lowerIsBetter: true },
+            { label: 'GC Pause Total', key: 'gc_pause_total_ns', format: formatDuration, lowerIsBetter: true },
+            { label: 'Goroutines', key: 'goroutines', format: formatNumber, lowerIsBetter: true },
+        ],
+        trace: [
+            { label: 'Sched Latency', key: 'sched_latency_ns', format: formatDuration, lowerIsBetter: true },
+            { label: 'GC Time', key: 'gc_time_ns', format: formatDuration, lowerIsBetter: true },
+            { label: 'Syscall Time', key: 'syscall_time_ns', format: formatDuration, lowerIsBetter: true },
+            { label: 'Goroutines', key: 'goroutine_count', format: formatNumber, lowerIsBetter: true },
+        ],
         k6: [
             { label: 'P50', key: 'p50_ms', format: v => v ? `${v.toFixed(1)}ms` : '—', lowerIsBetter: true },
             { label: 'P95', key: 'p95_ms', format: v => v ? `${v.toFixed(1)}ms` : '—', lowerIsBetter: true },
             { label: 'P99', key: 'p99_ms', format: v => v ? `${v.toFixed(1)}ms` : '—', lowerIsBetter: true },
             { label: 'RPS', key: 'rps', format: v => v ? v.toFixed(1) : '—', lowerIsBetter: false },
             { label: 'Error Rate', key: 'error_rate', format: v => `${((v || 0) * 100).toFixed(2)}%`, lowerIsBetter: true },
+            { label: 'Bytes / Request', key: 'bytes_per_request', format: v => formatBytes(Math.round(v)), lowerIsBetter: true },
+            { label: 'Errors / Request', key: 'errors_per_request', format: v => v != null ? v.toFixed(4) : '—', lowerIsBetter: true },
         ],
     };
 
@@ -768,5 +1043,12 @@ function calculateDelta(oldVal, newVal, metric) {
     };
 }
 
-// Initialize
-document.addEventListener('DOMContentLoaded', () => router.init());
+// Initialize. An exported comparison page carries its profiles and has
+// no server to route against.
+document.addEventListener('DOMContentLoaded', () => {
+    if (window.exportedProfiles) {
+        renderCompare(window.exportedProfiles);
+    } else {
+        router.init();
+    }
+});
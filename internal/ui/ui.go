@@ -5,10 +5,10 @@ import (
 	"io/fs"
 )
 
-//go:embed static/* fonts/*
+//go:embed static/* fonts/* templates/*
 var embeddedFS embed.FS
 
-// StaticFS returns the static files (HTML, CSS, JS)
+// StaticFS returns the static files (CSS, JS), served as they are
 func StaticFS() fs.FS {
 	sub, _ := fs.Sub(embeddedFS, "static")
 	return sub
@@ -19,3 +19,10 @@ func FontsFS() fs.FS {
 	sub, _ := fs.Sub(embeddedFS, "fonts")
 	return sub
 }
+
+// TemplatesFS returns the HTML page templates. They're kept out of
+// StaticFS, which is served as is, so their source isn't.
+func TemplatesFS() fs.FS {
+	sub, _ := fs.Sub(embeddedFS, "templates")
+	return sub
+}